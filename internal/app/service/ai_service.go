@@ -3,11 +3,18 @@ package service
 import (
 	"bytes"
 	"fmt"
+	"maps"
+	"regexp"
 	"repo-prompt-web/internal/domain/models"
 	"repo-prompt-web/internal/infrastructure/gemini"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
+	"repo-prompt-web/pkg/limiter"
 	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/stats"
 	"repo-prompt-web/pkg/types"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,17 +24,39 @@ import (
 
 // AIService 提供AI相关服务的结构体
 type AIService struct {
-	geminiClient   *gemini.Client
+	llm            LLMClient // 回答问题和生成向量使用的模型客户端
 	cfg            *config.Config
 	sessionHistory map[string]*ConversationContext
+	maxSessions    int              // 最大对话上下文数量，超出时淘汰最久未活跃的上下文
+	promptTemplate string           // 问答提示词模板，为空时使用内置布局
+	limiter        *limiter.Limiter // 与 DeepSeek 调用共享的并发限制，nil 表示不限制
 	mu             sync.RWMutex
 }
 
 // ConversationContext 维护对话上下文的结构体
 type ConversationContext struct {
-	InitialPrompt string            // 初始提示（包含项目信息）
-	Messages      []ConversationMsg // 对话消息记录
-	LastActive    time.Time         // 最后活跃时间
+	InitialPrompt  string               // 初始提示（包含项目信息）
+	BasePrompt     string               // 不含文件内容的初始提示，用于限定文件的提问
+	Instructions   string               // 用户追加到系统提示中的额外要求，对会话后续提问持续生效
+	Exclude        []string             // 构建初始提示时使用的上下文排除规则，变化时重建初始提示
+	Messages       []ConversationMsg    // 对话消息记录
+	LastActive     time.Time            // 最后活跃时间
+	FileEmbeddings map[string][]float32 // 文件向量缓存（启用检索时按需计算）
+	FileSummaries  map[string]string    // 大文件摘要缓存（启用 summarize_large_files 时按需生成）
+	TokensUsed     int                  // 会话累计消耗的 token 数，用于 session_token_budget 限制
+	inUse          int                  // 正在进行的提问数量，大于 0 时不会被淘汰或清理
+	stream         *streamBuffer        // 最近一次流式回答的进度，用于断线后 resume=true 继续读取
+}
+
+// AskOptions 单次提问的可选项
+type AskOptions struct {
+	Files        []string // 非空时只在上下文中放入这些文件，替代默认选取或向量检索
+	Instructions string   // 非空时替换会话的额外要求（如“用要点回答”），追加到系统提示中
+	Citations    bool     // 要求模型以 路径:行号 的格式引用代码，便于提取引用
+	Exclude      []string // 会话的上下文排除规则，匹配的文件内容不放入提示词（文件结构中仍保留）
+	Dedupe       bool     // 重复内容的文件只放入首次出现的一份，其余以对该文件的引用代替
+	// AnswerSchema 非 nil 时要求模型以符合该 JSON Schema 的 JSON 对象回答，仅非流式提问支持
+	AnswerSchema map[string]any
 }
 
 // ConversationMsg 对话消息结构体
@@ -36,13 +65,25 @@ type ConversationMsg struct {
 	Content string // 消息内容
 }
 
-// NewAIService 创建新的AI服务实例
-func NewAIService(cfg *config.Config) *AIService {
+// NewAIService 创建新的AI服务实例，aiLimiter 限制同时进行的 Gemini 请求数，可为 nil；
+// llm 为 nil 时使用 Gemini 客户端单例
+func NewAIService(cfg *config.Config, clients *httpclient.Factory, aiLimiter *limiter.Limiter, llm LLMClient) *AIService {
+	if llm == nil {
+		llm = gemini.GetClient(cfg, clients)
+	}
 	service := &AIService{
-		geminiClient:   gemini.GetClient(cfg),
+		llm:            llm,
 		cfg:            cfg,
 		sessionHistory: make(map[string]*ConversationContext),
+		maxSessions:    cfg.GetMaxSessions(),
+		limiter:        aiLimiter,
+	}
+
+	promptTemplate, err := loadPromptTemplate(cfg.GetPromptTemplateFile())
+	if err != nil {
+		logger.Warn("加载提示词模板失败，使用内置布局", zap.Error(err))
 	}
+	service.promptTemplate = promptTemplate
 
 	// 启动定期清理过期会话的后台任务
 	go service.cleanupExpiredSessions()
@@ -59,7 +100,7 @@ func (s *AIService) cleanupExpiredSessions() {
 		s.mu.Lock()
 		for id, context := range s.sessionHistory {
 			// 2小时不活跃则清理
-			if time.Since(context.LastActive) > 2*time.Hour {
+			if time.Since(context.LastActive) > 2*time.Hour && context.inUse == 0 {
 				delete(s.sessionHistory, id)
 				logger.Debug("清理过期AI会话上下文", zap.String("session_id", id))
 			}
@@ -68,15 +109,116 @@ func (s *AIService) cleanupExpiredSessions() {
 	}
 }
 
+// evictLeastRecentlyUsed 淘汰最久未活跃且不在使用中的对话上下文，调用方需持有写锁
+func (s *AIService) evictLeastRecentlyUsed() bool {
+	var oldestID string
+	var oldest time.Time
+	for id, context := range s.sessionHistory {
+		if context.inUse > 0 {
+			continue
+		}
+		if oldestID == "" || context.LastActive.Before(oldest) {
+			oldestID = id
+			oldest = context.LastActive
+		}
+	}
+	if oldestID == "" {
+		return false
+	}
+
+	delete(s.sessionHistory, oldestID)
+	logger.Info("AI会话上下文数量达到上限，已淘汰最久未活跃的上下文",
+		zap.String("session_id", oldestID),
+		zap.Int("max_sessions", s.maxSessions))
+	return true
+}
+
+// releaseSession 结束一次提问，取消对话上下文的使用中标记
+func (s *AIService) releaseSession(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if context, exists := s.sessionHistory[sessionID]; exists && context.inUse > 0 {
+		context.inUse--
+	}
+}
+
+// ModelInfo 返回回答问题使用的 AI 服务提供方和模型名称
+func (s *AIService) ModelInfo() (provider, model string) {
+	return s.llm.Provider(), s.llm.Model()
+}
+
+// ListModels 使用指定的 API 密钥获取 Gemini 可访问的模型列表，密钥无效时返回 models.ErrInvalidAPIKey
+func (s *AIService) ListModels(apiKey string) ([]string, error) {
+	return s.llm.ListModels(apiKey)
+}
+
+// SessionCount 返回当前对话上下文数量
+func (s *AIService) SessionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sessionHistory)
+}
+
+// sendPrompt 获取并发名额后调用 Gemini，名额已满且排队超时时返回 limiter.ErrBusy
+func (s *AIService) sendPrompt(requestID, prompt string) (string, error) {
+	response, _, err := s.sendPromptWithUsage(requestID, prompt)
+	return response, err
+}
+
+// sendPromptWithUsage 与 sendPrompt 相同，同时返回本次调用消耗的 token 数，用于累计会话用量
+func (s *AIService) sendPromptWithUsage(requestID, prompt string) (string, int, error) {
+	if err := s.limiter.Acquire(); err != nil {
+		logger.Warn("AI 请求排队超时", zap.String("request_id", requestID))
+		return "", 0, err
+	}
+	defer s.limiter.Release()
+	stats.AICall()
+	response, usage, err := s.llm.SendPromptWithUsage(requestID, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	return response, tokenCount(usage, prompt, response), nil
+}
+
+// tokenCount 返回一次调用消耗的 token 数，提供方未返回用量时按每 4 个字节 1 个 token 估算
+func tokenCount(usage *gemini.UsageMetadata, prompt, response string) int {
+	if usage != nil && usage.TotalTokenCount > 0 {
+		return usage.TotalTokenCount
+	}
+	return (len(prompt) + len(response)) / 4
+}
+
+// addTokenUsage 将 tokens 累计到会话的 token 用量中
+func (s *AIService) addTokenUsage(sessionID string, tokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if context, exists := s.sessionHistory[sessionID]; exists {
+		context.TokensUsed += tokens
+	}
+}
+
+// SessionTokensUsed 返回会话累计消耗的 token 数，会话不存在时返回 0
+func (s *AIService) SessionTokensUsed(sessionID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if context, exists := s.sessionHistory[sessionID]; exists {
+		return context.TokensUsed
+	}
+	return 0
+}
+
 // GenerateProjectAnalysis 根据项目文件生成分析结果
-func (s *AIService) GenerateProjectAnalysis(projectInfo string) (string, error) {
+func (s *AIService) GenerateProjectAnalysis(requestID, projectInfo string) (string, error) {
 	// 构建提示语
 	prompt := "请分析以下项目结构和代码，提供一个详细的项目概述、主要功能和组件分析：\n\n" + projectInfo
 
 	// 调用Gemini API
-	response, err := s.geminiClient.SendPrompt(prompt)
+	response, err := s.sendPrompt(requestID, prompt)
 	if err != nil {
-		logger.Error("调用Gemini API生成项目分析失败", zap.Error(err))
+		logger.Error("调用Gemini API生成项目分析失败", zap.String("request_id", requestID), zap.Error(err))
 		return "", err
 	}
 
@@ -84,26 +226,154 @@ func (s *AIService) GenerateProjectAnalysis(projectInfo string) (string, error)
 }
 
 // GenerateCodeExplanation 根据代码生成解释
-func (s *AIService) GenerateCodeExplanation(code string, functionName string) (string, error) {
+func (s *AIService) GenerateCodeExplanation(requestID, code, functionName string) (string, error) {
 	// 构建提示语
 	prompt := "请解释以下" + functionName + "函数的功能、参数和返回值：\n\n" + code
 
 	// 调用Gemini API
-	response, err := s.geminiClient.SendPrompt(prompt)
+	response, err := s.sendPrompt(requestID, prompt)
 	if err != nil {
-		logger.Error("调用Gemini API生成代码解释失败", zap.Error(err))
+		logger.Error("调用Gemini API生成代码解释失败", zap.String("request_id", requestID), zap.Error(err))
 		return "", err
 	}
 
 	return response, nil
 }
 
-// buildInitialPrompt 构建初始化提示（包含代码上下文）
-func (s *AIService) buildInitialPrompt(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis) string {
+// followupCount 追问建议的数量
+const followupCount = 3
+
+// SuggestFollowups 根据问题和回答生成后续追问建议（额外的一次轻量模型调用）
+func (s *AIService) SuggestFollowups(requestID, question, answer string) ([]string, error) {
+	// 回答过长时只保留开头部分，控制额外调用的开销
+	if len(answer) > 4000 {
+		answer = answer[:4000]
+	}
+
+	prompt := fmt.Sprintf(`用户正在询问一个代码库的问题。根据下面的问题和回答，提出 %d 个用户接下来可能想问的简短问题。
+只输出问题本身，每行一个，不要编号，不要其他内容。
+
+## 问题
+%s
+
+## 回答
+%s`, followupCount, question, answer)
+
+	response, err := s.sendPrompt(requestID, prompt)
+	if err != nil {
+		logger.Error("调用Gemini API生成追问建议失败", zap.String("request_id", requestID), zap.Error(err))
+		return nil, err
+	}
+
+	return parseFollowups(response), nil
+}
+
+// listMarkerPattern 匹配行首的列表符号或编号，如 "- "、"1. "、"2、"
+var listMarkerPattern = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.、)）])\s*`)
+
+// parseFollowups 从模型输出中解析追问列表，去除编号和列表符号
+func parseFollowups(text string) []string {
+	var followups []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(listMarkerPattern.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+		followups = append(followups, line)
+		if len(followups) == followupCount {
+			break
+		}
+	}
+	return followups
+}
+
+// maxDefaultContextFiles 未启用检索时放入上下文的最大文件数
+const maxDefaultContextFiles = 10
+
+// maxContextFileSize 上下文中单个文件内容的最大长度
+const maxContextFileSize = 5000
+
+// maxScopedContextSize 提问限定文件时所有文件内容的总长度上限
+const maxScopedContextSize = 200000
+
+// buildInitialPrompt 构建初始化提示（包含代码上下文）。
+// 启用向量检索时不包含文件内容，相关文件在每次提问时单独选取；summaries 中有摘要的大文件用摘要代替截断的内容
+func (s *AIService) buildInitialPrompt(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, instructions string, summaries map[string]string) string {
+	if s.promptTemplate != "" {
+		parts := s.newTemplateParts(result, projectAnalysis, instructions)
+		if !s.cfg.IsEmbeddingsEnabled() {
+			parts.setFiles(s.buildFileSection(result, nil, s.fileBudget(parts.length(s.promptTemplate)), summaries))
+		}
+		return s.renderTemplate(parts)
+	}
+
+	prompt := s.buildBasePrompt(result, projectAnalysis, instructions)
+	if !s.cfg.IsEmbeddingsEnabled() {
+		prompt += s.buildFileSection(result, nil, s.fileBudget(len(prompt)), summaries) + "\n"
+	}
+	return prompt
+}
+
+// fileBudget 返回文件内容段落可用的最大字符数：提示词总长度上限减去 baseLen 和为问题预留的长度，至少为 0
+func (s *AIService) fileBudget(baseLen int) int {
+	budget := s.cfg.GetMaxPromptChars() - baseLen - s.cfg.GetMaxQuestionBytes()
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// trimHistory 从最早的消息开始丢弃，直到 baseLen 加上消息总长度不超过提示词长度上限，
+// 始终保留最后一条消息（本次问题）
+func (s *AIService) trimHistory(baseLen int, messages []ConversationMsg) []ConversationMsg {
+	total := baseLen
+	for _, msg := range messages {
+		total += len(msg.Role) + len(msg.Content)
+	}
+
+	start := 0
+	for total > s.cfg.GetMaxPromptChars() && start < len(messages)-1 {
+		total -= len(messages[start].Role) + len(messages[start].Content)
+		start++
+	}
+	if start > 0 {
+		logger.Warn("提示词超出长度上限，已省略较早的对话历史",
+			zap.Int("max_prompt_chars", s.cfg.GetMaxPromptChars()),
+			zap.Int("dropped_messages", start))
+	}
+	return messages[start:]
+}
+
+// InitialPrompt 返回会话首次提问时发送给模型的初始上下文，便于导出查看或在其他工具中复用。
+// 会话已设置额外要求时一并包含，已缓存的大文件摘要代替截断的内容，匹配 exclude 的文件内容不包含在内，
+// dedupe 为 true 时重复内容的文件只包含一次
+func (s *AIService) InitialPrompt(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, sessionID string, exclude []string, dedupe bool) string {
+	s.mu.RLock()
+	var instructions string
+	var summaries map[string]string
+	if context, exists := s.sessionHistory[sessionID]; exists {
+		instructions = context.Instructions
+		summaries = maps.Clone(context.FileSummaries)
+	}
+	s.mu.RUnlock()
+
+	result = excludeContextFiles(result, exclude)
+	if dedupe {
+		result = dedupeContextFiles(result)
+	}
+	return s.buildInitialPrompt(result, projectAnalysis, instructions, summaries)
+}
+
+// buildBasePrompt 构建不含文件内容的初始提示：系统提示（含用户额外要求）、项目架构分析和文件结构
+func (s *AIService) buildBasePrompt(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, instructions string) string {
 	promptBuilder := &StringBuilder{}
 
 	// 添加系统提示
 	promptBuilder.AppendLine("你是一位代码分析助手，正在分析一个代码库并回答关于代码的问题。请基于以下代码库的内容和项目架构分析来回答问题。")
+	if instructions != "" {
+		promptBuilder.AppendLine("\n## 回答要求")
+		promptBuilder.AppendLine(instructions)
+	}
 
 	// 添加项目分析
 	if projectAnalysis != nil && len(projectAnalysis.PromptSuggestions) > 0 {
@@ -115,58 +385,169 @@ func (s *AIService) buildInitialPrompt(result *types.ProcessResult, projectAnaly
 	promptBuilder.AppendLine("\n## 文件结构")
 	if result.FileTree != nil {
 		buffer := &bytes.Buffer{}
-		result.FileTree.Print(buffer, "", true)
+		result.FileTree.PrintDepth(buffer, "", true, s.cfg.GetTreeMaxDepth())
 		promptBuilder.AppendLine(buffer.String())
 	}
 
-	// 添加文件内容 (最多10个文件，并限制大小)
-	promptBuilder.AppendLine("\n## 文件内容")
-	fileCount := 0
+	return promptBuilder.String()
+}
+
+// defaultContextFiles 按路径顺序选取前 maxDefaultContextFiles 个文本文件，用于未指定文件且未启用检索时的上下文。
+// 选取结果固定，同一会话中按路径缓存的摘要始终对应相同的文件
+func defaultContextFiles(result *types.ProcessResult) []string {
+	paths := make([]string, 0, len(result.FileContents))
 	for path, content := range result.FileContents {
-		if fileCount >= 10 {
-			break
-		}
 		// 跳过二进制内容
-		if content.IsBase64 {
-			continue
+		if !content.IsBase64 {
+			paths = append(paths, path)
 		}
+	}
+	sort.Strings(paths)
+	if len(paths) > maxDefaultContextFiles {
+		paths = paths[:maxDefaultContextFiles]
+	}
+	return paths
+}
+
+// buildFileSection 构建文件内容段落，paths 为空时按默认规则选取前若干个文件。
+// 超出单文件长度上限的文件在 summaries 中有摘要时使用摘要，否则截断。
+// 段落长度超过 budget 时按 paths 顺序从后往前省略放不下的文件并记录日志
+func (s *AIService) buildFileSection(result *types.ProcessResult, paths []string, budget int, summaries map[string]string) string {
+	promptBuilder := &StringBuilder{}
+	promptBuilder.AppendLine("\n## 文件内容")
+
+	if paths == nil {
+		paths = defaultContextFiles(result)
+	}
+
+	var dropped []string
+	for _, path := range paths {
+		content := result.FileContents[path]
 
 		// 限制每个文件内容大小
+		var block string
+		if summary, ok := summaries[path]; ok && len(content.Content) > maxContextFileSize {
+			block = summaryBlock(path, len(content.Content), summary)
+		} else {
+			fileContent := content.Content
+			if len(fileContent) > maxContextFileSize {
+				fileContent = fileContent[:maxContextFileSize] + "...(内容已截断)"
+			}
+			block = s.fileBlock(path, fileContent)
+		}
+
+		if promptBuilder.Len()+len(block) > budget {
+			dropped = append(dropped, path)
+			continue
+		}
+		promptBuilder.Append(block)
+	}
+
+	if len(dropped) > 0 {
+		logger.Warn("提示词超出长度上限，已省略部分文件",
+			zap.Int("budget", budget),
+			zap.Strings("dropped", dropped))
+	}
+	return promptBuilder.String()
+}
+
+// fileBlock 构建单个文件的标题和代码块
+func (s *AIService) fileBlock(path, content string) string {
+	return "\n### " + path + "\n```" + s.cfg.LanguageForPath(path) + "\n" + content + "\n```\n"
+}
+
+// buildScopedFileSection 构建用户指定文件的内容段落，文件内容不做单独截断，
+// 只在总长度超过 maxScopedContextSize 或 budget 时截断剩余内容
+func (s *AIService) buildScopedFileSection(result *types.ProcessResult, paths []string, budget int) string {
+	promptBuilder := &StringBuilder{}
+	promptBuilder.AppendLine("\n## 文件内容")
+
+	remaining := maxScopedContextSize
+	if budget < remaining {
+		remaining = budget
+	}
+	for _, path := range paths {
+		content, ok := result.FileContents[path]
+		if !ok || content.IsBase64 {
+			continue
+		}
+
 		fileContent := content.Content
-		if len(fileContent) > 5000 {
-			fileContent = fileContent[:5000] + "...(内容已截断)"
+		if remaining <= 0 {
+			fileContent = "...(超出上下文长度限制，内容已省略)"
+		} else if len(fileContent) > remaining {
+			fileContent = fileContent[:remaining] + "...(内容已截断)"
 		}
+		remaining -= len(content.Content)
 
 		promptBuilder.AppendLine("\n### " + path)
-		promptBuilder.AppendLine("```")
+		promptBuilder.AppendLine("```" + s.cfg.LanguageForPath(path))
 		promptBuilder.AppendLine(fileContent)
 		promptBuilder.AppendLine("```")
-		fileCount++
 	}
 
 	return promptBuilder.String()
 }
 
-// AskQuestionAboutCode 询问关于代码的问题
-func (s *AIService) AskQuestionAboutCode(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, question string, sessionID string) (string, error) {
+// preparePrompt 更新会话历史并构建本次提问的完整提示词，并将对话上下文标记为使用中，
+// 调用方需在提问结束后调用 releaseSession
+func (s *AIService) preparePrompt(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, question, sessionID, requestID string, opts AskOptions) (string, error) {
+	files := opts.Files
+	result = excludeContextFiles(result, opts.Exclude)
+	if opts.Dedupe {
+		result = dedupeContextFiles(result)
+	}
+
 	s.mu.Lock()
 
 	// 检查是否有现有会话
 	context, exists := s.sessionHistory[sessionID]
 	if !exists {
+		if s.maxSessions > 0 && len(s.sessionHistory) >= s.maxSessions && !s.evictLeastRecentlyUsed() {
+			s.mu.Unlock()
+			logger.Warn("AI会话上下文数量已达上限",
+				zap.String("request_id", requestID),
+				zap.String("session_id", sessionID),
+				zap.Int("max_sessions", s.maxSessions))
+			return "", models.ErrTooManySessions
+		}
+
 		// 创建新会话
-		initialPrompt := s.buildInitialPrompt(result, projectAnalysis)
 		context = &ConversationContext{
-			InitialPrompt: initialPrompt,
+			InitialPrompt: s.buildInitialPrompt(result, projectAnalysis, opts.Instructions, nil),
+			BasePrompt:    s.buildBasePrompt(result, projectAnalysis, opts.Instructions),
+			Instructions:  opts.Instructions,
+			Exclude:       opts.Exclude,
 			Messages:      []ConversationMsg{},
 			LastActive:    time.Now(),
 		}
 		s.sessionHistory[sessionID] = context
-		logger.Debug("创建新的AI会话上下文", zap.String("session_id", sessionID))
+		logger.Debug("创建新的AI会话上下文", zap.String("request_id", requestID), zap.String("session_id", sessionID))
+	} else if (opts.Instructions != "" && opts.Instructions != context.Instructions) || !slices.Equal(opts.Exclude, context.Exclude) {
+		// 额外要求或上下文排除规则变化时重建初始提示
+		if opts.Instructions != "" {
+			context.Instructions = opts.Instructions
+		}
+		context.Exclude = opts.Exclude
+		context.InitialPrompt = s.buildInitialPrompt(result, projectAnalysis, context.Instructions, nil)
+		context.BasePrompt = s.buildBasePrompt(result, projectAnalysis, context.Instructions)
+		logger.Debug("更新AI会话的额外要求或上下文排除规则", zap.String("request_id", requestID), zap.String("session_id", sessionID))
+	}
+
+	// 累计用量已达到预算时拒绝继续提问，正在进行的提问结束后才计入用量
+	if budget := s.cfg.GetSessionTokenBudget(); budget > 0 && context.TokensUsed >= budget {
+		s.mu.Unlock()
+		logger.Warn("会话的 token 用量已超出预算",
+			zap.String("request_id", requestID),
+			zap.String("session_id", sessionID),
+			zap.Int("tokens_used", context.TokensUsed),
+			zap.Int("budget", budget))
+		return "", models.ErrTokenBudgetExceeded
 	}
 
 	// 更新最后活跃时间
 	context.LastActive = time.Now()
+	context.inUse++
 
 	// 添加用户问题到会话历史
 	context.Messages = append(context.Messages, ConversationMsg{
@@ -174,125 +555,173 @@ func (s *AIService) AskQuestionAboutCode(result *types.ProcessResult, projectAna
 		Content: question,
 	})
 
+	initialPrompt := context.InitialPrompt
+	basePrompt := context.BasePrompt
+	instructions := context.Instructions
+	messages := append([]ConversationMsg(nil), context.Messages...)
+	s.mu.Unlock()
+
+	if s.promptTemplate != "" {
+		return s.prepareTemplatePrompt(context, result, projectAnalysis, question, sessionID, requestID, instructions, files, messages), nil
+	}
+
+	if len(files) > 0 {
+		// 用户指定了相关文件
+		initialPrompt = basePrompt + s.buildScopedFileSection(result, files, s.fileBudget(len(basePrompt)))
+		logger.Debug("使用指定文件作为上下文",
+			zap.String("request_id", requestID),
+			zap.String("session_id", sessionID),
+			zap.Strings("files", files))
+	} else if s.cfg.IsEmbeddingsEnabled() {
+		// 启用向量检索时按问题选取相关文件
+		paths := s.selectRelevantFiles(context, result, question, sessionID, requestID)
+		summaries := s.summarizeLargeFiles(context, result, paths, sessionID, requestID)
+		initialPrompt += s.buildFileSection(result, paths, s.fileBudget(len(initialPrompt)), summaries)
+	} else if s.cfg.IsSummarizeLargeFilesEnabled() {
+		// 大文件摘要在锁外生成并按会话缓存，生成后用摘要重建初始提示
+		initialPrompt = s.buildInitialPrompt(result, projectAnalysis, instructions, s.summarizeLargeFiles(context, result, nil, sessionID, requestID))
+	}
+
 	// 构建完整提示词
 	var prompt string
-	if len(context.Messages) <= 1 {
+	if len(messages) <= 1 {
 		// 首次提问，包含完整代码上下文
-		prompt = context.InitialPrompt + "\n\n## 问题\n" + question
+		prompt = initialPrompt + "\n\n## 问题\n" + question
 		logger.Debug("首次提问，使用完整代码上下文",
+			zap.String("request_id", requestID),
 			zap.String("session_id", sessionID),
 			zap.Int("prompt_length", len(prompt)))
 	} else {
 		// 后续提问，仅包含对话历史
 		promptBuilder := &StringBuilder{}
-		promptBuilder.AppendLine(context.InitialPrompt)
+		promptBuilder.AppendLine(initialPrompt)
 		promptBuilder.AppendLine("\n## 对话历史")
 
-		// 只保留最近10次对话
-		startIdx := 0
-		if len(context.Messages) > 10 {
-			startIdx = len(context.Messages) - 10
+		// 只保留最近10次对话，超出提示词长度上限时继续省略较早的对话
+		if len(messages) > 10 {
+			messages = messages[len(messages)-10:]
 		}
-
-		for i := startIdx; i < len(context.Messages); i++ {
-			msg := context.Messages[i]
+		for _, msg := range s.trimHistory(len(initialPrompt), messages) {
 			promptBuilder.AppendLine("\n" + msg.Role + ": " + msg.Content)
 		}
 
 		prompt = promptBuilder.String()
 		logger.Debug("后续提问，使用对话历史",
+			zap.String("request_id", requestID),
 			zap.String("session_id", sessionID),
-			zap.Int("message_count", len(context.Messages)),
+			zap.Int("message_count", len(messages)),
 			zap.Int("prompt_length", len(prompt)))
 	}
 
-	s.mu.Unlock()
+	return prompt, nil
+}
 
-	// 打印发送给Gemini的内容
-	fmt.Println("\n===== 发送给Gemini的内容开始 =====")
-	fmt.Println(prompt)
-	fmt.Println("===== 发送给Gemini的内容结束 =====")
+// prepareTemplatePrompt 使用配置的模板构建本次提问的提示词，文件选取规则与内置布局相同
+func (s *AIService) prepareTemplatePrompt(context *ConversationContext, result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, question, sessionID, requestID, instructions string, files []string, messages []ConversationMsg) string {
+	parts := s.newTemplateParts(result, projectAnalysis, instructions)
+	budget := s.fileBudget(parts.length(s.promptTemplate))
+	if len(files) > 0 {
+		parts.setFiles(s.buildScopedFileSection(result, files, budget))
+	} else if s.cfg.IsEmbeddingsEnabled() {
+		paths := s.selectRelevantFiles(context, result, question, sessionID, requestID)
+		parts.setFiles(s.buildFileSection(result, paths, budget, s.summarizeLargeFiles(context, result, paths, sessionID, requestID)))
+	} else {
+		parts.setFiles(s.buildFileSection(result, nil, budget, s.summarizeLargeFiles(context, result, nil, sessionID, requestID)))
+	}
 
-	// 调用Gemini API
-	response, err := s.geminiClient.SendPrompt(prompt)
-	if err != nil {
-		logger.Error("调用Gemini API回答代码问题失败", zap.Error(err))
-		return "", err
+	// 与内置布局一致，只保留最近10次对话并受提示词长度上限约束，最后一条为本次问题
+	if len(messages) > 10 {
+		messages = messages[len(messages)-10:]
 	}
+	messages = s.trimHistory(parts.length(s.promptTemplate), messages)
+	parts.History = formatHistory(messages[:len(messages)-1])
+	parts.Question = question
+	prompt := s.renderTemplate(parts)
+	logger.Debug("使用提示词模板构建提示",
+		zap.String("request_id", requestID),
+		zap.String("session_id", sessionID),
+		zap.Int("message_count", len(messages)),
+		zap.Int("prompt_length", len(prompt)))
+	return prompt
+}
 
-	// 添加回复到会话历史
+// appendAssistantMessage 将模型回复添加到会话历史
+func (s *AIService) appendAssistantMessage(sessionID, response string) {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if context, exists := s.sessionHistory[sessionID]; exists {
 		context.Messages = append(context.Messages, ConversationMsg{
 			Role:    "assistant",
 			Content: response,
 		})
 	}
-	s.mu.Unlock()
-
-	return response, nil
 }
 
-// AskQuestionAboutCodeStream 流式询问关于代码的问题
-func (s *AIService) AskQuestionAboutCodeStream(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, question string, sessionID string) (<-chan gemini.StreamChunk, error) {
-	s.mu.Lock()
+// AskQuestionAboutCode 询问关于代码的问题。opts.AnswerSchema 非 nil 时要求以 JSON 回答，
+// 回答未通过校验时附上错误原因重试一次，仍不通过时返回最后一次的原始回答，由调用方决定如何处理
+func (s *AIService) AskQuestionAboutCode(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, question, sessionID, requestID string, opts AskOptions) (string, error) {
+	prompt, err := s.preparePrompt(result, projectAnalysis, question, sessionID, requestID, opts)
+	if err != nil {
+		return "", err
+	}
+	defer s.releaseSession(sessionID)
+	prompt = appendJSONInstruction(appendCitationInstruction(prompt, opts), opts)
 
-	// 检查是否有现有会话
-	context, exists := s.sessionHistory[sessionID]
-	if !exists {
-		// 创建新会话
-		initialPrompt := s.buildInitialPrompt(result, projectAnalysis)
-		context = &ConversationContext{
-			InitialPrompt: initialPrompt,
-			Messages:      []ConversationMsg{},
-			LastActive:    time.Now(),
-		}
-		s.sessionHistory[sessionID] = context
-		logger.Debug("创建新的AI会话上下文（流式）", zap.String("session_id", sessionID))
+	// 打印发送给Gemini的内容
+	fmt.Println("\n===== 发送给Gemini的内容开始 =====")
+	fmt.Println(prompt)
+	fmt.Println("===== 发送给Gemini的内容结束 =====")
+
+	// 调用Gemini API
+	response, tokens, err := s.sendPromptWithUsage(requestID, prompt)
+	if err != nil {
+		logger.Error("调用Gemini API回答代码问题失败", zap.String("request_id", requestID), zap.Error(err))
+		return "", err
 	}
 
-	// 更新最后活跃时间
-	context.LastActive = time.Now()
+	if opts.AnswerSchema != nil {
+		if _, parseErr := ParseJSONAnswer(response, opts.AnswerSchema); parseErr != nil {
+			logger.Warn("JSON 回答未通过校验，重试一次",
+				zap.String("request_id", requestID),
+				zap.String("session_id", sessionID),
+				zap.Error(parseErr))
+			retried, retryTokens, err := s.sendPromptWithUsage(requestID, jsonRetryPrompt(prompt, response, parseErr))
+			tokens += retryTokens
+			if err != nil {
+				// 重试失败时保留第一次的回答
+				logger.Warn("重试 JSON 回答失败", zap.String("request_id", requestID), zap.Error(err))
+			} else {
+				response = retried
+			}
+		}
+	}
 
-	// 添加用户问题到会话历史
-	context.Messages = append(context.Messages, ConversationMsg{
-		Role:    "user",
-		Content: question,
-	})
+	// 添加回复到会话历史并累计用量
+	s.appendAssistantMessage(sessionID, response)
+	s.addTokenUsage(sessionID, tokens)
 
-	// 构建完整提示词
-	var prompt string
-	if len(context.Messages) <= 1 {
-		// 首次提问，包含完整代码上下文
-		prompt = context.InitialPrompt + "\n\n## 问题\n" + question
-		logger.Debug("首次提问（流式），使用完整代码上下文",
-			zap.String("session_id", sessionID),
-			zap.Int("prompt_length", len(prompt)))
-	} else {
-		// 后续提问，仅包含对话历史
-		promptBuilder := &StringBuilder{}
-		promptBuilder.AppendLine(context.InitialPrompt)
-		promptBuilder.AppendLine("\n## 对话历史")
+	return response, nil
+}
 
-		// 只保留最近10次对话
-		startIdx := 0
-		if len(context.Messages) > 10 {
-			startIdx = len(context.Messages) - 10
-		}
+// introQuestion 生成会话开场介绍时使用的问题
+const introQuestion = "请用简短的几段话介绍这个项目：它是做什么的、主要的模块和技术栈，以及阅读代码时值得先关注的地方。最后给出 2-3 个可以继续提问的方向。"
 
-		for i := startIdx; i < len(context.Messages); i++ {
-			msg := context.Messages[i]
-			promptBuilder.AppendLine("\n" + msg.Role + ": " + msg.Content)
-		}
+// IntroduceProject 使用会话的初始上下文生成项目开场介绍，介绍及其问题保存在会话历史中，
+// 后续提问可直接基于该对话继续；dedupe 为 true 时重复内容的文件只放入一次
+func (s *AIService) IntroduceProject(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, sessionID, requestID string, dedupe bool) (string, error) {
+	return s.AskQuestionAboutCode(result, projectAnalysis, introQuestion, sessionID, requestID, AskOptions{Dedupe: dedupe})
+}
 
-		prompt = promptBuilder.String()
-		logger.Debug("后续提问（流式），使用对话历史",
-			zap.String("session_id", sessionID),
-			zap.Int("message_count", len(context.Messages)),
-			zap.Int("prompt_length", len(prompt)))
+// AskQuestionAboutCodeStream 流式询问关于代码的问题
+func (s *AIService) AskQuestionAboutCodeStream(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, question, sessionID, requestID string, opts AskOptions) (<-chan gemini.StreamChunk, error) {
+	prompt, err := s.preparePrompt(result, projectAnalysis, question, sessionID, requestID, opts)
+	if err != nil {
+		responseChan := make(chan gemini.StreamChunk)
+		close(responseChan)
+		return responseChan, err
 	}
-
-	s.mu.Unlock()
+	prompt = appendCitationInstruction(prompt, opts)
 
 	// 打印发送给Gemini的内容
 	fmt.Println("\n===== 发送给Gemini的内容开始 =====")
@@ -302,44 +731,70 @@ func (s *AIService) AskQuestionAboutCodeStream(result *types.ProcessResult, proj
 	// 创建响应通道
 	responseChan := make(chan gemini.StreamChunk, 100)
 
+	// 流式响应期间一直占用并发名额，直到流结束
+	if err := s.limiter.Acquire(); err != nil {
+		close(responseChan)
+		s.releaseSession(sessionID)
+		logger.Warn("AI 请求排队超时", zap.String("request_id", requestID))
+		return responseChan, err
+	}
+
 	// 调用Gemini API流式接口
-	streamChan, err := s.geminiClient.SendPromptStream(prompt)
+	stats.AICall()
+	streamChan, err := s.llm.SendPromptStream(requestID, prompt)
 	if err != nil {
+		s.limiter.Release()
 		close(responseChan)
-		logger.Error("流式调用Gemini API回答代码问题失败", zap.Error(err))
+		s.releaseSession(sessionID)
+		logger.Error("流式调用Gemini API回答代码问题失败", zap.String("request_id", requestID), zap.Error(err))
 		return responseChan, err
 	}
 
+	// 记录生成进度，客户端断开后可通过 ResumeAnswerStream 继续读取
+	buffer := newStreamBuffer(question)
+	s.mu.Lock()
+	if context, exists := s.sessionHistory[sessionID]; exists {
+		context.stream = buffer
+	}
+	s.mu.Unlock()
+
 	// 启动goroutine来收集完整响应并保存到会话历史
 	go func() {
 		defer close(responseChan)
+		defer s.releaseSession(sessionID)
+		defer s.limiter.Release()
 
 		// 用于收集完整响应
 		responseBuilder := strings.Builder{}
+		var usage *gemini.UsageMetadata
 
 		for chunk := range streamChan {
 			if chunk.Error != nil {
+				// 已生成的部分回答同样保存到会话历史，后续提问可基于它继续
+				if responseBuilder.Len() > 0 {
+					s.appendAssistantMessage(sessionID, responseBuilder.String()+"\n（回答中断）")
+					s.addTokenUsage(sessionID, tokenCount(usage, prompt, responseBuilder.String()))
+				}
+				buffer.finish(chunk.Error)
 				responseChan <- chunk
 				return
 			}
 
 			// 收集响应
 			responseBuilder.WriteString(chunk.Text)
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			buffer.append(chunk)
 
 			// 转发响应块
 			responseChan <- chunk
 		}
 
-		// 添加完整响应到会话历史
-		completeResponse := responseBuilder.String()
-		s.mu.Lock()
-		if context, exists := s.sessionHistory[sessionID]; exists {
-			context.Messages = append(context.Messages, ConversationMsg{
-				Role:    "assistant",
-				Content: completeResponse,
-			})
-		}
-		s.mu.Unlock()
+		// 添加完整响应到会话历史并累计用量，之后再标记结束，保证继续读取的客户端看到的用量已更新
+		s.appendAssistantMessage(sessionID, responseBuilder.String())
+		s.addTokenUsage(sessionID, tokenCount(usage, prompt, responseBuilder.String()))
+		buffer.finish(nil)
 	}()
 
 	return responseChan, nil
@@ -356,6 +811,16 @@ func (sb *StringBuilder) AppendLine(line string) {
 	sb.builder.WriteString("\n")
 }
 
+// Append 添加文本，不追加换行
+func (sb *StringBuilder) Append(text string) {
+	sb.builder.WriteString(text)
+}
+
+// Len 返回已构建的字节数
+func (sb *StringBuilder) Len() int {
+	return sb.builder.Len()
+}
+
 // String 获取构建的字符串
 func (sb *StringBuilder) String() string {
 	return sb.builder.String()
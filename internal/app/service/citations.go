@@ -0,0 +1,96 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/types"
+)
+
+// citationInstruction 开启引用时追加到提示词末尾的格式要求
+const citationInstruction = "\n\n## 引用要求\n回答中涉及具体代码时，请写出文件的完整路径，能确定位置时附上行号范围，格式为 `路径:起始行-结束行`，例如 `internal/app/main.go:10-25`。"
+
+// citationPattern 匹配回答中的文件路径及可选的行号范围，支持 path:10、path:10-20、path#L10-L20
+var citationPattern = regexp.MustCompile(`([\w.\-/]+)(?:(?::|#L)(\d+)(?:\s*[-–~]\s*L?(\d+))?)?`)
+
+// appendCitationInstruction 开启引用时在提示词末尾追加引用格式要求
+func appendCitationInstruction(prompt string, opts AskOptions) string {
+	if !opts.Citations {
+		return prompt
+	}
+	return prompt + citationInstruction
+}
+
+// ExtractCitations 从回答中提取引用的文件路径和行号范围，只保留会话中存在的文件，按首次出现的顺序去重。
+// 路径可以是完整路径，也可以是能唯一确定文件的路径后缀（如 handlers/file_handler.go）；
+// 行号超出文件范围时只保留路径
+func ExtractCitations(answer string, result *types.ProcessResult) []models.Citation {
+	citations := []models.Citation{}
+	seen := make(map[models.Citation]bool)
+
+	for _, match := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		candidate := strings.TrimPrefix(match[1], "./")
+		path := resolveCitationPath(candidate, result)
+		if path == "" {
+			// 去掉末尾的标点后重试，如 "见 main.go." 中的 "main.go."
+			path = resolveCitationPath(strings.TrimRight(candidate, ".-/"), result)
+		}
+		if path == "" {
+			continue
+		}
+
+		citation := models.Citation{Path: path}
+		if match[2] != "" {
+			start, _ := strconv.Atoi(match[2])
+			end := start
+			if match[3] != "" {
+				end, _ = strconv.Atoi(match[3])
+			}
+			if start >= 1 && end >= start && end <= countLines(result.FileContents[path]) {
+				citation.StartLine = start
+				citation.EndLine = end
+			}
+		}
+
+		if !seen[citation] {
+			seen[citation] = true
+			citations = append(citations, citation)
+		}
+	}
+	return citations
+}
+
+// resolveCitationPath 将回答中的路径解析为会话中的文件路径：优先完全匹配，
+// 其次是包含目录或扩展名且唯一匹配的路径后缀，无法确定时返回空字符串
+func resolveCitationPath(candidate string, result *types.ProcessResult) string {
+	if candidate == "" {
+		return ""
+	}
+	if _, ok := result.FileContents[candidate]; ok {
+		return candidate
+	}
+	if !strings.ContainsAny(candidate, "./") {
+		return ""
+	}
+
+	var found string
+	for path := range result.FileContents {
+		if strings.HasSuffix(path, "/"+candidate) {
+			if found != "" {
+				return ""
+			}
+			found = path
+		}
+	}
+	return found
+}
+
+// countLines 返回文件内容的行数，Base64 内容返回 0
+func countLines(content types.FileContent) int {
+	if content.IsBase64 {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(content.Content, "\n"), "\n") + 1
+}
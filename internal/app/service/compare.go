@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+// CompareInput 参与对比的一个项目
+type CompareInput struct {
+	Name     string                  // 项目名称或来源，如仓库 URL
+	Result   *types.ProcessResult    // 处理结果，用于提供文件结构
+	Analysis *models.ProjectAnalysis // 项目架构分析，可为 nil
+}
+
+// maxCompareDocuments 对比时每个项目放入提示词的最大文档数
+const maxCompareDocuments = 3
+
+// CompareProjects 根据两个项目的架构分析、文档和文件结构生成结构化对比（共同技术、差异、迁移注意事项）。
+// 模型输出无法解析为 JSON 时，原始文本保存在 Raw 中
+func (s *AIService) CompareProjects(requestID string, a, b CompareInput) (*models.ProjectComparison, error) {
+	promptBuilder := &StringBuilder{}
+	promptBuilder.AppendLine("你是一位软件架构师，正在为迁移工作对比两个代码库。请根据下面两个项目的架构分析、文档和文件结构进行对比。")
+	promptBuilder.AppendLine(`只输出一个 JSON 对象，不要输出其他内容，格式如下：
+{"summary": "整体对比结论", "shared_tech": ["共同使用的技术"], "differences": ["主要差异"], "migration_notes": ["从项目 A 迁移到项目 B 的注意事项"]}`)
+	s.appendCompareProject(promptBuilder, "A", a)
+	s.appendCompareProject(promptBuilder, "B", b)
+
+	response, err := s.sendPrompt(requestID, promptBuilder.String())
+	if err != nil {
+		logger.Error("调用Gemini API生成项目对比失败", zap.String("request_id", requestID), zap.Error(err))
+		return nil, err
+	}
+
+	return parseComparison(response), nil
+}
+
+// appendCompareProject 将单个项目的上下文写入对比提示词
+func (s *AIService) appendCompareProject(promptBuilder *StringBuilder, label string, input CompareInput) {
+	promptBuilder.AppendLine(fmt.Sprintf("\n# 项目 %s: %s", label, input.Name))
+
+	if input.Analysis != nil {
+		if len(input.Analysis.PromptSuggestions) > 0 {
+			promptBuilder.AppendLine("\n## 项目架构分析")
+			promptBuilder.AppendLine(input.Analysis.PromptSuggestions[0])
+		}
+		for i, doc := range input.Analysis.Documents {
+			if i == maxCompareDocuments {
+				break
+			}
+			content := doc.Content
+			if len(content) > maxContextFileSize {
+				content = content[:maxContextFileSize] + "\n... (内容已截断)"
+			}
+			promptBuilder.AppendLine(fmt.Sprintf("\n## 文档: %s\n```\n%s\n```", doc.Path, content))
+		}
+	}
+
+	promptBuilder.AppendLine("\n## 文件结构")
+	if input.Result != nil && input.Result.FileTree != nil {
+		buffer := &bytes.Buffer{}
+		input.Result.FileTree.PrintDepth(buffer, "", true, s.cfg.GetTreeMaxDepth())
+		promptBuilder.AppendLine(buffer.String())
+	}
+}
+
+// parseComparison 解析模型输出的 JSON 对比结果，允许外层包裹 ```json 代码块
+func parseComparison(text string) *models.ProjectComparison {
+	trimmed := strings.TrimSpace(text)
+	trimmed = strings.TrimPrefix(trimmed, "```json")
+	trimmed = strings.TrimPrefix(trimmed, "```")
+	trimmed = strings.TrimSuffix(trimmed, "```")
+
+	var comparison models.ProjectComparison
+	if err := json.Unmarshal([]byte(strings.TrimSpace(trimmed)), &comparison); err != nil {
+		return &models.ProjectComparison{Raw: text}
+	}
+	return &comparison
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"sort"
+
+	"repo-prompt-web/pkg/types"
+)
+
+// dedupeContextFiles 返回重复内容（SHA-256 相同）的文件只保留首次出现的处理结果副本：文件按路径排序，
+// 之后的文件内容替换为 "see: <首次出现的路径>"，与文本输出的 dedupe 规则一致。文件树保持不变
+func dedupeContextFiles(result *types.ProcessResult) *types.ProcessResult {
+	paths := make([]string, 0, len(result.FileContents))
+	for filePath := range result.FileContents {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	deduped := *result
+	deduped.FileContents = make(map[string]types.FileContent, len(result.FileContents))
+	firstByHash := make(map[string]string)
+	for _, filePath := range paths {
+		content := result.FileContents[filePath]
+		if content.Hash != "" {
+			if first, ok := firstByHash[content.Hash]; ok {
+				content.Content = "see: " + first
+				content.IsBase64 = false
+			} else {
+				firstByHash[content.Hash] = filePath
+			}
+		}
+		deduped.FileContents[filePath] = content
+	}
+	return &deduped
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"path"
+	"strings"
+
+	"repo-prompt-web/pkg/types"
+)
+
+// IsContextExcluded 检查文件是否匹配会话的上下文排除规则：以 "/" 结尾的规则按目录前缀匹配，
+// 包含通配符的规则匹配完整路径或文件名，其余规则需与路径完全相同
+func IsContextExcluded(filePath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(filePath, pattern) {
+				return true
+			}
+			continue
+		}
+		if strings.ContainsAny(pattern, "*?[") {
+			if matched, _ := path.Match(pattern, filePath); matched {
+				return true
+			}
+			if matched, _ := path.Match(pattern, path.Base(filePath)); matched {
+				return true
+			}
+			continue
+		}
+		if pattern == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeContextFiles 返回去掉匹配 patterns 的文件内容后的处理结果副本，文件树保持不变，
+// 模型仍能看到这些文件的存在；patterns 为空时直接返回原结果
+func excludeContextFiles(result *types.ProcessResult, patterns []string) *types.ProcessResult {
+	if len(patterns) == 0 {
+		return result
+	}
+
+	filtered := *result
+	filtered.FileContents = make(map[string]types.FileContent, len(result.FileContents))
+	for filePath, content := range result.FileContents {
+		if !IsContextExcluded(filePath, patterns) {
+			filtered.FileContents[filePath] = content
+		}
+	}
+	return &filtered
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"repo-prompt-web/internal/infrastructure/anthropic"
+	"repo-prompt-web/internal/infrastructure/gemini"
+)
+
+// LLMClient AIService 调用的大模型客户端，默认实现为 gemini.Client，ai.provider 为 anthropic 时使用 anthropic.Client。
+// 测试时可注入返回固定回答和 StreamChunk 的实现，无需发出网络请求
+type LLMClient interface {
+	// Provider 返回 AI 服务提供方名称
+	Provider() string
+	// Model 返回生成内容使用的模型名称
+	Model() string
+	// SendPrompt 发送提示词并返回完整回答
+	SendPrompt(requestID, prompt string) (string, error)
+	// SendPromptWithUsage 发送提示词并返回完整回答和 token 用量，提供方未返回用量时为 nil
+	SendPromptWithUsage(requestID, prompt string) (string, *gemini.UsageMetadata, error)
+	// SendPromptStream 发送提示词并以通道逐段返回回答，通道在回答结束或出错后关闭
+	SendPromptStream(requestID, prompt string) (<-chan gemini.StreamChunk, error)
+	// EmbedTexts 返回每段文本的向量，顺序与 texts 相同
+	EmbedTexts(requestID string, texts []string) ([][]float32, error)
+	// ListModels 使用指定的 API 密钥获取可访问的模型列表，密钥无效时返回 models.ErrInvalidAPIKey
+	ListModels(apiKey string) ([]string, error)
+}
+
+var (
+	_ LLMClient = (*gemini.Client)(nil)
+	_ LLMClient = (*anthropic.Client)(nil)
+)
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"math"
+	"sort"
+
+	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+// selectRelevantFiles 使用向量相似度选取与问题最相关的 top-k 个文件。
+// 文件向量按会话缓存；检索失败时返回 nil，由调用方回退到默认文件选取
+func (s *AIService) selectRelevantFiles(context *ConversationContext, result *types.ProcessResult, question, sessionID, requestID string) []string {
+	fileEmbeddings, err := s.getFileEmbeddings(context, result, sessionID, requestID)
+	if err != nil {
+		logger.Warn("计算文件向量失败，回退到默认文件选取",
+			zap.String("request_id", requestID),
+			zap.String("session_id", sessionID),
+			zap.Error(err))
+		return nil
+	}
+
+	questionVectors, err := s.llm.EmbedTexts(requestID, []string{question})
+	if err != nil {
+		logger.Warn("计算问题向量失败，回退到默认文件选取",
+			zap.String("request_id", requestID),
+			zap.String("session_id", sessionID),
+			zap.Error(err))
+		return nil
+	}
+	questionVector := questionVectors[0]
+
+	type scoredFile struct {
+		path  string
+		score float64
+	}
+	scored := make([]scoredFile, 0, len(fileEmbeddings))
+	for path, vector := range fileEmbeddings {
+		// 向量按会话缓存，跳过之后被排除出上下文的文件
+		if _, ok := result.FileContents[path]; !ok {
+			continue
+		}
+		scored = append(scored, scoredFile{path: path, score: cosineSimilarity(questionVector, vector)})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].path < scored[j].path
+	})
+
+	topK := s.cfg.GetEmbeddingsTopK()
+	if len(scored) > topK {
+		scored = scored[:topK]
+	}
+
+	paths := make([]string, len(scored))
+	for i, file := range scored {
+		paths[i] = file.path
+	}
+
+	logger.Debug("已选取相关文件",
+		zap.String("request_id", requestID),
+		zap.String("session_id", sessionID),
+		zap.Strings("paths", paths))
+	return paths
+}
+
+// getFileEmbeddings 返回会话缓存的文件向量，未缓存时计算并写入缓存
+func (s *AIService) getFileEmbeddings(context *ConversationContext, result *types.ProcessResult, sessionID, requestID string) (map[string][]float32, error) {
+	s.mu.RLock()
+	cached := context.FileEmbeddings
+	s.mu.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	maxChars := s.cfg.GetEmbeddingsMaxChars()
+	var paths []string
+	var texts []string
+	for path, content := range result.FileContents {
+		// 跳过二进制内容
+		if content.IsBase64 {
+			continue
+		}
+		text := content.Content
+		if len(text) > maxChars {
+			text = text[:maxChars]
+		}
+		paths = append(paths, path)
+		texts = append(texts, path+"\n"+text)
+	}
+
+	vectors, err := s.llm.EmbedTexts(requestID, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings := make(map[string][]float32, len(paths))
+	for i, path := range paths {
+		embeddings[path] = vectors[i]
+	}
+
+	s.mu.Lock()
+	context.FileEmbeddings = embeddings
+	s.mu.Unlock()
+
+	logger.Debug("已缓存文件向量",
+		zap.String("request_id", requestID),
+		zap.String("session_id", sessionID),
+		zap.Int("file_count", len(embeddings)))
+	return embeddings, nil
+}
+
+// cosineSimilarity 计算两个向量的余弦相似度
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
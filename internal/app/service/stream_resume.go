@@ -0,0 +1,108 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/internal/infrastructure/gemini"
+)
+
+// streamBuffer 会话最近一次流式回答已生成的内容。客户端断开后回答仍在后台生成，
+// 重新连接时可从断开的位置继续读取
+type streamBuffer struct {
+	mu           sync.Mutex
+	question     string
+	text         strings.Builder
+	done         bool
+	err          error
+	finishReason string
+	usage        *gemini.UsageMetadata
+	updated      chan struct{} // 每次更新后关闭并替换，用于唤醒等待新内容的读取方
+}
+
+// newStreamBuffer 为问题 question 创建空的回答缓冲
+func newStreamBuffer(question string) *streamBuffer {
+	return &streamBuffer{question: question, updated: make(chan struct{})}
+}
+
+// append 追加模型返回的数据块并唤醒读取方
+func (b *streamBuffer) append(chunk gemini.StreamChunk) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.text.WriteString(chunk.Text)
+	if chunk.FinishReason != "" {
+		b.finishReason = chunk.FinishReason
+	}
+	if chunk.Usage != nil {
+		b.usage = chunk.Usage
+	}
+	b.notify()
+}
+
+// finish 标记回答结束，err 不为 nil 时表示回答中途出错
+func (b *streamBuffer) finish(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.err = err
+	b.notify()
+}
+
+// notify 唤醒等待中的读取方，调用方需持有锁
+func (b *streamBuffer) notify() {
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
+// follow 返回从 offset 字节开始的回答内容：先发送已生成的部分，再随生成进度发送后续数据块，
+// 回答结束时发送带结束原因和用量的空数据块，出错时发送错误，最后关闭通道
+func (b *streamBuffer) follow(offset int) <-chan gemini.StreamChunk {
+	ch := make(chan gemini.StreamChunk)
+	go func() {
+		defer close(ch)
+		for {
+			b.mu.Lock()
+			text := b.text.String()
+			done, err, finishReason, usage := b.done, b.err, b.finishReason, b.usage
+			updated := b.updated
+			b.mu.Unlock()
+
+			if offset < len(text) {
+				ch <- gemini.StreamChunk{Text: text[offset:]}
+				offset = len(text)
+			}
+			if done {
+				if err != nil {
+					ch <- gemini.StreamChunk{Error: err}
+				} else {
+					ch <- gemini.StreamChunk{FinishReason: finishReason, Usage: usage}
+				}
+				return
+			}
+			<-updated
+		}
+	}()
+	return ch
+}
+
+// ResumeAnswerStream 返回会话最近一次流式回答的问题，以及从 offset 字节开始的回答内容通道
+// （offset 为客户端已收到的字节数，即最后一个 message 事件的 ID，超出已生成的长度时按已生成的长度处理，
+// 实际使用的起始位置随之返回）。回答仍在生成时通道随进度继续发送，会话没有流式回答时返回 models.ErrNoStreamToResume
+func (s *AIService) ResumeAnswerStream(sessionID string, offset int) (string, int, <-chan gemini.StreamChunk, error) {
+	s.mu.Lock()
+	context, exists := s.sessionHistory[sessionID]
+	if !exists || context.stream == nil {
+		s.mu.Unlock()
+		return "", 0, nil, models.ErrNoStreamToResume
+	}
+	context.LastActive = time.Now()
+	buffer := context.stream
+	s.mu.Unlock()
+
+	buffer.mu.Lock()
+	offset = min(offset, buffer.text.Len())
+	buffer.mu.Unlock()
+	return buffer.question, offset, buffer.follow(offset), nil
+}
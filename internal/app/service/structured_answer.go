@@ -0,0 +1,167 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultAnswerSchema answer_format=json 且未提供 answer_schema 时使用的 JSON Schema
+const DefaultAnswerSchema = `{
+  "type": "object",
+  "properties": {
+    "answer": { "type": "string", "description": "对问题的完整回答" },
+    "key_points": { "type": "array", "items": { "type": "string" }, "description": "回答的要点" },
+    "files": { "type": "array", "items": { "type": "string" }, "description": "回答涉及的文件路径" }
+  },
+  "required": ["answer"]
+}`
+
+// ParseAnswerSchema 解析 JSON Schema 文本，要求顶层为对象。只使用其中的 type、properties、required、items 和 enum 校验回答
+func ParseAnswerSchema(text string) (map[string]any, error) {
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(text), &schema); err != nil {
+		return nil, fmt.Errorf("answer_schema 不是合法的 JSON 对象: %w", err)
+	}
+	return schema, nil
+}
+
+// appendJSONInstruction 要求 JSON 回答时在提示词末尾追加格式要求和 Schema
+func appendJSONInstruction(prompt string, opts AskOptions) string {
+	if opts.AnswerSchema == nil {
+		return prompt
+	}
+	schema, _ := json.MarshalIndent(opts.AnswerSchema, "", "  ")
+	return prompt + "\n\n## 回答格式\n只输出一个 JSON 对象，不要使用 Markdown 代码块，也不要输出其他内容。JSON 必须符合以下 JSON Schema：\n" + string(schema)
+}
+
+// jsonRetryPrompt 构建 JSON 回答未通过校验时的重试提示，附上上一次的回答和错误原因
+func jsonRetryPrompt(prompt, response string, err error) string {
+	return fmt.Sprintf("%s\n\n## 上一次的回答\n%s\n\n上一次的回答不符合要求：%v。请重新回答，只输出符合上述 JSON Schema 的 JSON 对象。", prompt, response, err)
+}
+
+// ParseJSONAnswer 解析模型的 JSON 回答并按 schema 校验，允许回答被 Markdown 代码块包裹
+func ParseJSONAnswer(answer string, schema map[string]any) (any, error) {
+	text := strings.TrimSpace(answer)
+	if strings.HasPrefix(text, "```") {
+		// 去掉 ```json 所在的首行和结尾的 ```
+		if i := strings.Index(text, "\n"); i >= 0 {
+			text = text[i+1:]
+		}
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, fmt.Errorf("回答不是合法的 JSON: %w", err)
+	}
+	if err := validateJSONValue(value, schema, "$"); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// validateJSONValue 按 schema 校验 value，path 为出错位置，如 $.files[0]
+func validateJSONValue(value any, schema map[string]any, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s 的类型应为 %s", path, strings.Join(types, " 或 "))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		matched := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s 的值不在允许的范围内", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := v[key]; !exists {
+					return fmt.Errorf("%s 缺少必需的字段 %s", path, key)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for key, propSchema := range properties {
+			child, exists := v[key]
+			sub, ok := propSchema.(map[string]any)
+			if !exists || !ok {
+				continue
+			}
+			if err := validateJSONValue(child, sub, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateJSONValue(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaTypes 返回 schema 中 type 的取值，支持单个字符串或字符串数组
+func schemaTypes(t any) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var types []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// jsonTypeMatches 判断 encoding/json 解码出的值是否属于 JSON Schema 类型 t，未知类型视为匹配
+func jsonTypeMatches(value any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
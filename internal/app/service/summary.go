@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+// maxSummaryInputSize 生成摘要时发送给模型的单个文件内容最大长度，超出部分不参与摘要
+const maxSummaryInputSize = 100000
+
+// summarizeLargeFiles 返回 paths 中超出 maxContextFileSize 的文件的摘要，paths 为 nil 时使用默认选取的文件。
+// 摘要按会话缓存，未缓存的文件并发请求模型生成；生成失败的文件不在返回结果中，由 buildFileSection 按原规则截断
+func (s *AIService) summarizeLargeFiles(context *ConversationContext, result *types.ProcessResult, paths []string, sessionID, requestID string) map[string]string {
+	if !s.cfg.IsSummarizeLargeFilesEnabled() {
+		return nil
+	}
+	if paths == nil {
+		paths = defaultContextFiles(result)
+	}
+
+	summaries := make(map[string]string)
+	var missing []string
+	s.mu.RLock()
+	for _, path := range paths {
+		content, ok := result.FileContents[path]
+		if !ok || content.IsBase64 || len(content.Content) <= maxContextFileSize {
+			continue
+		}
+		if summary, cached := context.FileSummaries[path]; cached {
+			summaries[path] = summary
+		} else {
+			missing = append(missing, path)
+		}
+	}
+	s.mu.RUnlock()
+	if len(missing) == 0 {
+		return summaries
+	}
+
+	generated := make([]string, len(missing))
+	tokens := make([]int, len(missing))
+	var wg sync.WaitGroup
+	for i, path := range missing {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			summary, used, err := s.summarizeFile(requestID, path, result.FileContents[path].Content)
+			if err != nil {
+				logger.Warn("生成文件摘要失败，使用截断的内容",
+					zap.String("request_id", requestID),
+					zap.String("session_id", sessionID),
+					zap.String("path", path),
+					zap.Error(err))
+				return
+			}
+			generated[i] = summary
+			tokens[i] = used
+		}(i, path)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	if context.FileSummaries == nil {
+		context.FileSummaries = make(map[string]string)
+	}
+	for i, path := range missing {
+		// 摘要调用同样计入会话的 token 用量
+		context.TokensUsed += tokens[i]
+		if generated[i] == "" {
+			continue
+		}
+		context.FileSummaries[path] = generated[i]
+		summaries[path] = generated[i]
+	}
+	s.mu.Unlock()
+
+	logger.Debug("已生成大文件摘要",
+		zap.String("request_id", requestID),
+		zap.String("session_id", sessionID),
+		zap.Strings("paths", missing))
+	return summaries
+}
+
+// summarizeFile 请求模型为单个文件生成简短摘要，同时返回消耗的 token 数
+func (s *AIService) summarizeFile(requestID, path, content string) (string, int, error) {
+	if len(content) > maxSummaryInputSize {
+		content = content[:maxSummaryInputSize]
+	}
+
+	prompt := fmt.Sprintf(`下面是代码库中的文件 %s，内容过长无法完整放入上下文。请用不超过 %d 个字符概括它：
+文件的用途、主要的类型和函数（附名称）、关键逻辑以及与其他模块的关系。只输出摘要本身。
+
+`+"```"+`%s
+%s
+`+"```", path, maxContextFileSize/2, s.cfg.LanguageForPath(path), content)
+
+	summary, tokens, err := s.sendPromptWithUsage(requestID, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(summary) > maxContextFileSize {
+		summary = summary[:maxContextFileSize] + "...(摘要已截断)"
+	}
+	return summary, tokens, nil
+}
+
+// summaryBlock 构建用摘要代替文件内容时的标题和说明，明确标注内容不是原始代码
+func summaryBlock(path string, size int, summary string) string {
+	return fmt.Sprintf("\n### %s（摘要）\n> 该文件共 %d 字节，超出单文件长度上限，以下是模型生成的摘要，不是原始代码。\n\n%s\n", path, size, summary)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/types"
+)
+
+// fileSectionHeading buildFileSection 和 buildScopedFileSection 生成的段落标题，模板中的 {files} 不包含该标题
+const fileSectionHeading = "\n## 文件内容\n"
+
+// loadPromptTemplate 读取问答提示词模板文件，path 为空时返回空模板（使用内置布局）
+func loadPromptTemplate(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取提示词模板失败: %w", err)
+	}
+	return string(content), nil
+}
+
+// templateParts 渲染提示词模板所用的各部分内容
+type templateParts struct {
+	Instructions string // 用户追加的额外要求
+	Analysis     string // 项目架构分析
+	Tree         string // 文件结构
+	Files        string // 文件内容（不含段落标题）
+	History      string // 本次提问之前的对话历史，首次提问为空
+	Question     string // 本次问题，导出初始提示时为空
+}
+
+// renderTemplate 替换模板中的 {instructions}、{analysis}、{tree}、{files}、{history}、{question} 占位符。
+// 模板未包含 {history} 或 {question} 时，对话历史和问题按内置布局追加在末尾
+func (s *AIService) renderTemplate(parts templateParts) string {
+	replacer := strings.NewReplacer(
+		"{instructions}", parts.Instructions,
+		"{analysis}", parts.Analysis,
+		"{tree}", parts.Tree,
+		"{files}", parts.Files,
+		"{history}", parts.History,
+		"{question}", parts.Question,
+	)
+	prompt := replacer.Replace(s.promptTemplate)
+
+	if parts.History != "" && !strings.Contains(s.promptTemplate, "{history}") {
+		prompt += "\n\n## 对话历史\n" + parts.History
+	}
+	if parts.Question != "" && !strings.Contains(s.promptTemplate, "{question}") {
+		prompt += "\n\n## 问题\n" + parts.Question
+	}
+	return prompt
+}
+
+// newTemplateParts 从处理结果和架构分析构建模板内容，文件内容通过 setFiles 单独设置
+func (s *AIService) newTemplateParts(result *types.ProcessResult, projectAnalysis *models.ProjectAnalysis, instructions string) templateParts {
+	parts := templateParts{Instructions: instructions}
+	if projectAnalysis != nil && len(projectAnalysis.PromptSuggestions) > 0 {
+		parts.Analysis = projectAnalysis.PromptSuggestions[0]
+	}
+	if result.FileTree != nil {
+		buffer := &bytes.Buffer{}
+		result.FileTree.PrintDepth(buffer, "", true, s.cfg.GetTreeMaxDepth())
+		parts.Tree = buffer.String()
+	}
+	return parts
+}
+
+// setFiles 设置文件内容，fileSection 为 buildFileSection 等生成的文件段落
+func (p *templateParts) setFiles(fileSection string) {
+	p.Files = strings.TrimPrefix(fileSection, fileSectionHeading)
+}
+
+// length 估算使用 template 渲染后的长度（不计占位符本身的长度）
+func (p templateParts) length(template string) int {
+	return len(template) + len(p.Instructions) + len(p.Analysis) + len(p.Tree) + len(p.Files) + len(p.History) + len(p.Question)
+}
+
+// formatHistory 将对话消息格式化为 "role: content" 形式，每条之间空一行
+func formatHistory(messages []ConversationMsg) string {
+	lines := make([]string, len(messages))
+	for i, msg := range messages {
+		lines[i] = msg.Role + ": " + msg.Content
+	}
+	return strings.Join(lines, "\n\n")
+}
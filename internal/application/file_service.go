@@ -1,37 +1,123 @@
 package application
 
 import (
+	"fmt"
 	"io"
 	"mime/multipart"
+	"path/filepath"
+	"strings"
 
 	"repo-prompt-web/internal/domain/models"
 	"repo-prompt-web/internal/domain/services"
+	"repo-prompt-web/pkg/config"
 )
 
 // FileService 文件应用服务
 type FileService struct {
 	fileProcessor *services.FileProcessor
+	config        *config.Config
 }
 
 // NewFileService 创建文件应用服务实例
-func NewFileService(fileProcessor *services.FileProcessor) *FileService {
+func NewFileService(fileProcessor *services.FileProcessor, cfg *config.Config) *FileService {
 	return &FileService{
 		fileProcessor: fileProcessor,
+		config:        cfg,
 	}
 }
 
-// ProcessZipFile 处理ZIP文件
-func (s *FileService) ProcessZipFile(file *multipart.FileHeader, useBase64 bool) (*models.ProcessResult, error) {
+// ProcessZipFile 处理上传的ZIP文件，tar 归档（可为 gzip、bzip2 或 xz 压缩）和 gzip 压缩的单个文件同样支持
+func (s *FileService) ProcessZipFile(file *multipart.FileHeader, opts models.ProcessOptions) (*models.ProcessResult, error) {
 	src, err := file.Open()
 	if err != nil {
 		return nil, err
 	}
 	defer src.Close()
 
-	return s.fileProcessor.ProcessZipFile(src.(io.ReaderAt), file.Size, useBase64)
+	return s.fileProcessor.ProcessArchive(src.(io.ReaderAt), file.Size, file.Filename, opts)
 }
 
-// FormatOutput 格式化输出
-func (s *FileService) FormatOutput(result *models.ProcessResult) string {
-	return s.fileProcessor.FormatOutput(result)
+// ProcessZipFiles 处理多个ZIP文件并合并结果，每个归档的文件位于由文件名生成的前缀目录下。
+// 合并后的文件树整体受 max_tree_nodes 限制
+func (s *FileService) ProcessZipFiles(files []*multipart.FileHeader, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	merged := &models.ProcessResult{
+		FileTree:     models.NewRootNode(s.config.GetMaxTreeNodes()),
+		FileContents: make(map[string]models.FileContent),
+	}
+	usedPrefixes := make(map[string]int)
+
+	for _, file := range files {
+		result, err := s.ProcessZipFile(file, opts)
+		if err != nil {
+			return nil, fmt.Errorf("处理 %s 失败: %w", file.Filename, err)
+		}
+
+		prefix := archivePrefix(file.Filename)
+		// 文件名重复时追加序号，避免不同归档的文件互相覆盖
+		usedPrefixes[prefix]++
+		if n := usedPrefixes[prefix]; n > 1 {
+			prefix = fmt.Sprintf("%s-%d", prefix, n)
+		}
+
+		for path, content := range result.FileContents {
+			prefixedPath := prefix + "/" + path
+			content.Path = prefixedPath
+			merged.FileContents[prefixedPath] = content
+		}
+		if result.FileTree != nil {
+			merged.FileTree.AddSubtree(prefix, result.FileTree)
+		}
+		for _, warning := range result.Warnings {
+			warning.Path = prefix + "/" + warning.Path
+			merged.Warnings = append(merged.Warnings, warning)
+		}
+	}
+
+	// 路径加上前缀后重新计算整体哈希
+	merged.UpdateContentHash()
+	return merged, nil
+}
+
+// archivePrefix 根据归档文件名生成目录前缀，如 "my-service.zip" -> "my-service"、"my-service.tar.gz" -> "my-service"
+func archivePrefix(filename string) string {
+	base := filepath.Base(filepath.ToSlash(filename))
+	prefix := strings.TrimSuffix(base, filepath.Ext(base))
+	if strings.EqualFold(filepath.Ext(prefix), ".tar") {
+		prefix = strings.TrimSuffix(prefix, filepath.Ext(prefix))
+	}
+	prefix = strings.Trim(strings.ReplaceAll(prefix, "/", "_"), ". ")
+	if prefix == "" {
+		return "archive"
+	}
+	return prefix
+}
+
+// ProcessZipReader 处理已读取到本地的ZIP数据（如下载的远程文件），name 为 gzip 文件解压后的默认文件名来源
+func (s *FileService) ProcessZipReader(r io.ReaderAt, size int64, name string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	return s.fileProcessor.ProcessArchive(r, size, name, opts)
+}
+
+// ProcessTarStream 顺序读取请求体等流中的 tar 归档（可为 gzip、bzip2 或 xz 压缩），无需先保存到本地
+func (s *FileService) ProcessTarStream(r io.Reader, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	return s.fileProcessor.ProcessTarStream(r, opts)
+}
+
+// FormatOutput 按输出选项格式化文件树和文件内容
+func (s *FileService) FormatOutput(result *models.ProcessResult, opts models.OutputOptions) string {
+	return s.fileProcessor.FormatOutput(result, opts)
+}
+
+// WriteOutput 将格式化输出直接写入 w，避免在内存中构建完整文本
+func (s *FileService) WriteOutput(w io.Writer, result *models.ProcessResult, opts models.OutputOptions) error {
+	return s.fileProcessor.WriteOutput(w, result, opts)
+}
+
+// WriteTree 只将文件树写入 w
+func (s *FileService) WriteTree(w io.Writer, result *models.ProcessResult, opts models.OutputOptions) error {
+	return s.fileProcessor.WriteTree(w, result, opts)
+}
+
+// WriteContents 只将文件内容写入 w
+func (s *FileService) WriteContents(w io.Writer, result *models.ProcessResult, opts models.OutputOptions) error {
+	return s.fileProcessor.WriteContents(w, result, opts)
 }
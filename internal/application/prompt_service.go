@@ -1,19 +1,24 @@
 package application
 
 import (
+	"errors"
+
 	"repo-prompt-web/internal/domain/models"
 	"repo-prompt-web/internal/domain/services"
+	"repo-prompt-web/pkg/limiter"
 )
 
 // PromptService 提示词应用服务
 type PromptService struct {
 	promptGenerator *services.PromptGenerator
+	generatorOpts   services.PromptGeneratorOptions
 }
 
-// NewPromptService 创建提示词应用服务实例
-func NewPromptService(apiKey string) *PromptService {
+// NewPromptService 创建提示词应用服务实例，opts 为忽略文件、重要文件和文档数量限制等配置
+func NewPromptService(apiKey string, opts services.PromptGeneratorOptions) *PromptService {
 	return &PromptService{
-		promptGenerator: services.NewPromptGenerator(apiKey),
+		promptGenerator: services.NewPromptGenerator(apiKey, opts),
+		generatorOpts:   opts,
 	}
 }
 
@@ -33,13 +38,28 @@ func (s *PromptService) GetProjectAnalysis(projectPath string) (*models.ProjectA
 	return &analysis, nil
 }
 
+// GetProjectAnalysisStream 以流式方式生成项目分析，每收到一段分析文本调用一次 onDelta
+func (s *PromptService) GetProjectAnalysisStream(projectPath string, onDelta func(string)) (*models.ProjectAnalysis, error) {
+	contextPrompt, err := s.promptGenerator.ProcessDirectoryContextStream(projectPath, onDelta)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := models.ConvertToProjectAnalysis(*contextPrompt)
+	return &analysis, nil
+}
+
 // GeneratePromptWithApiKey 使用指定的 API 密钥生成提示
 func (s *PromptService) GeneratePromptWithApiKey(request models.PromptRequest) (*models.PromptResponse, error) {
 	// 创建临时生成器使用请求指定的 API 密钥
-	generator := services.NewPromptGenerator(request.ApiKey)
+	generator := services.NewPromptGenerator(request.ApiKey, s.generatorOpts)
 
 	prompt, err := generator.ProcessDirectoryContext(request.ProjectPath)
 	if err != nil {
+		// 排队超时由调用方返回 503，分析内容为空由调用方返回 502，其余错误随响应返回
+		if errors.Is(err, limiter.ErrBusy) || errors.Is(err, models.ErrEmptyAnalysis) {
+			return nil, err
+		}
 		return &models.PromptResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -51,3 +71,8 @@ func (s *PromptService) GeneratePromptWithApiKey(request models.PromptRequest) (
 		Prompt:  *prompt,
 	}, nil
 }
+
+// ListDeepSeekModels 使用指定的 API 密钥获取 DeepSeek 可访问的模型列表，密钥无效时返回 models.ErrInvalidAPIKey
+func (s *PromptService) ListDeepSeekModels(apiKey string) ([]string, error) {
+	return s.promptGenerator.ListDeepSeekModels(apiKey)
+}
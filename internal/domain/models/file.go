@@ -1,9 +1,51 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
 	"repo-prompt-web/pkg/types"
 )
 
+// ErrFileProcessing 表示严格模式（FailOnError）下有文件无法读取或处理
+var ErrFileProcessing = errors.New("部分文件处理失败")
+
+// ErrEmptyRepository 表示 GitHub 仓库没有任何提交
+var ErrEmptyRepository = errors.New("仓库为空，没有任何提交")
+
+// ErrSSORequired 表示 GitHub 令牌需要对仓库所属组织进行 SSO 授权
+var ErrSSORequired = errors.New("GitHub 令牌未对该组织进行 SSO 授权")
+
+// ErrRepoNotFound 表示 GitHub 仓库或分支不存在（私有仓库无访问权限时 GitHub 同样返回 404）
+var ErrRepoNotFound = errors.New("仓库或分支不存在，或令牌无权访问该私有仓库")
+
+// ErrRepoForbidden 表示 GitHub 拒绝访问仓库（令牌无效、权限不足或超出速率限制）
+var ErrRepoForbidden = errors.New("GitHub 拒绝访问该仓库")
+
+// ErrGistNotFound 表示 Gist 不存在（或为无权访问的私密 Gist）
+var ErrGistNotFound = errors.New("Gist 不存在或无权访问")
+
+// ErrTooManySessions 表示会话数量已达上限，且无法淘汰（最久未使用的会话均在使用中）
+var ErrTooManySessions = errors.New("会话数量已达上限且均在使用中，请稍后重试")
+
+// ErrUnsupportedArchive 表示上传的归档或压缩格式不受支持（如 zstd、7z、rar）
+var ErrUnsupportedArchive = errors.New("不支持的归档格式")
+
+// ErrExtractedTooLarge 表示 tar 归档解压后的数据超过 file_limits.max_extracted_size
+var ErrExtractedTooLarge = errors.New("归档解压后的大小超过限制")
+
+// ErrEmptyAnalysis 表示 DeepSeek 多次返回空内容，未能生成架构分析
+var ErrEmptyAnalysis = errors.New("架构分析没有生成任何内容")
+
+// ErrTokenBudgetExceeded 表示会话累计消耗的 token 数已超出 ai.session_token_budget
+var ErrTokenBudgetExceeded = errors.New("会话的 token 用量已超出预算，请重新上传代码创建新会话")
+
+// ErrNoStreamToResume 表示会话中没有可以继续读取的流式回答
+var ErrNoStreamToResume = errors.New("会话中没有可继续读取的流式回答")
+
 // FileContent alias to unified model
 type FileContent = types.FileContent
 
@@ -13,11 +55,139 @@ type TreeNode = types.TreeNode
 // ProcessResult alias to unified model
 type ProcessResult = types.ProcessResult
 
+// FileWarning alias to unified model
+type FileWarning = types.FileWarning
+
+// FileChange alias to unified model
+type FileChange = types.FileChange
+
+// ProcessOptions 控制文件处理行为的选项
+type ProcessOptions struct {
+	UseBase64        bool // 以 base64 编码返回文件内容
+	SkipTests        bool // 跳过测试文件
+	SkipGenerated    bool // 跳过生成的代码
+	FailOnError      bool // 严格模式：任一文件无法读取时返回错误
+	IncludeBinary    bool // 以 base64 保留不超过 max_binary_bytes 的二进制文件，而不是丢弃
+	IncludeSymlinks  bool // 在文件树中以 "name -> target" 保留符号链接（不读取内容），否则跳过
+	FollowSubmodules bool // 获取 GitHub 子模块指向的仓库内容（深度受 github.submodule_max_depth 限制）
+	// IncludeLastModified 为前 github.last_modified_max_files 个 GitHub 文件查询最近一次提交的时间和作者
+	IncludeLastModified bool
+
+	// AutoBase64 只对内容不是合法 UTF-8 的文本文件使用 base64，其余按原文返回，每个文件的 IsBase64 分别标记；UseBase64 为 true 时无效
+	AutoBase64 bool
+
+	// IgnoreFiles 按 gitignore 语法应用的忽略文件名（如 .dockerignore），在归档任意目录中出现均生效
+	IgnoreFiles []string
+
+	// ExcludeDirs 本次请求额外排除的目录前缀（以 "/" 结尾，相对项目根目录），在配置的 excluded_dir_prefixes 之外生效
+	ExcludeDirs []string
+
+	// ExtraTextExtensions 本次请求额外视为文本的扩展名（小写、以 "." 开头），与配置的 text_extensions 一起生效，
+	// 并覆盖 excluded_extensions 中的同名扩展名
+	ExtraTextExtensions []string
+
+	// LineEndings 文本文件的换行符处理：keep（默认，保持原样）、lf 或 crlf
+	LineEndings string
+
+	// MaxFileSize 本次请求的单个文件大小上限（字节），0 表示使用配置的 max_file_size，只能比配置值更小
+	MaxFileSize int64
+
+	// Progress 获取 GitHub 仓库文件时每处理完一个文件调用一次，为 nil 时不报告进度
+	Progress ProgressFunc
+}
+
+// FileSizeLimit 返回本次处理的单个文件大小上限：请求指定了更小的 MaxFileSize 时使用请求值，否则使用配置值 configured
+func (o ProcessOptions) FileSizeLimit(configured int64) int64 {
+	if o.MaxFileSize > 0 && o.MaxFileSize < configured {
+		return o.MaxFileSize
+	}
+	return configured
+}
+
+// FetchProgress 获取仓库文件的进度
+type FetchProgress struct {
+	Fetched int    `json:"fetched"` // 已处理的文件数（含获取失败和被跳过的文件）
+	Total   int    `json:"total"`   // 需要获取内容的文件总数
+	Current string `json:"current"` // 刚处理完的文件路径
+}
+
+// ProgressFunc 接收获取进度的回调，在处理请求的协程中同步调用
+type ProgressFunc func(FetchProgress)
+
+// 换行符处理方式
+const (
+	LineEndingsKeep = "keep"
+	LineEndingsLF   = "lf"
+	LineEndingsCRLF = "crlf"
+)
+
+// IsExcludedDir 检查文件是否位于本次请求排除的目录下
+func (o ProcessOptions) IsExcludedDir(filePath string) bool {
+	for _, prefix := range o.ExcludeDirs {
+		if strings.HasPrefix(filePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExtraTextFile 检查文件扩展名是否在本次请求额外指定的文本扩展名中（不区分大小写）
+func (o ProcessOptions) IsExtraTextFile(filePath string) bool {
+	if len(o.ExtraTextExtensions) == 0 {
+		return false
+	}
+	return slices.Contains(o.ExtraTextExtensions, strings.ToLower(filepath.Ext(filePath)))
+}
+
+// ExcludeDirsUnder 返回位于 dir 下的排除目录，路径改为相对 dir，用于处理子模块等嵌套的仓库
+func (o ProcessOptions) ExcludeDirsUnder(dir string) []string {
+	var dirs []string
+	for _, prefix := range o.ExcludeDirs {
+		if rel := strings.TrimPrefix(prefix, dir+"/"); rel != prefix && rel != "" {
+			dirs = append(dirs, rel)
+		}
+	}
+	return dirs
+}
+
+// OutputOptions 控制文本输出格式的选项
+type OutputOptions struct {
+	TreeMaxDepth  int    // 文件树最大渲染深度，0 表示不限制
+	TreeHeader    string // 文件树之前的内容
+	ContentHeader string // 文件树与文件内容之间的分隔
+	FileHeader    string // 每个文件之前的标题模板，支持 {path}、{size}、{language}
+	FileFooter    string // 每个文件之后的内容
+	// Dedupe 内容相同（SHA-256 相同）的文件只输出一次内容，之后的文件以 "see: <首次出现的路径>" 代替内容；
+	// 创建的会话在 AI 上下文中按同样的规则去重
+	Dedupe bool
+}
+
+// NewFileProcessingError 根据警告列表构造严格模式下的错误
+func NewFileProcessingError(warnings []FileWarning) error {
+	first := warnings[0]
+	return fmt.Errorf("%w: 共 %d 个文件，首个失败文件 %s: %s", ErrFileProcessing, len(warnings), first.Path, first.Reason)
+}
+
 // NewTreeNode alias to unified function
 func NewTreeNode(name string, isDir bool) *TreeNode {
 	return types.NewTreeNode(name, isDir)
 }
 
+// NewRootNode alias to unified function
+func NewRootNode(maxNodes int) *TreeNode {
+	return types.NewRootNode(maxNodes)
+}
+
+// HashContent alias to unified function
+func HashContent(content []byte) string {
+	return types.HashContent(content)
+}
+
+// FormatSize alias to unified function
+func FormatSize(size int64) string {
+	return types.FormatSize(size)
+}
+
 // AddPathToTree is a helper function that wraps the TreeNode.AddPath method
 func AddPathToTree(node *TreeNode, path string) {
 	if node != nil {
@@ -1,20 +1,44 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"repo-prompt-web/pkg/types"
 )
 
+// ErrInvalidAPIKey 表示 AI 服务提供方拒绝了 API 密钥（密钥无效、已停用或无权访问）
+var ErrInvalidAPIKey = errors.New("API 密钥无效或无权访问")
+
 // Document alias to unified model
 type Document = types.Document
 
 // ContextPrompt 表示生成的上下文提示
 type ContextPrompt struct {
-	DirectoryStructure string     // 目录结构
-	Documents          []Document // 文档集合
-	PromptSuggestions  []string   // 提示词建议
-	GeneratedAt        time.Time  // 生成时间
+	DirectoryStructure string           // 目录结构
+	Documents          []Document       // 文档集合
+	PromptSuggestions  []string         // 提示词建议
+	LanguageStats      map[string]int64 // 各语言的文件总字节数
+	PrimaryLanguage    string           // 字节数最多的编程语言，未识别时为空
+	Frameworks         []string         // 从 go.mod、package.json 等依赖清单识别出的框架
+	GeneratedAt        time.Time        // 生成时间
+	Provider           string           // 生成分析的 AI 服务提供方，未调用 API 时为空
+	Model              string           // 生成分析的模型名称，未调用 API 时为空
+	Debug              *DeepSeekDebug   // DeepSeek 原始响应信息，未调用 API 时为 nil
+}
+
+// DeepSeekDebug DeepSeek API 响应中用于调试提示词质量的信息
+type DeepSeekDebug struct {
+	Model        string                 `json:"model"`
+	FinishReason string                 `json:"finish_reason"`
+	Usage        map[string]interface{} `json:"usage,omitempty"`
+	RawResponse  json.RawMessage        `json:"raw_response"`
+}
+
+// FormatTimestamp 将时间格式化为 API 响应统一使用的 RFC3339 字符串
+func FormatTimestamp(t time.Time) string {
+	return t.Format(time.RFC3339)
 }
 
 // ProjectAnalysis alias to unified model
@@ -25,14 +49,34 @@ func ConvertToProjectAnalysis(cp ContextPrompt) ProjectAnalysis {
 	return ProjectAnalysis{
 		PromptSuggestions: cp.PromptSuggestions,
 		Documents:         cp.Documents,
-		GeneratedAt:       cp.GeneratedAt.Format(time.RFC3339),
+		LanguageStats:     cp.LanguageStats,
+		PrimaryLanguage:   cp.PrimaryLanguage,
+		Frameworks:        cp.Frameworks,
+		GeneratedAt:       FormatTimestamp(cp.GeneratedAt),
 	}
 }
 
+// ProjectComparison 两个项目的结构化对比结果
+type ProjectComparison struct {
+	Summary        string   `json:"summary"`         // 整体对比结论
+	SharedTech     []string `json:"shared_tech"`     // 共同使用的技术栈
+	Differences    []string `json:"differences"`     // 主要差异
+	MigrationNotes []string `json:"migration_notes"` // 从 A 迁移到 B 的注意事项
+	Raw            string   `json:"raw,omitempty"`   // 模型输出无法解析为 JSON 时的原始文本
+}
+
+// Citation 回答中引用的会话文件，行号范围无法确定时为 0
+type Citation struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+}
+
 // PromptRequest 表示提示词生成请求
 type PromptRequest struct {
 	ProjectPath string // 项目路径
 	ApiKey      string // API 密钥
+	Debug       bool   // 是否在响应中返回 DeepSeek 原始响应
 }
 
 // PromptResponse 表示提示词生成响应
@@ -3,17 +3,21 @@ package services
 import (
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"repo-prompt-web/internal/domain/models"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/ignore"
 )
 
 // FileProcessor 文件处理服务
@@ -28,15 +32,120 @@ func NewFileProcessor(cfg *config.Config) *FileProcessor {
 	}
 }
 
+// gzipMagic gzip 数据的前两个字节
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ProcessArchive 根据文件头识别上传的数据：tar 归档（可为 gzip、bzip2 或 xz 压缩）按 ProcessTarStream 处理，
+// gzip 压缩的单个文件按 ProcessGzipFile 处理，其余按 ZIP 处理。zstd 等能识别但不支持的格式返回 models.ErrUnsupportedArchive。
+// name 为上传的文件名，用于确定 gzip 解压后的文件名
+func (fp *FileProcessor) ProcessArchive(file io.ReaderAt, size int64, name string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	header := make([]byte, 262)
+	n, _ := file.ReadAt(header, 0)
+	header = header[:n]
+
+	switch {
+	case isTarHeader(header):
+		return fp.ProcessTarStream(io.NewSectionReader(file, 0, size), opts)
+	case bytes.HasPrefix(header, gzipMagic):
+		if isCompressedTar(io.NewSectionReader(file, 0, size)) {
+			return fp.ProcessTarStream(io.NewSectionReader(file, 0, size), opts)
+		}
+		return fp.ProcessGzipFile(io.NewSectionReader(file, 0, size), name, opts)
+	case isBzip2(header):
+		if isCompressedTar(io.NewSectionReader(file, 0, size)) {
+			return fp.ProcessTarStream(io.NewSectionReader(file, 0, size), opts)
+		}
+		return nil, fmt.Errorf("%w: bzip2 压缩的数据只支持 tar 归档", models.ErrUnsupportedArchive)
+	case bytes.HasPrefix(header, xzMagic):
+		if isCompressedTar(io.NewSectionReader(file, 0, size)) {
+			return fp.ProcessTarStream(io.NewSectionReader(file, 0, size), opts)
+		}
+		return nil, fmt.Errorf("%w: xz 压缩的数据只支持 tar 归档", models.ErrUnsupportedArchive)
+	}
+	if format := unsupportedFormat(header); format != "" {
+		return nil, fmt.Errorf("%w: %s", models.ErrUnsupportedArchive, format)
+	}
+	return fp.ProcessZipFile(file, size, opts)
+}
+
+// ProcessGzipFile 解压 gzip 压缩的单个文件，结果中只包含该文件；内容为二进制时按常规规则排除。
+// 文件名优先使用 gzip 头中记录的名称，否则为去掉 .gz 后缀的 name
+func (fp *FileProcessor) ProcessGzipFile(r io.Reader, name string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取gzip文件: %w", err)
+	}
+	defer gz.Close()
+	// 只处理单个文件，忽略拼接的多个 gzip 成员
+	gz.Multistream(false)
+
+	filePath := gzipFileName(gz.Name, name)
+	root := models.NewRootNode(fp.config.GetMaxTreeNodes())
+	fileContents := make(map[string]models.FileContent)
+	result := &models.ProcessResult{
+		FileTree:     root,
+		FileContents: fileContents,
+	}
+
+	readLimit, ok := fp.checkFile(filePath, 0, opts)
+	if !ok {
+		result.UpdateContentHash()
+		return result, nil
+	}
+
+	contentBytes, err := io.ReadAll(io.LimitReader(gz, readLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("解压gzip文件失败: %w", err)
+	}
+	if isTarHeader(contentBytes) {
+		return nil, fmt.Errorf("%w: tar.gz 归档不能按单个文件处理", models.ErrUnsupportedArchive)
+	}
+
+	if int64(len(contentBytes)) > readLimit {
+		log.Print("排除 (文件内容超限): " + filePath)
+	} else {
+		fp.addContent(root, fileContents, filePath, contentBytes, opts)
+	}
+	result.Warnings = LineEndingWarnings(fileContents)
+	result.UpdateContentHash()
+	return result, nil
+}
+
+// gzipFileName 返回 gzip 文件解压后的文件名，只保留最后一级名称以免出现目录穿越
+func gzipFileName(headerName, uploadName string) string {
+	name := path.Base(filepath.ToSlash(headerName))
+	if headerName == "" || name == "." || name == "/" || name == ".." {
+		name = path.Base(filepath.ToSlash(uploadName))
+		lower := strings.ToLower(name)
+		for _, ext := range []string{".gz", ".gzip"} {
+			if strings.HasSuffix(lower, ext) {
+				name = name[:len(name)-len(ext)]
+				break
+			}
+		}
+	}
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// isTarHeader 判断数据是否以 tar 头开始（偏移 257 处为 "ustar" 标记）
+func isTarHeader(content []byte) bool {
+	return len(content) >= 262 && string(content[257:262]) == "ustar"
+}
+
 // ProcessZipFile 处理ZIP文件
-func (fp *FileProcessor) ProcessZipFile(file io.ReaderAt, size int64, useBase64 bool) (*models.ProcessResult, error) {
+func (fp *FileProcessor) ProcessZipFile(file io.ReaderAt, size int64, opts models.ProcessOptions) (*models.ProcessResult, error) {
 	reader, err := zip.NewReader(file, size)
 	if err != nil {
 		return nil, fmt.Errorf("无法读取ZIP文件: %w", err)
 	}
 
-	root := models.NewTreeNode("", false)
+	root := models.NewRootNode(fp.config.GetMaxTreeNodes())
 	fileContents := make(map[string]models.FileContent)
+	var warnings []models.FileWarning
+	matcher := fp.loadIgnoreFiles(reader, opts.IgnoreFiles)
 
 	for _, zipEntry := range reader.File {
 		if zipEntry.FileInfo().IsDir() {
@@ -44,112 +153,318 @@ func (fp *FileProcessor) ProcessZipFile(file io.ReaderAt, size int64, useBase64
 		}
 
 		filePath := zipEntry.Name
-		if fp.config.IsExcluded(filePath, zipEntry.UncompressedSize64) {
-			log.Print("排除 (规则): " + filePath)
+		if matcher.Match(filePath, false) {
+			log.Print("排除 (忽略文件): " + filePath)
+			continue
+		}
+		if opts.IsExcludedDir(filePath) {
+			log.Print("排除 (请求排除目录): " + filePath)
 			continue
 		}
 
-		if !fp.config.IsLikelyTextFile(filePath) {
-			log.Print("排除 (非文本扩展名): " + filePath)
+		// 符号链接的内容是目标路径，不作为普通文件读取
+		if zipEntry.Mode()&os.ModeSymlink != 0 {
+			if opts.IncludeSymlinks {
+				fp.addSymlink(root, zipEntry)
+			} else {
+				log.Print("排除 (符号链接): " + filePath)
+			}
+			continue
+		}
+		readLimit, ok := fp.checkFile(filePath, zipEntry.UncompressedSize64, opts)
+		if !ok {
 			continue
 		}
 
 		rc, err := zipEntry.Open()
 		if err != nil {
 			log.Printf("警告: 无法打开文件 %s: %v", filePath, err)
+			warnings = append(warnings, models.FileWarning{Path: filePath, Reason: "无法打开文件: " + err.Error()})
 			continue
 		}
 
-		contentBytes, err := io.ReadAll(io.LimitReader(rc, fp.config.GetMaxFileSize()+1))
+		contentBytes, err := io.ReadAll(io.LimitReader(rc, readLimit+1))
 		rc.Close()
 
 		if err != nil {
 			log.Printf("警告: 读取文件 %s 失败: %v", filePath, err)
+			warnings = append(warnings, models.FileWarning{Path: filePath, Reason: "读取文件失败: " + err.Error()})
 			continue
 		}
 
-		if int64(len(contentBytes)) > fp.config.GetMaxFileSize() {
+		if int64(len(contentBytes)) > readLimit {
 			log.Print("排除 (文件内容超限): " + filePath)
 			continue
 		}
 
-		contentType := http.DetectContentType(contentBytes)
-		if !strings.HasPrefix(contentType, "text/") && !fp.config.IsTextContentTypeException(contentType) {
-			log.Print("排除 (检测到二进制内容 " + contentType + "): " + filePath)
-			continue
-		}
+		fp.addContent(root, fileContents, filePath, contentBytes, opts)
+	}
 
-		normalizedPath := filepath.ToSlash(filePath)
-		fileContents[normalizedPath] = fp.processContent(normalizedPath, contentBytes, useBase64)
-		root.AddPath(normalizedPath)
-		log.Printf("已处理: %s", filePath)
+	if opts.FailOnError && len(warnings) > 0 {
+		return nil, models.NewFileProcessingError(warnings)
 	}
 
-	return &models.ProcessResult{
+	// 混合换行符只作提示，不触发严格模式
+	result := &models.ProcessResult{
 		FileTree:     root,
 		FileContents: fileContents,
-	}, nil
+		Warnings:     append(warnings, LineEndingWarnings(fileContents)...),
+	}
+	result.UpdateContentHash()
+	return result, nil
 }
 
-// processContent 处理文件内容
-func (fp *FileProcessor) processContent(path string, content []byte, useBase64 bool) models.FileContent {
-	if useBase64 {
-		return models.FileContent{
-			Path:     path,
-			Content:  base64.StdEncoding.EncodeToString(content),
+// checkFile 按路径和大小规则判断文件是否需要读取，返回允许读取的最大字节数。
+// size 未知时传 0，读取后仍需按返回的上限检查
+func (fp *FileProcessor) checkFile(filePath string, size uint64, opts models.ProcessOptions) (int64, bool) {
+	maxBinaryBytes := fp.config.GetMaxBinaryBytes()
+	// 保留二进制文件时，被扩展名规则排除的小文件仍作为二进制候选
+	binaryCandidate := opts.IncludeBinary && size <= uint64(maxBinaryBytes) &&
+		!fp.config.IsExcludedDir(filePath)
+
+	// 请求额外指定的文本扩展名覆盖扩展名排除规则，排除目录仍然生效，大小在后面按读取上限检查
+	extraText := opts.IsExtraTextFile(filePath)
+	excluded := fp.config.IsExcluded(filePath, size)
+	if extraText {
+		excluded = fp.config.IsExcludedDir(filePath)
+	}
+	if excluded && !binaryCandidate {
+		log.Print("排除 (规则): " + filePath)
+		return 0, false
+	}
+
+	// 开启内容检测时，未识别扩展名的文件读取后由 addContent 按内容判断
+	if !extraText && !fp.config.IsLikelyTextFile(filePath) && !binaryCandidate && !fp.config.IsContentSniffingEnabled() {
+		log.Print("排除 (非文本扩展名): " + filePath)
+		return 0, false
+	}
+
+	if opts.SkipTests && fp.config.IsTestFile(filePath) {
+		log.Print("排除 (测试文件): " + filePath)
+		return 0, false
+	}
+
+	if opts.SkipGenerated && fp.config.IsGeneratedFile(filePath) {
+		log.Print("排除 (生成代码): " + filePath)
+		return 0, false
+	}
+
+	readLimit := opts.FileSizeLimit(fp.config.GetMaxFileSize())
+	if binaryCandidate && readLimit < maxBinaryBytes {
+		readLimit = maxBinaryBytes
+	}
+	if size > uint64(readLimit) {
+		log.Print("排除 (超过大小限制): " + filePath)
+		return 0, false
+	}
+	return readLimit, true
+}
+
+// addContent 检测已读取的文件内容，文本文件（或 include_binary 时的小型二进制文件）加入结果，其余排除
+func (fp *FileProcessor) addContent(root *models.TreeNode, fileContents map[string]models.FileContent, filePath string, contentBytes []byte, opts models.ProcessOptions) {
+	normalizedPath := filepath.ToSlash(filePath)
+	contentType, isText := fp.config.DetectTextContent(filePath, contentBytes, opts.IsExtraTextFile(filePath))
+	if !isText {
+		if !opts.IncludeBinary || int64(len(contentBytes)) > fp.config.GetMaxBinaryBytes() {
+			log.Print("排除 (检测到二进制内容 " + contentType + "): " + filePath)
+			return
+		}
+		fileContents[normalizedPath] = models.FileContent{
+			Path:     normalizedPath,
+			Content:  base64.StdEncoding.EncodeToString(contentBytes),
 			IsBase64: true,
+			IsBinary: true,
+			Size:     int64(len(contentBytes)),
+			Hash:     models.HashContent(contentBytes),
 		}
+		root.AddPath(normalizedPath).Size = int64(len(contentBytes))
+		log.Printf("已处理 (二进制 %s): %s", contentType, filePath)
+		return
 	}
-	return models.FileContent{
-		Path:     path,
-		Content:  string(content),
-		IsBase64: false,
+
+	if opts.SkipGenerated && IsGeneratedContent(contentBytes) {
+		log.Print("排除 (生成代码标记): " + filePath)
+		return
 	}
+
+	content := ProcessContent(normalizedPath, contentBytes, opts)
+	fileContents[normalizedPath] = content
+	root.AddPath(normalizedPath).Size = content.Size
+	log.Printf("已处理: %s", filePath)
 }
 
-// FormatOutput 格式化输出
-func (fp *FileProcessor) FormatOutput(result *models.ProcessResult) string {
-	var buf bytes.Buffer
+// maxSymlinkTargetSize 符号链接目标路径的最大读取字节数
+const maxSymlinkTargetSize = 4096
+
+// addSymlink 将符号链接以 "name -> target" 形式加入文件树
+func (fp *FileProcessor) addSymlink(root *models.TreeNode, zipEntry *zip.File) {
+	var target string
+	if rc, err := zipEntry.Open(); err == nil {
+		content, _ := io.ReadAll(io.LimitReader(rc, maxSymlinkTargetSize))
+		rc.Close()
+		target = strings.TrimSpace(string(content))
+	} else {
+		log.Printf("警告: 无法读取符号链接 %s: %v", zipEntry.Name, err)
+	}
+
+	node := root.AddPath(filepath.ToSlash(zipEntry.Name))
+	node.Type = "symlink"
+	node.LinkTarget = target
+	log.Printf("已处理 (符号链接): %s -> %s", zipEntry.Name, target)
+}
 
-	buf.WriteString("文件结构:\n")
-	fp.printTree(result.FileTree, &buf, "", true)
-	buf.WriteString("\n文件内容:\n")
+// maxIgnoreFileSize 忽略文件的最大读取字节数
+const maxIgnoreFileSize = 1024 * 1024
 
-	for path, content := range result.FileContents {
-		buf.WriteString(fmt.Sprintf("\n=== %s ===\n", path))
-		buf.WriteString(content.Content)
-		buf.WriteString("\n")
+// loadIgnoreFiles 读取归档中所有名为 names 的忽略文件，每个文件的规则相对其所在目录生效
+func (fp *FileProcessor) loadIgnoreFiles(reader *zip.Reader, names []string) *ignore.Matcher {
+	matcher := ignore.New()
+	if len(names) == 0 {
+		return matcher
 	}
 
-	return buf.String()
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	for _, zipEntry := range reader.File {
+		if zipEntry.FileInfo().IsDir() || !wanted[path.Base(zipEntry.Name)] {
+			continue
+		}
+
+		rc, err := zipEntry.Open()
+		if err != nil {
+			log.Printf("警告: 无法打开忽略文件 %s: %v", zipEntry.Name, err)
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, maxIgnoreFileSize))
+		rc.Close()
+		if err != nil {
+			log.Printf("警告: 读取忽略文件 %s 失败: %v", zipEntry.Name, err)
+			continue
+		}
+
+		matcher.Add(path.Dir(zipEntry.Name), content)
+		log.Printf("已加载忽略文件: %s", zipEntry.Name)
+	}
+	return matcher
 }
 
-// printTree 打印文件树
-func (fp *FileProcessor) printTree(node *models.TreeNode, buffer *bytes.Buffer, prefix string, isLast bool) {
-	if node.Name != "" {
-		buffer.WriteString(prefix)
-		if isLast {
-			buffer.WriteString("└── ")
-			prefix += "    "
-		} else {
-			buffer.WriteString("├── ")
-			prefix += "│   "
+// generatedHeaderLines 检查生成代码标记时扫描的最大行数
+const generatedHeaderLines = 5
+
+// IsGeneratedContent 检查文件开头是否包含 "Code generated ... DO NOT EDIT" 标记
+func IsGeneratedContent(content []byte) bool {
+	lines := bytes.SplitN(content, []byte("\n"), generatedHeaderLines+1)
+	if len(lines) > generatedHeaderLines {
+		lines = lines[:generatedHeaderLines]
+	}
+	for _, line := range lines {
+		if bytes.Contains(line, []byte("Code generated")) && bytes.Contains(line, []byte("DO NOT EDIT")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessContent 按处理选项生成文本文件的内容：先按 LineEndings 转换换行符，再按需进行 base64 编码
+// （UseBase64 时全部编码，AutoBase64 时只编码不是合法 UTF-8 的内容）。混合换行符按转换前的原始内容检测
+func ProcessContent(path string, content []byte, opts models.ProcessOptions) models.FileContent {
+	mixed := HasMixedLineEndings(content)
+	content = NormalizeLineEndings(content, opts.LineEndings)
+
+	if opts.UseBase64 || (opts.AutoBase64 && !utf8.Valid(content)) {
+		return models.FileContent{
+			Path:             path,
+			Content:          base64.StdEncoding.EncodeToString(content),
+			IsBase64:         true,
+			Size:             int64(len(content)),
+			Hash:             models.HashContent(content),
+			MixedLineEndings: mixed,
 		}
-		buffer.WriteString(node.Name + "\n")
 	}
+	return models.FileContent{
+		Path:             path,
+		Content:          string(content),
+		IsBase64:         false,
+		Size:             int64(len(content)),
+		Hash:             models.HashContent(content),
+		MixedLineEndings: mixed,
+	}
+}
+
+// FormatOutput 按输出选项格式化文件树和文件内容
+func (fp *FileProcessor) FormatOutput(result *models.ProcessResult, opts models.OutputOptions) string {
+	var buf bytes.Buffer
+	// 写入 bytes.Buffer 不会失败
+	_ = fp.WriteOutput(&buf, result, opts)
+	return buf.String()
+}
+
+// WriteOutput 按 FormatOutput 的格式先写文件树，再逐个写入文件内容，
+// 不在内存中拼接完整输出，适合直接写入 HTTP 响应
+func (fp *FileProcessor) WriteOutput(w io.Writer, result *models.ProcessResult, opts models.OutputOptions) error {
+	if _, err := io.WriteString(w, opts.TreeHeader); err != nil {
+		return err
+	}
+	if err := fp.WriteTree(w, result, opts); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, opts.ContentHeader); err != nil {
+		return err
+	}
+	return fp.WriteContents(w, result, opts)
+}
+
+// WriteTree 只写入文件树（按 opts.TreeMaxDepth 限制深度），不含 TreeHeader 和 ContentHeader
+func (fp *FileProcessor) WriteTree(w io.Writer, result *models.ProcessResult, opts models.OutputOptions) error {
+	var tree bytes.Buffer
+	result.FileTree.PrintDepth(&tree, "", true, opts.TreeMaxDepth)
+	_, err := w.Write(tree.Bytes())
+	return err
+}
 
-	var children []*models.TreeNode
-	for _, child := range node.Children {
-		children = append(children, child)
+// WriteContents 只逐个写入文件内容，每个文件前后为 FileHeader 和 FileFooter。
+// 开启 Dedupe 时按路径顺序输出，重复内容的文件只写入对首次出现的文件的引用
+func (fp *FileProcessor) WriteContents(w io.Writer, result *models.ProcessResult, opts models.OutputOptions) error {
+	paths := make([]string, 0, len(result.FileContents))
+	for path := range result.FileContents {
+		paths = append(paths, path)
+	}
+	var firstByHash map[string]string
+	if opts.Dedupe {
+		// 按路径排序，使"首次出现"的文件在多次输出间保持一致
+		sort.Strings(paths)
+		firstByHash = make(map[string]string)
 	}
-	sort.Slice(children, func(i, j int) bool {
-		if children[i].IsDir != children[j].IsDir {
-			return children[i].IsDir
+
+	for _, path := range paths {
+		content := result.FileContents[path]
+		body := content.Content
+		if opts.Dedupe && content.Hash != "" {
+			if first, ok := firstByHash[content.Hash]; ok {
+				body = "see: " + first + "\n"
+			} else {
+				firstByHash[content.Hash] = path
+			}
 		}
-		return children[i].Name < children[j].Name
-	})
 
-	for i, child := range children {
-		fp.printTree(child, buffer, prefix, i == len(children)-1)
+		header := strings.NewReplacer(
+			"{path}", path,
+			"{size}", models.FormatSize(content.Size),
+			"{language}", fp.config.LanguageForPath(path),
+		).Replace(opts.FileHeader)
+
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, body); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, opts.FileFooter); err != nil {
+			return err
+		}
 	}
+	return nil
 }
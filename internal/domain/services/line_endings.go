@@ -0,0 +1,45 @@
+package services
+
+import (
+	"bytes"
+	"sort"
+
+	"repo-prompt-web/internal/domain/models"
+)
+
+// HasMixedLineEndings 检查内容是否同时包含 CRLF 和单独的 LF 换行符
+func HasMixedLineEndings(content []byte) bool {
+	crlf := bytes.Count(content, []byte("\r\n"))
+	if crlf == 0 {
+		return false
+	}
+	return bytes.Count(content, []byte("\n")) > crlf
+}
+
+// NormalizeLineEndings 按 mode 转换换行符：lf 将 CRLF 转为 LF，crlf 将所有换行统一为 CRLF，
+// keep 或空值时原样返回
+func NormalizeLineEndings(content []byte, mode string) []byte {
+	switch mode {
+	case models.LineEndingsLF:
+		return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	case models.LineEndingsCRLF:
+		lf := bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+		return bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n"))
+	default:
+		return content
+	}
+}
+
+// LineEndingWarnings 为原始内容混用 CRLF 和 LF 的文件生成警告，按路径排序
+func LineEndingWarnings(fileContents map[string]models.FileContent) []models.FileWarning {
+	var warnings []models.FileWarning
+	for path, content := range fileContents {
+		if content.MixedLineEndings {
+			warnings = append(warnings, models.FileWarning{Path: path, Reason: "混合使用 CRLF 和 LF 换行符"})
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Path < warnings[j].Path
+	})
+	return warnings
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"repo-prompt-web/pkg/ignore"
+)
+
+// ProjectStack 从目录中检测到的语言和框架
+type ProjectStack struct {
+	LanguageStats   map[string]int64 // 各语言的文件总字节数
+	PrimaryLanguage string           // 字节数最多的编程语言，不计标记和数据格式
+	Frameworks      []string         // 从依赖清单识别出的框架，按名称排序
+}
+
+// nonPrimaryLanguages 只计入语言统计、不参与主要语言判断的标记、数据和构建配置格式
+var nonPrimaryLanguages = map[string]bool{
+	"markdown": true, "rst": true,
+	"json": true, "xml": true, "yaml": true, "toml": true, "ini": true,
+	"html": true, "css": true, "scss": true, "sass": true, "less": true,
+	"dockerfile": true, "makefile": true, "cmake": true,
+}
+
+// pythonFrameworks requirements.txt 和 pyproject.toml 中的依赖名到框架名的映射
+var pythonFrameworks = map[string]string{
+	"django":     "Django",
+	"flask":      "Flask",
+	"fastapi":    "FastAPI",
+	"tornado":    "Tornado",
+	"torch":      "PyTorch",
+	"tensorflow": "TensorFlow",
+}
+
+// jvmFrameworks Maven 和 Gradle 构建文件中的依赖名到框架名的映射
+var jvmFrameworks = map[string]string{
+	"spring-boot": "Spring Boot",
+}
+
+// frameworkMarkers 依赖清单文件名到“依赖名 -> 框架名”映射的对应关系
+var frameworkMarkers = map[string]map[string]string{
+	"go.mod": {
+		"github.com/gin-gonic/gin":     "Gin",
+		"github.com/labstack/echo":     "Echo",
+		"github.com/gofiber/fiber":     "Fiber",
+		"github.com/go-chi/chi":        "Chi",
+		"github.com/gorilla/mux":       "Gorilla Mux",
+		"github.com/beego/beego":       "Beego",
+		"github.com/zeromicro/go-zero": "go-zero",
+		"github.com/spf13/cobra":       "Cobra",
+		"google.golang.org/grpc":       "gRPC",
+		"gorm.io/gorm":                 "GORM",
+	},
+	"package.json": {
+		"react":         "React",
+		"next":          "Next.js",
+		"vue":           "Vue",
+		"nuxt":          "Nuxt",
+		"@angular/core": "Angular",
+		"svelte":        "Svelte",
+		"express":       "Express",
+		"@nestjs/core":  "NestJS",
+		"koa":           "Koa",
+		"fastify":       "Fastify",
+		"electron":      "Electron",
+	},
+	"requirements.txt": pythonFrameworks,
+	"pyproject.toml":   pythonFrameworks,
+	"Cargo.toml": {
+		"actix-web": "Actix Web",
+		"axum":      "Axum",
+		"rocket":    "Rocket",
+		"tokio":     "Tokio",
+	},
+	"Gemfile": {
+		"rails":   "Ruby on Rails",
+		"sinatra": "Sinatra",
+	},
+	"composer.json": {
+		"laravel/framework":        "Laravel",
+		"symfony/framework-bundle": "Symfony",
+	},
+	"pom.xml":          jvmFrameworks,
+	"build.gradle":     jvmFrameworks,
+	"build.gradle.kts": jvmFrameworks,
+}
+
+// maxManifestSize 解析依赖清单时读取的最大字节数
+const maxManifestSize = 1024 * 1024
+
+// goVersionSuffix 匹配 Go 模块路径末尾的主版本号，如 /v4
+var goVersionSuffix = regexp.MustCompile(`/v\d+$`)
+
+// requirementName 匹配 Python 依赖声明开头的包名
+var requirementName = regexp.MustCompile(`^\s*"?([A-Za-z0-9][A-Za-z0-9._-]*)`)
+
+// detectProjectStack 遍历目录统计各语言的字节数，并从 go.mod、package.json、requirements.txt 等
+// 依赖清单识别框架。跳过的目录和忽略文件规则与 buildDirectoryTree 相同
+func (pg *PromptGenerator) detectProjectStack(rootDir string) ProjectStack {
+	stats := make(map[string]int64)
+	found := make(map[string]bool)
+
+	matcher := ignore.New()
+	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && (strings.HasPrefix(info.Name(), ".") ||
+			info.Name() == "node_modules" ||
+			info.Name() == "vendor" ||
+			info.Name() == "dist") {
+			return filepath.SkipDir
+		}
+		if pg.ignored(matcher, rootDir, path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		if pg.languageForPath != nil {
+			if lang := pg.languageForPath(path); lang != "" {
+				stats[lang] += info.Size()
+			}
+		}
+
+		if markers, ok := frameworkMarkers[info.Name()]; ok && info.Size() <= maxManifestSize {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("读取依赖清单出错 %s: %v", path, err)
+				return nil
+			}
+			for _, dep := range manifestDependencies(info.Name(), content) {
+				if framework, ok := markers[dep]; ok {
+					found[framework] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("检测语言和框架出错: %v", err)
+	}
+
+	stack := ProjectStack{Frameworks: make([]string, 0, len(found))}
+	if len(stats) > 0 {
+		stack.LanguageStats = stats
+	}
+	var primaryBytes int64
+	for lang, size := range stats {
+		if nonPrimaryLanguages[lang] {
+			continue
+		}
+		if size > primaryBytes || (size == primaryBytes && lang < stack.PrimaryLanguage) {
+			stack.PrimaryLanguage = lang
+			primaryBytes = size
+		}
+	}
+	for framework := range found {
+		stack.Frameworks = append(stack.Frameworks, framework)
+	}
+	sort.Strings(stack.Frameworks)
+	return stack
+}
+
+// manifestDependencies 从依赖清单中提取依赖名。JSON 清单读取 dependencies 和 devDependencies（composer 为 require），
+// 其他清单按行提取，Go 模块路径去掉主版本号后缀，Python 包名转为小写
+func manifestDependencies(name string, content []byte) []string {
+	switch name {
+	case "package.json", "composer.json":
+		var manifest struct {
+			Dependencies    map[string]string `json:"dependencies"`
+			DevDependencies map[string]string `json:"devDependencies"`
+			Require         map[string]string `json:"require"`
+			RequireDev      map[string]string `json:"require-dev"`
+		}
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil
+		}
+		var deps []string
+		for _, m := range []map[string]string{manifest.Dependencies, manifest.DevDependencies, manifest.Require, manifest.RequireDev} {
+			for dep := range m {
+				deps = append(deps, dep)
+			}
+		}
+		return deps
+	}
+
+	var deps []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxManifestSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch name {
+		case "go.mod":
+			// 同时处理 require ( ... ) 块中的行和单行 require
+			fields := strings.Fields(strings.TrimPrefix(line, "require "))
+			if len(fields) >= 2 && strings.Contains(fields[0], ".") {
+				deps = append(deps, goVersionSuffix.ReplaceAllString(fields[0], ""))
+			}
+		case "requirements.txt", "pyproject.toml":
+			if match := requirementName.FindStringSubmatch(line); match != nil {
+				deps = append(deps, strings.ToLower(match[1]))
+			}
+		case "Cargo.toml":
+			if key, _, ok := strings.Cut(line, "="); ok {
+				deps = append(deps, strings.TrimSpace(key))
+			}
+		case "Gemfile":
+			if rest, ok := strings.CutPrefix(line, "gem "); ok {
+				deps = append(deps, strings.Trim(strings.TrimSpace(strings.Split(rest, ",")[0]), `"'`))
+			}
+		case "pom.xml", "build.gradle", "build.gradle.kts":
+			// 只需识别 Spring Boot，包含 spring-boot 的行即视为依赖
+			if strings.Contains(line, "spring-boot") {
+				deps = append(deps, "spring-boot")
+			}
+		}
+	}
+	return deps
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,9 @@ import (
 	"time"
 
 	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/ignore"
+	"repo-prompt-web/pkg/limiter"
+	"repo-prompt-web/pkg/stats"
 )
 
 // PromptGenerator 提示词生成服务
@@ -20,30 +24,108 @@ type PromptGenerator struct {
 	deepseekAPIKey     string
 	maxDocumentSize    int64
 	documentExtensions map[string]bool
+	importantFiles     map[string]bool // 优先收集的重要文件名
+	ignoreFiles        []string        // 遍历目录时读取的忽略文件名，如 .dockerignore
+	limits             DocumentLimits
+	languageForPath    func(path string) string // 按文件路径识别语言，用于统计语言，为空时不统计
+	httpClient         *http.Client             // 调用 DeepSeek API 使用的客户端
+	limiter            *limiter.Limiter         // 与 Gemini 调用共享的并发限制，nil 表示不限制
 }
 
+// PromptGeneratorOptions 提示词生成服务的可配置项
+type PromptGeneratorOptions struct {
+	IgnoreFiles    []string // 遍历目录时读取的忽略文件名，如 .dockerignore
+	ImportantFiles []string // 优先收集的重要文件名
+	Limits         DocumentLimits
+	// LanguageForPath 按文件路径返回语言名称（未知时为空字符串），用于统计语言和判断主要语言，为空时不统计
+	LanguageForPath func(path string) string
+	HTTPClient      *http.Client     // 调用 DeepSeek API 使用的客户端，为空时使用超时 120 秒的默认客户端
+	Limiter         *limiter.Limiter // 调用 DeepSeek API 前获取的并发名额，为空时不限制
+}
+
+// DocumentLimits 收集文档的数量限制，零值字段使用默认值
+type DocumentLimits struct {
+	MaxTotal       int // 总共最多收集的文件数
+	MaxPerType     int // 每种非文档类型（按扩展名或文件名区分）最多收集的文件数
+	MaxDocsPerType int // 每种文档扩展名（如 .md）最多收集的文件数
+}
+
+// withDefaults 为未设置的限制填充默认值
+func (l DocumentLimits) withDefaults() DocumentLimits {
+	if l.MaxTotal <= 0 {
+		l.MaxTotal = 10
+	}
+	if l.MaxPerType <= 0 {
+		l.MaxPerType = 1
+	}
+	if l.MaxDocsPerType <= 0 {
+		l.MaxDocsPerType = 4
+	}
+	return l
+}
+
+// 支持的文档文件类型
+var documentExtensions = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+	".rst":      true,
+	".org":      true,
+	".wiki":     true,
+	".adoc":     true,
+}
+
+// deepseekModel 生成架构分析使用的 DeepSeek 模型
+const deepseekModel = "deepseek-chat"
+
+// 文档文件的最大大小
+const maxDocumentSize = 1024 * 1024 // 1MB
+
 // NewPromptGenerator 创建提示词生成服务
-func NewPromptGenerator(apiKey string) *PromptGenerator {
-	// 支持的文档文件类型
-	docExtensions := map[string]bool{
-		".md":       true,
-		".markdown": true,
-		".txt":      true,
-		".rst":      true,
-		".org":      true,
-		".wiki":     true,
-		".adoc":     true,
-	}
-
-	return &PromptGenerator{
+func NewPromptGenerator(apiKey string, opts PromptGeneratorOptions) *PromptGenerator {
+	important := make(map[string]bool, len(opts.ImportantFiles))
+	for _, name := range opts.ImportantFiles {
+		important[name] = true
+	}
+
+	generator := &PromptGenerator{
 		deepseekAPIKey:     apiKey,
-		maxDocumentSize:    1024 * 1024, // 1MB
-		documentExtensions: docExtensions,
+		maxDocumentSize:    maxDocumentSize,
+		documentExtensions: documentExtensions,
+		importantFiles:     important,
+		ignoreFiles:        opts.IgnoreFiles,
+		limits:             opts.Limits.withDefaults(),
+		languageForPath:    opts.LanguageForPath,
+		httpClient:         opts.HTTPClient,
+		limiter:            opts.Limiter,
+	}
+	if generator.httpClient == nil {
+		generator.httpClient = &http.Client{Timeout: 120 * time.Second}
 	}
+	return generator
+}
+
+// IsDocumentCandidate 判断文件是否可能被 collectImportantDocuments 收集，
+// 供只需要文档而不需要全部文件内容的调用方（如 GitHub 轻量获取）使用。
+// important 表示文件名在配置的重要文件列表中
+func IsDocumentCandidate(path string, size int64, important bool) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return (important || documentExtensions[ext]) && size < maxDocumentSize/2
 }
 
 // ProcessDirectoryContext 处理目录上下文并生成提示词
 func (pg *PromptGenerator) ProcessDirectoryContext(rootDir string) (*models.ContextPrompt, error) {
+	return pg.processDirectoryContext(rootDir, nil)
+}
+
+// ProcessDirectoryContextStream 与 ProcessDirectoryContext 相同，但以流式方式调用 DeepSeek，
+// 每收到一段分析文本调用一次 onDelta（在调用方的 goroutine 中同步执行）
+func (pg *PromptGenerator) ProcessDirectoryContextStream(rootDir string, onDelta func(string)) (*models.ContextPrompt, error) {
+	return pg.processDirectoryContext(rootDir, onDelta)
+}
+
+// processDirectoryContext 收集目录结构和文档并生成架构分析，onDelta 非空时使用流式响应
+func (pg *PromptGenerator) processDirectoryContext(rootDir string, onDelta func(string)) (*models.ContextPrompt, error) {
 	log.Printf("正在处理目录: %s", rootDir)
 
 	// 收集目录结构
@@ -60,20 +142,43 @@ func (pg *PromptGenerator) ProcessDirectoryContext(rootDir string) (*models.Cont
 	}
 	log.Printf("收集到 %d 个重要文档文件", len(docs))
 
+	// 统计语言并从依赖清单识别框架，作为自由文本分析之外的结构化字段
+	stack := pg.detectProjectStack(rootDir)
+	log.Printf("主要语言: %s, 框架: %v", stack.PrimaryLanguage, stack.Frameworks)
+
 	// 调用 DeepSeek API 生成提示词
-	promptSuggestions, err := pg.generateArchitectPrompt(dirStructure, docs)
+	var promptSuggestions []string
+	var debug *models.DeepSeekDebug
+	if onDelta != nil {
+		promptSuggestions, debug, err = pg.streamArchitectPrompt(dirStructure, docs, onDelta)
+	} else {
+		promptSuggestions, debug, err = pg.generateArchitectPrompt(dirStructure, docs)
+	}
 	if err != nil {
 		log.Printf("生成提示词时出错: %v", err)
 		return nil, fmt.Errorf("生成提示词建议失败: %w", err)
 	}
 	log.Printf("生成了 %d 个提示词建议", len(promptSuggestions))
 
-	return &models.ContextPrompt{
+	contextPrompt := &models.ContextPrompt{
 		DirectoryStructure: dirStructure,
 		Documents:          docs,
 		PromptSuggestions:  promptSuggestions,
+		LanguageStats:      stack.LanguageStats,
+		PrimaryLanguage:    stack.PrimaryLanguage,
+		Frameworks:         stack.Frameworks,
 		GeneratedAt:        time.Now(),
-	}, nil
+		Debug:              debug,
+	}
+	if debug != nil {
+		// 以 API 实际返回的模型为准
+		contextPrompt.Provider = "deepseek"
+		contextPrompt.Model = debug.Model
+		if contextPrompt.Model == "" {
+			contextPrompt.Model = deepseekModel
+		}
+	}
+	return contextPrompt, nil
 }
 
 // 构建目录树结构
@@ -93,6 +198,7 @@ func (pg *PromptGenerator) buildDirectoryTree(rootDir string) (string, error) {
 	}
 	log.Printf("开始构建目录树: %s", absRoot)
 
+	matcher := ignore.New()
 	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Printf("访问路径出错 %s: %v", path, err)
@@ -107,6 +213,14 @@ func (pg *PromptGenerator) buildDirectoryTree(rootDir string) (string, error) {
 			return filepath.SkipDir
 		}
 
+		// 按忽略文件（如 .dockerignore）排除
+		if pg.ignored(matcher, rootDir, path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// 计算相对路径和缩进
 		relPath, err := filepath.Rel(rootDir, path)
 		if err != nil {
@@ -123,7 +237,7 @@ func (pg *PromptGenerator) buildDirectoryTree(rootDir string) (string, error) {
 		if info.IsDir() {
 			buffer.WriteString(indent + "📁 " + info.Name() + "/\n")
 		} else {
-			buffer.WriteString(indent + "📄 " + info.Name() + " (" + formatFileSize(info.Size()) + ")\n")
+			buffer.WriteString(indent + "📄 " + info.Name() + " (" + models.FormatSize(info.Size()) + ")\n")
 		}
 
 		return nil
@@ -142,28 +256,14 @@ func (pg *PromptGenerator) buildDirectoryTree(rootDir string) (string, error) {
 func (pg *PromptGenerator) collectImportantDocuments(rootDir string) ([]models.Document, error) {
 	var documents []models.Document
 
-	// 重要文件列表 - 优先级从高到低
-	importantFiles := map[string]bool{
-		"README.md":        true,
-		"README":           true,
-		"README.txt":       true,
-		"go.mod":           true,
-		"package.json":     true,
-		"requirements.txt": true,
-		"Cargo.toml":       true,
-		"Dockerfile":       true,
-		"LICENSE":          true,
-	}
-
 	// 每种类型的文件计数
 	fileTypeCount := make(map[string]int)
-	const maxFilesPerType = 1 // 每种类型最多收集的文件数
-	const maxTotalFiles = 5   // 总共最多收集的文件数
 
 	var collectedFiles int
 
+	matcher := ignore.New()
 	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if collectedFiles >= maxTotalFiles {
+		if collectedFiles >= pg.limits.MaxTotal {
 			return filepath.SkipDir // 已收集足够的文件
 		}
 
@@ -180,6 +280,14 @@ func (pg *PromptGenerator) collectImportantDocuments(rootDir string) ([]models.D
 			return filepath.SkipDir
 		}
 
+		// 按忽略文件（如 .dockerignore）排除
+		if pg.ignored(matcher, rootDir, path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// 只处理重要文件
 		if !info.IsDir() {
 			filename := filepath.Base(path)
@@ -189,12 +297,16 @@ func (pg *PromptGenerator) collectImportantDocuments(rootDir string) ([]models.D
 				fileType = filename
 			}
 
-			isImportant := importantFiles[filename]
+			isImportant := pg.importantFiles[filename]
 			isDoc := pg.documentExtensions[ext]
 
 			if (isImportant || isDoc) && info.Size() < pg.maxDocumentSize/2 {
-				// 检查此类型的文件是否已达到上限
-				if fileTypeCount[fileType] >= maxFilesPerType {
+				// 检查此类型的文件是否已达到上限，文档类型的上限更高
+				maxPerType := pg.limits.MaxPerType
+				if isDoc {
+					maxPerType = pg.limits.MaxDocsPerType
+				}
+				if fileTypeCount[fileType] >= maxPerType {
 					return nil
 				}
 
@@ -237,7 +349,7 @@ func (pg *PromptGenerator) collectImportantDocuments(rootDir string) ([]models.D
 
 				fileTypeCount[fileType]++
 				collectedFiles++
-				log.Printf("收集重要文档: %s (%s)", relPath, formatFileSize(info.Size()))
+				log.Printf("收集重要文档: %s (%s)", relPath, models.FormatSize(info.Size()))
 			}
 		}
 
@@ -247,12 +359,238 @@ func (pg *PromptGenerator) collectImportantDocuments(rootDir string) ([]models.D
 	return documents, err
 }
 
-// 生成架构师视角的提示词
-func (pg *PromptGenerator) generateArchitectPrompt(dirStructure string, docs []models.Document) ([]string, error) {
+// ignored 判断遍历到的路径是否被忽略文件排除；进入未被排除的目录时加载其中的忽略文件
+func (pg *PromptGenerator) ignored(matcher *ignore.Matcher, rootDir, path string, info os.FileInfo) bool {
+	if len(pg.ignoreFiles) == 0 {
+		return false
+	}
+
+	relPath, err := filepath.Rel(rootDir, path)
+	if err != nil {
+		return false
+	}
+	if relPath != "." && matcher.Match(relPath, info.IsDir()) {
+		return true
+	}
+	if info.IsDir() {
+		matcher.AddFromDir(path, relPath, pg.ignoreFiles)
+	}
+	return false
+}
+
+// maxEmptyAnalysisRetries DeepSeek 返回空内容（或只有空白）时调整提示词后重新请求的最大次数
+const maxEmptyAnalysisRetries = 2
+
+// 生成架构师视角的提示词，同时返回 DeepSeek 原始响应信息用于调试。
+// 响应内容为空时调整提示词重试，重试后仍为空时返回 models.ErrEmptyAnalysis
+func (pg *PromptGenerator) generateArchitectPrompt(dirStructure string, docs []models.Document) ([]string, *models.DeepSeekDebug, error) {
+	if pg.deepseekAPIKey == "" {
+		return []string{noAPIKeyMessage}, nil, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		requestBody, err := architectRequest(dirStructure, docs, false, attempt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		content, debug, err := pg.requestArchitectPrompt(requestBody)
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.TrimSpace(content) != "" {
+			log.Printf("成功从 DeepSeek API 获取响应，长度: %d 字节", len(content))
+			// 将响应作为一个完整的提示词返回
+			return []string{content}, debug, nil
+		}
+		if !retryEmptyAnalysis(attempt, debug) {
+			return nil, debug, models.ErrEmptyAnalysis
+		}
+	}
+}
+
+// requestArchitectPrompt 发送一次非流式请求并返回分析文本
+func (pg *PromptGenerator) requestArchitectPrompt(requestBody []byte) (string, *models.DeepSeekDebug, error) {
+	// 名额一直占用到响应读取完毕
+	if err := pg.limiter.Acquire(); err != nil {
+		log.Print("DeepSeek 请求排队超时")
+		return "", nil, err
+	}
+	defer pg.limiter.Release()
+
+	resp, err := pg.postDeepSeek(requestBody)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("读取 DeepSeek API 响应失败: %v", err)
+		return "", nil, err
+	}
+
+	content, debug, err := parseDeepSeekResponse(body)
+	if err != nil {
+		log.Printf("解析 DeepSeek API 响应失败: %v", err)
+		return "", nil, err
+	}
+	return content, debug, nil
+}
+
+// retryEmptyAnalysis 记录第 attempt 次请求返回空内容，返回是否还可以重试
+func retryEmptyAnalysis(attempt int, debug *models.DeepSeekDebug) bool {
+	var finishReason string
+	if debug != nil {
+		finishReason = debug.FinishReason
+	}
+	if attempt >= maxEmptyAnalysisRetries {
+		log.Printf("DeepSeek API 连续 %d 次返回空内容 (finish_reason: %s)", attempt+1, finishReason)
+		return false
+	}
+	log.Printf("DeepSeek API 返回空内容 (finish_reason: %s)，调整提示词后重试 (%d/%d)", finishReason, attempt+1, maxEmptyAnalysisRetries)
+	return true
+}
+
+// parseDeepSeekResponse 从非流式响应中取出第一个选项的文本内容。响应结构不符合预期
+// （如 content 为 null 或只有 tool_calls）时返回描述性错误，而不是在类型断言时 panic
+func parseDeepSeekResponse(body []byte) (string, *models.DeepSeekDebug, error) {
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", nil, fmt.Errorf("DeepSeek API 响应不是有效的 JSON: %w", err)
+	}
+
+	choices, ok := result["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return "", nil, fmt.Errorf("无效的API响应格式: 缺少 choices")
+	}
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("无效的API响应格式: choices[0] 类型为 %T", choices[0])
+	}
+	message, ok := choice["message"].(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("无效的API响应格式: 缺少 message")
+	}
+	content, ok := message["content"].(string)
+	if !ok {
+		if _, hasToolCalls := message["tool_calls"]; hasToolCalls {
+			return "", nil, fmt.Errorf("DeepSeek API 返回了工具调用而不是文本内容")
+		}
+		return "", nil, fmt.Errorf("无效的API响应格式: message.content 类型为 %T", message["content"])
+	}
+
+	debug := &models.DeepSeekDebug{RawResponse: body}
+	debug.Model, _ = result["model"].(string)
+	debug.FinishReason, _ = choice["finish_reason"].(string)
+	debug.Usage, _ = result["usage"].(map[string]interface{})
+	return content, debug, nil
+}
+
+// deepseekStreamChunk DeepSeek 流式响应中的单个数据块（仅解析需要的字段）
+type deepseekStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage map[string]interface{} `json:"usage"`
+}
+
+// streamArchitectPrompt 以流式方式生成架构分析，每收到一段文本调用一次 onDelta，返回完整的分析文本。
+// 流式响应没有完整的原始响应体，调试信息中的 RawResponse 为空。内容为空时的重试规则与 generateArchitectPrompt 相同
+func (pg *PromptGenerator) streamArchitectPrompt(dirStructure string, docs []models.Document, onDelta func(string)) ([]string, *models.DeepSeekDebug, error) {
 	if pg.deepseekAPIKey == "" {
-		return []string{"请配置 DeepSeek API 密钥以启用提示词生成功能"}, nil
+		onDelta(noAPIKeyMessage)
+		return []string{noAPIKeyMessage}, nil, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		requestBody, err := architectRequest(dirStructure, docs, true, attempt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		content, debug, err := pg.streamArchitectOnce(requestBody, onDelta)
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.TrimSpace(content) != "" {
+			log.Printf("成功从 DeepSeek API 获取流式响应，长度: %d 字节", len(content))
+			return []string{content}, debug, nil
+		}
+		if !retryEmptyAnalysis(attempt, debug) {
+			return nil, debug, models.ErrEmptyAnalysis
+		}
+	}
+}
+
+// streamArchitectOnce 发送一次流式请求，每收到一段文本调用一次 onDelta，返回完整的分析文本
+func (pg *PromptGenerator) streamArchitectOnce(requestBody []byte, onDelta func(string)) (string, *models.DeepSeekDebug, error) {
+	// 名额一直占用到响应读取完毕
+	if err := pg.limiter.Acquire(); err != nil {
+		log.Print("DeepSeek 请求排队超时")
+		return "", nil, err
+	}
+	defer pg.limiter.Release()
+
+	resp, err := pg.postDeepSeek(requestBody)
+	if err != nil {
+		return "", nil, err
 	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	debug := &models.DeepSeekDebug{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			// 跳过空行和 ": keep-alive" 注释
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk deepseekStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Printf("解析 DeepSeek 流式响应失败: %v", err)
+			return "", nil, fmt.Errorf("解析流式响应失败: %w", err)
+		}
+		if chunk.Model != "" {
+			debug.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			debug.Usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				debug.FinishReason = choice.FinishReason
+			}
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("读取 DeepSeek 流式响应失败: %v", err)
+		return "", nil, fmt.Errorf("读取流式响应失败: %w", err)
+	}
+	return content.String(), debug, nil
+}
 
+// noAPIKeyMessage 未配置 DeepSeek API 密钥时返回的提示
+const noAPIKeyMessage = "请配置 DeepSeek API 密钥以启用提示词生成功能"
+
+// architectRequest 构建生成架构分析的 DeepSeek 请求体，stream 为 true 时请求流式响应。
+// attempt 大于 0 表示之前的请求返回了空内容，此时在用户提示中强调必须输出分析正文并略微提高温度
+func architectRequest(dirStructure string, docs []models.Document, stream bool, attempt int) ([]byte, error) {
 	// 构建请求内容
 	var docsContent string
 	log.Printf("准备处理 %d 个文档", len(docs))
@@ -290,9 +628,14 @@ func (pg *PromptGenerator) generateArchitectPrompt(dirStructure string, docs []m
 2. 项目文档：
 %s`, dirStructure, docsContent)
 
-	// 调用 DeepSeek API
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"model": "deepseek-chat",
+	temperature := 0.1 // 降低温度增加确定性
+	if attempt > 0 {
+		userPrompt += "\n\n注意：上一次请求没有返回任何内容。请直接输出完整的架构分析正文，不要返回空内容。"
+		temperature += 0.2 * float64(attempt)
+	}
+
+	requestFields := map[string]interface{}{
+		"model": deepseekModel,
 		"messages": []map[string]string{
 			{
 				"role":    "system",
@@ -303,13 +646,17 @@ func (pg *PromptGenerator) generateArchitectPrompt(dirStructure string, docs []m
 				"content": userPrompt,
 			},
 		},
-		"temperature": 0.1,  // 降低温度增加确定性
+		"temperature": temperature,
 		"max_tokens":  1500, // 减少输出长度
-	})
-	if err != nil {
-		return nil, err
 	}
+	if stream {
+		requestFields["stream"] = true
+	}
+	return json.Marshal(requestFields)
+}
 
+// postDeepSeek 调用 DeepSeek 对话接口，状态码不为 200 时读取响应体并返回错误
+func (pg *PromptGenerator) postDeepSeek(requestBody []byte) (*http.Response, error) {
 	log.Printf("准备调用 DeepSeek API，请求大小: %d 字节", len(requestBody))
 	req, err := http.NewRequest("POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(requestBody))
 	if err != nil {
@@ -319,54 +666,59 @@ func (pg *PromptGenerator) generateArchitectPrompt(dirStructure string, docs []m
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+pg.deepseekAPIKey)
 
-	// 增加超时时间
-	client := &http.Client{Timeout: 120 * time.Second}
-	log.Print("发送请求到 DeepSeek API，超时设置: 120秒")
-	resp, err := client.Do(req)
+	log.Print("发送请求到 DeepSeek API")
+	stats.AICall()
+	resp, err := pg.httpClient.Do(req)
 	if err != nil {
 		log.Printf("调用 DeepSeek API 失败: %v", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		log.Printf("DeepSeek API 返回错误: 状态码 %d, 响应: %s", resp.StatusCode, string(body))
 		return nil, fmt.Errorf("API调用失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
 	}
+	return resp, nil
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("解析 DeepSeek API 响应失败: %v", err)
+// ListDeepSeekModels 使用指定的 API 密钥获取 DeepSeek 可访问的模型列表，用于校验密钥是否有效。
+// 密钥被拒绝时返回 models.ErrInvalidAPIKey
+func (pg *PromptGenerator) ListDeepSeekModels(apiKey string) ([]string, error) {
+	req, err := http.NewRequest("GET", "https://api.deepseek.com/models", nil)
+	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	// 解析响应
-	choices, ok := result["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		log.Print("DeepSeek API 响应格式无效")
-		return nil, fmt.Errorf("无效的API响应格式")
+	resp, err := pg.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 DeepSeek 模型列表失败: %w", err)
 	}
+	defer resp.Body.Close()
 
-	choice := choices[0].(map[string]interface{})
-	message := choice["message"].(map[string]interface{})
-	content := message["content"].(string)
-
-	log.Printf("成功从 DeepSeek API 获取响应，长度: %d 字节", len(content))
-	// 将响应作为一个完整的提示词返回
-	return []string{content}, nil
-}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, models.ErrInvalidAPIKey
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("DeepSeek API 返回错误: %s: %s", resp.Status, string(body))
+	}
 
-// 格式化文件大小
-func formatFileSize(size int64) string {
-	const unit = 1024
-	if size < unit {
-		return fmt.Sprintf("%d B", size)
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
 	}
-	div, exp := int64(unit), 0
-	for n := size / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("解析模型列表失败: %w", err)
+	}
+
+	names := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		names = append(names, m.ID)
 	}
-	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+	return names, nil
 }
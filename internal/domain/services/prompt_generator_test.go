@@ -0,0 +1,96 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDeepSeekResponseMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "invalid json",
+			body:    `{"choices": [`,
+			wantErr: "不是有效的 JSON",
+		},
+		{
+			name:    "missing choices",
+			body:    `{"model": "deepseek-chat"}`,
+			wantErr: "缺少 choices",
+		},
+		{
+			name:    "empty choices",
+			body:    `{"choices": []}`,
+			wantErr: "缺少 choices",
+		},
+		{
+			name:    "choices is not an array",
+			body:    `{"choices": {"message": {"content": "x"}}}`,
+			wantErr: "缺少 choices",
+		},
+		{
+			name:    "non-object choice",
+			body:    `{"choices": ["x"]}`,
+			wantErr: "choices[0] 类型为 string",
+		},
+		{
+			name:    "missing message",
+			body:    `{"choices": [{"finish_reason": "stop"}]}`,
+			wantErr: "缺少 message",
+		},
+		{
+			name:    "tool_calls with null content",
+			body:    `{"choices": [{"message": {"content": null, "tool_calls": [{"id": "call_1", "type": "function"}]}}]}`,
+			wantErr: "工具调用",
+		},
+		{
+			name:    "null content",
+			body:    `{"choices": [{"message": {"content": null}}]}`,
+			wantErr: "message.content 类型为 <nil>",
+		},
+		{
+			name:    "non-string content",
+			body:    `{"choices": [{"message": {"content": [{"type": "text", "text": "x"}]}}]}`,
+			wantErr: "message.content 类型为 []interface {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("parseDeepSeekResponse panicked: %v", r)
+				}
+			}()
+
+			content, debug, err := parseDeepSeekResponse([]byte(tt.body))
+			if err == nil {
+				t.Fatalf("expected error, got content %q", content)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q does not contain %q", err, tt.wantErr)
+			}
+			if content != "" || debug != nil {
+				t.Errorf("expected empty result on error, got content %q, debug %v", content, debug)
+			}
+		})
+	}
+}
+
+func TestParseDeepSeekResponse(t *testing.T) {
+	body := `{"model": "deepseek-chat", "choices": [{"message": {"content": "分析结果"}, "finish_reason": "stop"}], "usage": {"total_tokens": 10}}`
+
+	content, debug, err := parseDeepSeekResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content != "分析结果" {
+		t.Errorf("content = %q, want %q", content, "分析结果")
+	}
+	if debug.Model != "deepseek-chat" || debug.FinishReason != "stop" || debug.Usage["total_tokens"] != float64(10) {
+		t.Errorf("unexpected debug info: %+v", debug)
+	}
+}
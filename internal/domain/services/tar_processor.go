@@ -0,0 +1,241 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/ignore"
+
+	"github.com/ulikunitz/xz"
+)
+
+// tarEntry 读取到内存、等待按忽略文件过滤的 tar 文件
+type tarEntry struct {
+	path    string
+	content []byte
+}
+
+// bzip2Magic bzip2 数据的前三个字节，之后为块大小 '1'-'9'
+var bzip2Magic = []byte("BZh")
+
+// xzMagic xz 数据的文件头
+var xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+// unsupportedFormats 能按魔数识别但不支持的压缩或归档格式
+var unsupportedFormats = []struct {
+	magic []byte
+	name  string
+}{
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+	{[]byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, "7z"},
+	{[]byte("Rar!\x1a\x07"), "rar"},
+}
+
+// isBzip2 判断数据是否以 bzip2 头开始
+func isBzip2(header []byte) bool {
+	return len(header) > len(bzip2Magic) && bytes.HasPrefix(header, bzip2Magic) && header[3] >= '1' && header[3] <= '9'
+}
+
+// unsupportedFormat 返回 header 对应的不支持格式的名称，未识别时返回空字符串
+func unsupportedFormat(header []byte) string {
+	for _, format := range unsupportedFormats {
+		if bytes.HasPrefix(header, format.magic) {
+			return format.name
+		}
+	}
+	return ""
+}
+
+// decompress 按魔数为数据套上 gzip、bzip2 或 xz 解压，未压缩时原样返回；不支持的压缩格式返回 models.ErrUnsupportedArchive
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(8)
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取gzip数据: %w", err)
+		}
+		return gz, nil
+	case isBzip2(header):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(header, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取xz数据: %w", err)
+		}
+		return xr, nil
+	}
+	if format := unsupportedFormat(header); format != "" {
+		return nil, fmt.Errorf("%w: %s", models.ErrUnsupportedArchive, format)
+	}
+	return br, nil
+}
+
+// extractLimitReader 限制解压后读取的总字节数，超出时返回 models.ErrExtractedTooLarge，
+// 防止高压缩比的小请求体解压出大量数据。tar 头和跳过的文件内容同样计入
+type extractLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *extractLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, models.ErrExtractedTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// isCompressedTar 判断 gzip、bzip2 或 xz 压缩的数据解压后是否为 tar 归档，只解压开头的一个块
+func isCompressedTar(r io.Reader) bool {
+	decompressed, err := decompress(r)
+	if err != nil {
+		return false
+	}
+	header := make([]byte, 262)
+	n, _ := io.ReadFull(decompressed, header)
+	return isTarHeader(header[:n])
+}
+
+// ProcessTarStream 从流中顺序读取 tar 归档（gzip、bzip2 或 xz 压缩时按魔数自动解压），过滤规则与 ZIP 相同。
+// 流只能读取一次，忽略文件（如 .gitignore）可能出现在其作用的文件之后，
+// 因此先读取通过路径和大小检查的文件，读完整个归档后再按忽略文件过滤。
+// 解压后的数据超过 file_limits.max_extracted_size 时返回 models.ErrExtractedTooLarge
+func (fp *FileProcessor) ProcessTarStream(r io.Reader, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	r, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	r = &extractLimitReader{r: r, remaining: fp.config.GetMaxExtractedSize()}
+
+	wantedIgnoreFiles := make(map[string]bool, len(opts.IgnoreFiles))
+	for _, name := range opts.IgnoreFiles {
+		wantedIgnoreFiles[name] = true
+	}
+
+	root := models.NewRootNode(fp.config.GetMaxTreeNodes())
+	fileContents := make(map[string]models.FileContent)
+	matcher := ignore.New()
+	var entries []tarEntry
+	var symlinks []tarEntry
+
+	reader := tar.NewReader(r)
+	for {
+		hdr, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("无法读取tar数据: %w", err)
+		}
+
+		filePath, ok := tarEntryPath(hdr.Name)
+		if !ok {
+			log.Print("排除 (不安全的路径): " + hdr.Name)
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeSymlink:
+			if opts.IncludeSymlinks && !opts.IsExcludedDir(filePath) {
+				symlinks = append(symlinks, tarEntry{path: filePath, content: []byte(hdr.Linkname)})
+			} else {
+				log.Print("排除 (符号链接): " + filePath)
+			}
+			continue
+		default:
+			// 目录、硬链接、设备文件等不作为文件读取
+			continue
+		}
+
+		// 与 ZIP 相同，忽略文件本身仍按常规规则决定是否收录
+		if wantedIgnoreFiles[path.Base(filePath)] {
+			content, err := io.ReadAll(io.LimitReader(reader, maxIgnoreFileSize))
+			if err != nil {
+				return nil, fmt.Errorf("读取忽略文件 %s 失败: %w", filePath, err)
+			}
+			matcher.Add(path.Dir(filePath), content)
+			log.Printf("已加载忽略文件: %s", filePath)
+			if readLimit, ok := fp.checkFile(filePath, uint64(len(content)), opts); ok && !opts.IsExcludedDir(filePath) && int64(len(content)) <= readLimit {
+				entries = append(entries, tarEntry{path: filePath, content: content})
+			}
+			continue
+		}
+
+		if opts.IsExcludedDir(filePath) {
+			log.Print("排除 (请求排除目录): " + filePath)
+			continue
+		}
+		readLimit, ok := fp.checkFile(filePath, uint64(hdr.Size), opts)
+		if !ok {
+			continue
+		}
+
+		contentBytes, err := io.ReadAll(io.LimitReader(reader, readLimit+1))
+		if err != nil {
+			// 流中的读取错误无法跳过该文件继续读取后续内容
+			return nil, fmt.Errorf("读取文件 %s 失败: %w", filePath, err)
+		}
+		if int64(len(contentBytes)) > readLimit {
+			log.Print("排除 (文件内容超限): " + filePath)
+			continue
+		}
+		entries = append(entries, tarEntry{path: filePath, content: contentBytes})
+	}
+
+	for _, entry := range entries {
+		if matcher.Match(entry.path, false) {
+			log.Print("排除 (忽略文件): " + entry.path)
+			continue
+		}
+		fp.addContent(root, fileContents, entry.path, entry.content, opts)
+	}
+	for _, link := range symlinks {
+		if matcher.Match(link.path, false) {
+			continue
+		}
+		node := root.AddPath(link.path)
+		node.Type = "symlink"
+		node.LinkTarget = string(link.content)
+		log.Printf("已处理 (符号链接): %s -> %s", link.path, node.LinkTarget)
+	}
+
+	result := &models.ProcessResult{
+		FileTree:     root,
+		FileContents: fileContents,
+		Warnings:     LineEndingWarnings(fileContents),
+	}
+	result.UpdateContentHash()
+	return result, nil
+}
+
+// tarEntryPath 将 tar 条目名转换为项目内的相对路径（去掉开头的 "./" 和 "/"），
+// 包含 ".." 的路径返回 false，避免与其他文件冲突或指向项目之外
+func tarEntryPath(name string) (string, bool) {
+	cleaned := path.Clean("/" + strings.TrimPrefix(name, "./"))
+	if cleaned == "/" {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return strings.TrimPrefix(cleaned, "/"), true
+}
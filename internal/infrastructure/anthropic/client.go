@@ -0,0 +1,357 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/internal/infrastructure/gemini"
+	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
+	"repo-prompt-web/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// apiVersion 请求头 anthropic-version 的值
+const apiVersion = "2023-06-01"
+
+// 重试策略：网络错误、429 和 5xx（含 529 过载）时等待 retryDelay 后重试，每次翻倍
+const (
+	maxRetries = 3
+	retryDelay = 2 * time.Second
+)
+
+// Client 是 Anthropic Messages API 客户端，实现代码问答使用的 LLMClient
+type Client struct {
+	apiKey     string
+	endpoint   string
+	model      string
+	maxTokens  int
+	httpClient *http.Client
+}
+
+// message Messages API 中的一条消息
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// messagesRequest Messages API 请求结构
+type messagesRequest struct {
+	Model     string    `json:"model"`
+	MaxTokens int       `json:"max_tokens"`
+	Messages  []message `json:"messages"`
+	Stream    bool      `json:"stream,omitempty"`
+}
+
+// usage Messages API 返回的 token 用量
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// messagesResponse Messages API 非流式响应结构
+type messagesResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      usage  `json:"usage"`
+}
+
+// apiError 错误响应和流式 error 事件中的错误信息
+type apiError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// streamEvent 流式响应中 data 行的结构，不同事件类型只使用其中部分字段
+type streamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage usage `json:"usage"`
+	} `json:"message"` // message_start
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"` // content_block_delta、message_delta
+	Usage usage `json:"usage"` // message_delta
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"` // error
+}
+
+// NewClient 创建 Anthropic 客户端，HTTP 客户端优先使用配置的代理，否则使用系统代理
+func NewClient(cfg *config.Config, clients *httpclient.Factory) *Client {
+	return &Client{
+		apiKey:    cfg.GetAnthropicAPIKey(),
+		endpoint:  cfg.GetAnthropicApiEndpoint(),
+		model:     cfg.GetAnthropicModel(),
+		maxTokens: cfg.GetAnthropicMaxTokens(),
+		httpClient: clients.Client(httpclient.Options{
+			Name:     "Anthropic",
+			Timeout:  180 * time.Second,
+			ProxyURL: cfg.GetAnthropicProxyURL(),
+			// 非流式的 Messages 请求生成完整回答后才返回响应头，不受连接池的响应头超时限制
+			ResponseHeaderTimeout: httpclient.NoResponseHeaderTimeout,
+		}),
+	}
+}
+
+// Provider 返回 AI 服务提供方名称
+func (c *Client) Provider() string {
+	return "anthropic"
+}
+
+// Model 返回生成内容使用的模型名称
+func (c *Client) Model() string {
+	return c.model
+}
+
+// SendPrompt 发送提示词到 Messages API，返回所有文本内容块拼接后的回答
+func (c *Client) SendPrompt(requestID, prompt string) (string, error) {
+	response, _, err := c.SendPromptWithUsage(requestID, prompt)
+	return response, err
+}
+
+// SendPromptWithUsage 发送提示词到 Messages API，同时返回转换为 gemini.UsageMetadata 的 token 用量
+func (c *Client) SendPromptWithUsage(requestID, prompt string) (string, *gemini.UsageMetadata, error) {
+	if c.apiKey == "" {
+		return "", nil, fmt.Errorf("Anthropic API 密钥未配置")
+	}
+
+	logger.Debug("准备发送提示词到 Anthropic API",
+		zap.String("request_id", requestID),
+		zap.String("model", c.model),
+		zap.Int("prompt_length", len(prompt)))
+
+	resp, err := c.post(requestID, prompt, false)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	var result messagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", nil, fmt.Errorf("API 返回空响应 (stop_reason: %s)", result.StopReason)
+	}
+
+	logger.Debug("从 Anthropic 收到响应",
+		zap.String("request_id", requestID),
+		zap.Int("response_length", text.Len()),
+		zap.String("stop_reason", result.StopReason),
+		zap.Int("input_tokens", result.Usage.InputTokens),
+		zap.Int("output_tokens", result.Usage.OutputTokens))
+	return text.String(), &gemini.UsageMetadata{
+		PromptTokenCount:     result.Usage.InputTokens,
+		CandidatesTokenCount: result.Usage.OutputTokens,
+		TotalTokenCount:      result.Usage.InputTokens + result.Usage.OutputTokens,
+	}, nil
+}
+
+// SendPromptStream 流式发送提示词到 Messages API。content_block_delta 事件的文本作为 StreamChunk.Text 发送，
+// message_delta 事件的停止原因和 token 用量在最后一个片段中发送
+func (c *Client) SendPromptStream(requestID, prompt string) (<-chan gemini.StreamChunk, error) {
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("Anthropic API 密钥未配置")
+	}
+
+	logger.Debug("准备流式发送提示词到 Anthropic API",
+		zap.String("request_id", requestID),
+		zap.String("model", c.model),
+		zap.Int("prompt_length", len(prompt)))
+
+	resultChan := make(chan gemini.StreamChunk, 100)
+	go func() {
+		defer close(resultChan)
+
+		resp, err := c.post(requestID, prompt, true)
+		if err != nil {
+			resultChan <- gemini.StreamChunk{Error: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		var inputTokens int
+		for scanner.Scan() {
+			line := scanner.Text()
+			// 事件类型同时出现在 data 的 type 字段中，只需解析 data 行
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+			var event streamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				resultChan <- gemini.StreamChunk{Error: fmt.Errorf("解析流式响应失败: %w", err)}
+				return
+			}
+
+			switch event.Type {
+			case "message_start":
+				inputTokens = event.Message.Usage.InputTokens
+			case "content_block_delta":
+				if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+					resultChan <- gemini.StreamChunk{Text: event.Delta.Text}
+				}
+			case "message_delta":
+				resultChan <- gemini.StreamChunk{
+					FinishReason: event.Delta.StopReason,
+					Usage: &gemini.UsageMetadata{
+						PromptTokenCount:     inputTokens,
+						CandidatesTokenCount: event.Usage.OutputTokens,
+						TotalTokenCount:      inputTokens + event.Usage.OutputTokens,
+					},
+				}
+			case "message_stop":
+				return
+			case "error":
+				resultChan <- gemini.StreamChunk{Error: fmt.Errorf("API 返回错误: %s: %s", event.Error.Type, event.Error.Message)}
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			resultChan <- gemini.StreamChunk{Error: fmt.Errorf("读取流式响应失败: %w", err)}
+		}
+	}()
+
+	return resultChan, nil
+}
+
+// post 发送 Messages API 请求并返回状态码为 200 的响应。网络错误、429 和 5xx 时按退避策略重试
+func (c *Client) post(requestID, prompt string, stream bool) (*http.Response, error) {
+	reqJSON, err := json.Marshal(messagesRequest{
+		Model:     c.model,
+		MaxTokens: c.maxTokens,
+		Messages:  []message{{Role: "user", Content: prompt}},
+		Stream:    stream,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	delay := retryDelay
+	var lastErr error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			logger.Info("重试 Anthropic API 请求",
+				zap.String("request_id", requestID),
+				zap.Int("attempt", attempt+1),
+				zap.Int("max_retries", maxRetries),
+				zap.Error(lastErr))
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		req, err := http.NewRequest("POST", c.endpoint+"/messages", bytes.NewReader(reqJSON))
+		if err != nil {
+			return nil, fmt.Errorf("创建请求失败: %w", err)
+		}
+		c.setHeaders(req, c.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		if stream {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("请求失败: %w", err)
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		lastErr = responseError(resp)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// EmbedTexts Anthropic 不提供向量接口，始终返回错误，调用方回退到默认文件选取
+func (c *Client) EmbedTexts(requestID string, texts []string) ([][]float32, error) {
+	return nil, fmt.Errorf("Anthropic 不支持向量检索，请关闭 embeddings 或使用 gemini")
+}
+
+// ListModels 使用指定的 API 密钥获取可访问的模型列表，用于校验密钥是否有效。
+// 密钥被拒绝时返回 models.ErrInvalidAPIKey，密钥只用于本次请求，不会保存
+func (c *Client) ListModels(apiKey string) ([]string, error) {
+	req, err := http.NewRequest("GET", c.endpoint+"/models?limit=1000", nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	c.setHeaders(req, apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 Anthropic 模型列表失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, models.ErrInvalidAPIKey
+	default:
+		return nil, responseError(resp)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("解析模型列表失败: %w", err)
+	}
+
+	names := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		names = append(names, m.ID)
+	}
+	return names, nil
+}
+
+// setHeaders 设置认证和版本请求头
+func (c *Client) setHeaders(req *http.Request, apiKey string) {
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", apiVersion)
+}
+
+// responseError 将非 200 响应转换为错误，优先使用响应体中的错误类型和消息
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var apiErr apiError
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.Message != "" {
+		return fmt.Errorf("API 返回错误: %s: %s: %s", resp.Status, apiErr.Error.Type, apiErr.Error.Message)
+	}
+	return fmt.Errorf("API 返回错误: %s: %s", resp.Status, string(body))
+}
@@ -6,10 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
-	"net/url"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
 	"repo-prompt-web/pkg/logger"
 	"strings"
 	"time"
@@ -19,10 +18,18 @@ import (
 
 // Client 是 Gemini API 客户端
 type Client struct {
-	apiKey     string
-	apiUrl     string
-	model      string
-	httpClient *http.Client
+	apiKey         string
+	apiUrl         string
+	modelsUrl      string // 模型列表接口，用于校验 API 密钥
+	model          string
+	embeddingUrl   string
+	embeddingModel string
+	httpClient     *http.Client
+
+	// 重试策略：失败后等待 retryDelay 再重试，每次翻倍
+	maxRetries       int
+	streamMaxRetries int
+	retryDelay       time.Duration
 }
 
 // GeminiRequest Gemini API 请求结构
@@ -54,70 +61,68 @@ type GeminiResponse struct {
 	PromptFeedback struct {
 		BlockReason string `json:"blockReason,omitempty"`
 	} `json:"promptFeedback"`
+	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+// UsageMetadata 表示 Gemini 返回的 token 用量
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
 }
 
 // StreamChunk 表示流式响应的一个片段
 type StreamChunk struct {
 	Text         string
 	FinishReason string
+	Usage        *UsageMetadata // 用量信息，通常只在最后的片段中提供
 	Error        error
 }
 
-// getProxy 获取代理配置
-func getProxy(cfg *config.Config) func(*http.Request) (*url.URL, error) {
-	// 检查配置中是否有明确的代理设置
-	proxyURL := cfg.GetGeminiProxyURL()
-	if proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			logger.Warn("无效的代理URL配置，将使用系统代理",
-				zap.String("proxy_url", proxyURL),
-				zap.Error(err))
-			return http.ProxyFromEnvironment
-		}
-		logger.Info("使用配置的Gemini API代理",
-			zap.String("proxy_url", proxyURL))
-		return http.ProxyURL(proxy)
+// NewClient 创建一个新的 Gemini 客户端，HTTP 客户端优先使用配置的代理，否则使用系统代理
+func NewClient(cfg *config.Config, clients *httpclient.Factory) *Client {
+	return &Client{
+		apiKey:         cfg.GetGeminiAPIKey(),
+		apiUrl:         fmt.Sprintf("%s/%s:generateContent", cfg.GetGeminiApiEndpoint(), cfg.GetGeminiModel()),
+		modelsUrl:      cfg.GetGeminiApiEndpoint(),
+		model:          cfg.GetGeminiModel(),
+		embeddingUrl:   fmt.Sprintf("%s/%s:batchEmbedContents", cfg.GetEmbeddingsApiEndpoint(), cfg.GetEmbeddingsModel()),
+		embeddingModel: cfg.GetEmbeddingsModel(),
+		httpClient: clients.Client(httpclient.Options{
+			Name:     "Gemini",
+			Timeout:  180 * time.Second, // 增加整体超时时间到3分钟
+			ProxyURL: cfg.GetGeminiProxyURL(),
+		}),
+		maxRetries:       cfg.GetGeminiMaxRetries(),
+		streamMaxRetries: cfg.GetGeminiStreamMaxRetries(),
+		retryDelay:       cfg.GetGeminiRetryDelay(),
 	}
-
-	// 否则使用系统环境变量中的代理
-	return http.ProxyFromEnvironment
 }
 
-// NewClient 创建一个新的 Gemini 客户端
-func NewClient(cfg *config.Config) *Client {
-	// 创建一个带有自定义传输层的HTTP客户端
-	transport := &http.Transport{
-		Proxy: getProxy(cfg), // 使用代理配置
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second, // 连接超时时间
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   30 * time.Second, // 增加TLS握手超时时间
-		ExpectContinueTimeout: 1 * time.Second,
-		ResponseHeaderTimeout: 60 * time.Second,
-	}
+// Provider 返回 AI 服务提供方名称
+func (c *Client) Provider() string {
+	return "gemini"
+}
 
-	return &Client{
-		apiKey: cfg.GetGeminiAPIKey(),
-		apiUrl: fmt.Sprintf("%s/%s:generateContent", cfg.GetGeminiApiEndpoint(), cfg.GetGeminiModel()),
-		model:  cfg.GetGeminiModel(),
-		httpClient: &http.Client{
-			Transport: transport,
-			Timeout:   180 * time.Second, // 增加整体超时时间到3分钟
-		},
-	}
+// Model 返回生成内容使用的模型名称
+func (c *Client) Model() string {
+	return c.model
 }
 
 // SendPrompt 发送提示词到 Gemini API
-func (c *Client) SendPrompt(prompt string) (string, error) {
+func (c *Client) SendPrompt(requestID, prompt string) (string, error) {
+	response, _, err := c.SendPromptWithUsage(requestID, prompt)
+	return response, err
+}
+
+// SendPromptWithUsage 发送提示词到 Gemini API，同时返回 token 用量（响应中没有用量信息时为 nil）
+func (c *Client) SendPromptWithUsage(requestID, prompt string) (string, *UsageMetadata, error) {
 	if c.apiKey == "" {
-		return "", fmt.Errorf("Gemini API 密钥未配置")
+		return "", nil, fmt.Errorf("Gemini API 密钥未配置")
 	}
 
 	logger.Debug("准备发送提示词到 Gemini API",
+		zap.String("request_id", requestID),
 		zap.String("model", c.model),
 		zap.Int("prompt_length", len(prompt)))
 
@@ -136,17 +141,19 @@ func (c *Client) SendPrompt(prompt string) (string, error) {
 
 	reqJSON, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("序列化请求失败: %w", err)
+		return "", nil, fmt.Errorf("序列化请求失败: %w", err)
 	}
 
 	// 添加重试逻辑
 	var response string
-	maxRetries := 3
-	retryDelay := 2 * time.Second
+	var usage *UsageMetadata
+	maxRetries := c.maxRetries
+	retryDelay := c.retryDelay
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
 			logger.Info("重试 Gemini API 请求",
+				zap.String("request_id", requestID),
 				zap.Int("attempt", attempt+1),
 				zap.Int("max_retries", maxRetries))
 			time.Sleep(retryDelay)
@@ -157,7 +164,7 @@ func (c *Client) SendPrompt(prompt string) (string, error) {
 		// 构建请求
 		req, err := http.NewRequest("POST", c.apiUrl, bytes.NewBuffer(reqJSON))
 		if err != nil {
-			return "", fmt.Errorf("创建请求失败: %w", err)
+			return "", nil, fmt.Errorf("创建请求失败: %w", err)
 		}
 
 		// 添加查询参数和请求头
@@ -171,6 +178,7 @@ func (c *Client) SendPrompt(prompt string) (string, error) {
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			logger.Warn("Gemini API 请求失败, 将重试",
+				zap.String("request_id", requestID),
 				zap.Error(err),
 				zap.Int("attempt", attempt+1),
 				zap.Int("max_retries", maxRetries))
@@ -187,13 +195,14 @@ func (c *Client) SendPrompt(prompt string) (string, error) {
 			// 如果是服务器错误(5xx)，尝试重试
 			if resp.StatusCode >= 500 && attempt < maxRetries-1 {
 				logger.Warn("Gemini API 服务器错误, 将重试",
+					zap.String("request_id", requestID),
 					zap.Int("status_code", resp.StatusCode),
 					zap.Int("attempt", attempt+1),
 					zap.Int("max_retries", maxRetries))
 				continue // 重试
 			}
 
-			return "", fmt.Errorf(errMsg)
+			return "", nil, fmt.Errorf(errMsg)
 		}
 
 		// 解析响应
@@ -201,33 +210,37 @@ func (c *Client) SendPrompt(prompt string) (string, error) {
 		if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
 			if attempt < maxRetries-1 {
 				logger.Warn("解析 Gemini 响应失败, 将重试",
+					zap.String("request_id", requestID),
 					zap.Error(err),
 					zap.Int("attempt", attempt+1),
 					zap.Int("max_retries", maxRetries))
 				continue // 重试
 			}
-			return "", fmt.Errorf("解析响应失败: %w", err)
+			return "", nil, fmt.Errorf("解析响应失败: %w", err)
 		}
 
 		// 检查是否被阻止
 		if geminiResp.PromptFeedback.BlockReason != "" {
-			return "", fmt.Errorf("提示词被阻止: %s", geminiResp.PromptFeedback.BlockReason)
+			return "", nil, fmt.Errorf("提示词被阻止: %s", geminiResp.PromptFeedback.BlockReason)
 		}
 
 		// 检查是否有有效响应
 		if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
 			if attempt < maxRetries-1 {
 				logger.Warn("Gemini API 返回空响应, 将重试",
+					zap.String("request_id", requestID),
 					zap.Int("attempt", attempt+1),
 					zap.Int("max_retries", maxRetries))
 				continue // 重试
 			}
-			return "", fmt.Errorf("API 返回空响应")
+			return "", nil, fmt.Errorf("API 返回空响应")
 		}
 
 		response = geminiResp.Candidates[0].Content.Parts[0].Text
+		usage = geminiResp.UsageMetadata
 
 		logger.Debug("从 Gemini 收到响应",
+			zap.String("request_id", requestID),
 			zap.Int("response_length", len(response)),
 			zap.String("finish_reason", geminiResp.Candidates[0].FinishReason))
 
@@ -235,19 +248,20 @@ func (c *Client) SendPrompt(prompt string) (string, error) {
 	}
 
 	if response == "" {
-		return "", fmt.Errorf("Gemini API 请求失败，已达到最大重试次数")
+		return "", nil, fmt.Errorf("Gemini API 请求失败，已达到最大重试次数")
 	}
 
-	return response, nil
+	return response, usage, nil
 }
 
 // SendPromptStream 流式发送提示词到 Gemini API，支持实时响应
-func (c *Client) SendPromptStream(prompt string) (<-chan StreamChunk, error) {
+func (c *Client) SendPromptStream(requestID, prompt string) (<-chan StreamChunk, error) {
 	if c.apiKey == "" {
 		return nil, fmt.Errorf("Gemini API 密钥未配置")
 	}
 
 	logger.Debug("准备流式发送提示词到 Gemini API",
+		zap.String("request_id", requestID),
 		zap.String("model", c.model),
 		zap.Int("prompt_length", len(prompt)))
 
@@ -293,14 +307,16 @@ func (c *Client) SendPromptStream(prompt string) (<-chan StreamChunk, error) {
 		defer close(resultChan)
 
 		// 添加重试逻辑
-		maxRetries := 2 // 流式响应重试次数少一些
-		retryDelay := 2 * time.Second
+		maxRetries := c.streamMaxRetries
+		retryDelay := c.retryDelay
 
 		for attempt := 0; attempt < maxRetries; attempt++ {
 			if attempt > 0 {
 				logger.Info("重试流式 Gemini API 请求",
+					zap.String("request_id", requestID),
 					zap.Int("attempt", attempt+1),
-					zap.Int("max_retries", maxRetries))
+					zap.Int("max_retries", maxRetries),
+					zap.Duration("delay", retryDelay))
 				time.Sleep(retryDelay)
 				retryDelay *= 2
 			}
@@ -314,6 +330,7 @@ func (c *Client) SendPromptStream(prompt string) (<-chan StreamChunk, error) {
 			if err != nil {
 				if attempt < maxRetries-1 {
 					logger.Warn("流式 Gemini API 请求失败, 将重试",
+						zap.String("request_id", requestID),
 						zap.Error(err),
 						zap.Int("attempt", attempt+1),
 						zap.Int("max_retries", maxRetries))
@@ -335,6 +352,7 @@ func (c *Client) SendPromptStream(prompt string) (<-chan StreamChunk, error) {
 					// 如果是服务器错误(5xx)，尝试重试
 					if resp.StatusCode >= 500 && attempt < maxRetries-1 {
 						logger.Warn("流式 Gemini API 服务器错误, 将重试",
+							zap.String("request_id", requestID),
 							zap.Int("status_code", resp.StatusCode),
 							zap.Int("attempt", attempt+1),
 							zap.Int("max_retries", maxRetries))
@@ -390,6 +408,7 @@ func (c *Client) SendPromptStream(prompt string) (<-chan StreamChunk, error) {
 						chunk := StreamChunk{
 							Text:         streamResp.Candidates[0].Content.Parts[0].Text,
 							FinishReason: streamResp.Candidates[0].FinishReason,
+							Usage:        streamResp.UsageMetadata,
 						}
 						resultChan <- chunk
 
@@ -406,6 +425,7 @@ func (c *Client) SendPromptStream(prompt string) (<-chan StreamChunk, error) {
 				if err := scanner.Err(); err != nil {
 					if !successfulStream && attempt < maxRetries-1 {
 						logger.Warn("读取流失败, 将重试",
+							zap.String("request_id", requestID),
 							zap.Error(err),
 							zap.Int("attempt", attempt+1),
 							zap.Int("max_retries", maxRetries))
@@ -0,0 +1,111 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"repo-prompt-web/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// maxEmbedBatchSize batchEmbedContents 单次请求允许的最大文本数
+const maxEmbedBatchSize = 100
+
+// embedRequest 单条向量请求
+type embedRequest struct {
+	Model   string  `json:"model"`
+	Content Content `json:"content"`
+}
+
+// batchEmbedRequest 批量向量请求
+type batchEmbedRequest struct {
+	Requests []embedRequest `json:"requests"`
+}
+
+// batchEmbedResponse 批量向量响应
+type batchEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
+// EmbedTexts 计算一组文本的向量，返回结果与输入顺序一致
+func (c *Client) EmbedTexts(requestID string, texts []string) ([][]float32, error) {
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("Gemini API 密钥未配置")
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbedBatchSize {
+		end := start + maxEmbedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		batch, err := c.embedBatch(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	logger.Debug("计算文本向量完成",
+		zap.String("request_id", requestID),
+		zap.String("model", c.embeddingModel),
+		zap.Int("count", len(vectors)))
+	return vectors, nil
+}
+
+// embedBatch 发送一次批量向量请求
+func (c *Client) embedBatch(texts []string) ([][]float32, error) {
+	reqBody := batchEmbedRequest{Requests: make([]embedRequest, len(texts))}
+	for i, text := range texts {
+		reqBody.Requests[i] = embedRequest{
+			Model:   "models/" + c.embeddingModel,
+			Content: Content{Parts: []Part{{Text: text}}},
+		}
+	}
+
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("序列化向量请求失败: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.embeddingUrl, bytes.NewBuffer(reqJSON))
+	if err != nil {
+		return nil, fmt.Errorf("创建向量请求失败: %w", err)
+	}
+	q := req.URL.Query()
+	q.Add("key", c.apiKey)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("向量请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("向量 API 返回错误: %s (%d): %s", resp.Status, resp.StatusCode, string(bodyBytes))
+	}
+
+	var embedResp batchEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("解析向量响应失败: %w", err)
+	}
+	if len(embedResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("向量数量不匹配: 期望 %d, 实际 %d", len(texts), len(embedResp.Embeddings))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, embedding := range embedResp.Embeddings {
+		vectors[i] = embedding.Values
+	}
+	return vectors, nil
+}
@@ -2,6 +2,7 @@ package gemini
 
 import (
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
 	"sync"
 )
 
@@ -11,10 +12,10 @@ var (
 )
 
 // GetClient 获取Gemini客户端单例实例
-func GetClient(cfg *config.Config) *Client {
+func GetClient(cfg *config.Config, clients *httpclient.Factory) *Client {
 	// 只初始化一次
 	once.Do(func() {
-		instance = NewClient(cfg)
+		instance = NewClient(cfg, clients)
 	})
 	return instance
 }
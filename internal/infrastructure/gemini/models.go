@@ -0,0 +1,54 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+)
+
+// modelsResponse 模型列表接口的响应
+type modelsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// ListModels 使用指定的 API 密钥获取可访问的模型列表，用于校验密钥是否有效。
+// 密钥被拒绝时返回 models.ErrInvalidAPIKey，密钥只用于本次请求，不会保存
+func (c *Client) ListModels(apiKey string) ([]string, error) {
+	query := url.Values{}
+	query.Set("key", apiKey)
+	query.Set("pageSize", "1000")
+
+	resp, err := c.httpClient.Get(c.modelsUrl + "?" + query.Encode())
+	if err != nil {
+		// 错误信息中的 URL 包含密钥，不能原样返回
+		return nil, fmt.Errorf("请求 Gemini 模型列表失败")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return nil, models.ErrInvalidAPIKey
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Gemini API 返回错误: %s: %s", resp.Status, string(body))
+	}
+
+	var list modelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("解析模型列表失败: %w", err)
+	}
+
+	names := make([]string, 0, len(list.Models))
+	for _, m := range list.Models {
+		names = append(names, strings.TrimPrefix(m.Name, "models/"))
+	}
+	return names, nil
+}
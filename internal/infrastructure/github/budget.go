@@ -0,0 +1,43 @@
+package github
+
+import (
+	"errors"
+	"log"
+)
+
+// errBudgetExhausted 表示本次获取的 GitHub API 请求数已达上限
+var errBudgetExhausted = errors.New("GitHub API 请求数已达上限")
+
+// requestBudget 限制一次仓库获取（含子模块）发出的 GitHub API 请求数，
+// 防止单个仓库耗尽令牌的速率限制配额。nil 或 max 为 0 时不限制
+type requestBudget struct {
+	max       int
+	used      int
+	exhausted bool // 是否有请求因达到上限而未发出，结果因此不完整
+}
+
+// newRequestBudget 创建请求预算，max 为 0 表示不限制
+func newRequestBudget(max int) *requestBudget {
+	return &requestBudget{max: max}
+}
+
+// take 占用一次请求名额，名额已用完时返回 false 并将预算标记为已耗尽
+func (b *requestBudget) take() bool {
+	if b == nil || b.max <= 0 {
+		return true
+	}
+	if b.used >= b.max {
+		if !b.exhausted {
+			log.Printf("GitHub API 请求数已达上限 (%d)，停止获取，结果不完整", b.max)
+		}
+		b.exhausted = true
+		return false
+	}
+	b.used++
+	return true
+}
+
+// isExhausted 返回是否有请求因达到上限而未发出
+func (b *requestBudget) isExhausted() bool {
+	return b != nil && b.exhausted
+}
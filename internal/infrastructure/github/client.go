@@ -3,17 +3,23 @@ package github
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/internal/domain/services"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
+	"repo-prompt-web/pkg/ignore"
 )
 
 // Content 表示 GitHub API 响应
@@ -21,141 +27,241 @@ type Content struct {
 	Type        string `json:"type"`
 	Path        string `json:"path"`
 	Content     string `json:"content"`
+	Size        int64  `json:"size"`
+	Target      string `json:"target"` // type 为 symlink 时的链接目标
 	DownloadURL string `json:"download_url"`
 }
 
+// treeEntry GitHub git/trees 接口返回的单个条目
+type treeEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"` // 文件模式，符号链接为 120000
+	Type string `json:"type"` // blob、tree 或 commit（子模块）
+	SHA  string `json:"sha"`  // 对象 SHA，子模块为其指向的提交
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+// symlinkMode git 中符号链接的文件模式
+const symlinkMode = "120000"
+
+// isSymlink 判断条目是否为符号链接
+func (e treeEntry) isSymlink() bool {
+	return e.Type == "blob" && e.Mode == symlinkMode
+}
+
+// gitTree GitHub git/trees 接口的响应
+type gitTree struct {
+	Tree      []treeEntry `json:"tree"`
+	Truncated bool        `json:"truncated"`
+}
+
+// RepoTree 仓库的文件树，不包含文件内容
+type RepoTree struct {
+	Branch    string           `json:"branch"`
+	FileTree  *models.TreeNode `json:"file_tree"`
+	Truncated bool             `json:"truncated"`
+}
+
 // Client GitHub 客户端
 type Client struct {
-	config *config.Config
+	config        *config.Config
+	httpClient    *http.Client // REST 接口使用的客户端
+	graphqlClient *http.Client // GraphQL 批量查询使用的客户端，超时更长
 }
 
-// NewClient 创建 GitHub 客户端实例
-func NewClient(cfg *config.Config) *Client {
+// NewClient 创建 GitHub 客户端实例，两个 HTTP 客户端共享连接池
+func NewClient(cfg *config.Config, clients *httpclient.Factory) *Client {
 	return &Client{
-		config: cfg,
+		config:        cfg,
+		httpClient:    clients.Client(httpclient.Options{Name: "GitHub", Timeout: 20 * time.Second}),
+		graphqlClient: clients.Client(httpclient.Options{Name: "GitHub", Timeout: 60 * time.Second}),
 	}
 }
 
 // GetRepoContents 获取仓库内容
-func (c *Client) GetRepoContents(owner, repo, token string, useBase64 bool) (*models.ProcessResult, error) {
-	log.Printf("开始获取 GitHub 仓库内容: %s/%s", owner, repo)
+func (c *Client) GetRepoContents(info RepoInfo, token string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	return c.getRepo(info, token, opts, false)
+}
 
-	branches := []string{"main", "master"}
-	var lastError error
+// GetRepoDocuments 获取仓库完整文件树，但只下载生成架构分析所需的文档和重要文件。
+// opts 中的排除目录、忽略文件和跳过测试/生成代码等过滤规则同样生效
+func (c *Client) GetRepoDocuments(info RepoInfo, token string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	return c.getRepo(info, token, opts, true)
+}
 
-	for _, branch := range branches {
-		log.Printf("尝试分支: %s", branch)
-		tree, contents, err := c.getTreeContents(owner, repo, branch, token, useBase64)
+// GetRepoTree 只获取仓库的目录树（含文件大小和类型），不下载任何文件内容
+func (c *Client) GetRepoTree(info RepoInfo, token string) (*RepoTree, error) {
+	var lastError error
+	for _, branch := range c.candidateBranches(info, token) {
+		tree, err := c.fetchTree(info, branch, token)
 		if err != nil {
+			if errors.Is(err, models.ErrEmptyRepository) || errors.Is(err, models.ErrSSORequired) {
+				return nil, err
+			}
 			log.Printf("分支 %s 获取失败: %v", branch, err)
 			lastError = err
 			continue
 		}
 
-		log.Printf("成功获取仓库内容，共 %d 个文件", len(contents))
-		return &models.ProcessResult{
-			FileTree:     tree,
-			FileContents: contents,
+		root := models.NewRootNode(c.config.GetMaxTreeNodes())
+		for _, item := range tree.Tree {
+			if !info.Contains(item.Path) {
+				continue
+			}
+			node := root.AddPath(item.Path)
+			node.IsDir = node.IsDir || item.Type == "tree"
+			node.Type = item.Type
+			node.Size = item.Size
+			if item.isSymlink() {
+				node.Type = "symlink"
+			}
+			if item.Type == "commit" {
+				node.Commit = item.SHA
+			}
+		}
+
+		return &RepoTree{
+			Branch:    branch,
+			FileTree:  root,
+			Truncated: tree.Truncated,
 		}, nil
 	}
 
-	return nil, fmt.Errorf("无法获取仓库内容: %v", lastError)
+	return nil, fmt.Errorf("无法获取仓库目录树: %v", lastError)
 }
 
-// getTreeContents 获取文件树内容
-func (c *Client) getTreeContents(owner, repo, branch, token string, useBase64 bool) (*models.TreeNode, map[string]models.FileContent, error) {
-	root := models.NewTreeNode("", false)
-	fileContents := make(map[string]models.FileContent)
+// getRepo 依次尝试分支获取仓库内容，docsOnly 为 true 时只下载文档文件
+func (c *Client) getRepo(info RepoInfo, token string, opts models.ProcessOptions, docsOnly bool) (*models.ProcessResult, error) {
+	log.Printf("开始获取 GitHub 仓库内容: %s/%s (仅文档: %v)", info.Owner, info.Repo, docsOnly)
 
-	// 首先尝试获取递归树结构
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees/%s?recursive=1", owner, repo, branch)
-	log.Printf("获取仓库结构: %s", apiURL)
+	// 请求预算覆盖本次获取的所有分支尝试和子模块
+	budget := newRequestBudget(c.config.GetGithubMaxAPIRequests())
 
-	resp, err := c.makeRequest(apiURL, token)
-	if err != nil {
-		return nil, nil, fmt.Errorf("请求仓库树失败: %w", err)
-	}
-	defer resp.Body.Close()
+	var lastError error
+	for _, branch := range c.candidateBranches(info, token) {
+		log.Printf("尝试分支: %s", branch)
+		result, err := c.getTreeContents(info, branch, token, opts, docsOnly, 0, budget)
+		if err != nil {
+			// 严格模式下的文件失败、空仓库和 SSO 授权与分支无关，无需再尝试其他分支
+			if errors.Is(err, models.ErrFileProcessing) || errors.Is(err, models.ErrEmptyRepository) ||
+				errors.Is(err, models.ErrSSORequired) {
+				return nil, err
+			}
+			log.Printf("分支 %s 获取失败: %v", branch, err)
+			lastError = err
+			continue
+		}
 
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("API 返回错误: 状态码 %d, 响应: %s", resp.StatusCode, string(body))
-		return nil, nil, fmt.Errorf("GitHub API 请求失败: %s - %s", resp.Status, string(body))
+		log.Printf("成功获取仓库内容，共 %d 个文件", len(result.FileContents))
+		result.DocsOnly = docsOnly
+		// 混合换行符只作提示，在严格模式检查之后加入；子模块的文件也在此统一检查
+		result.Warnings = append(result.Warnings, services.LineEndingWarnings(result.FileContents)...)
+		return result, nil
 	}
 
-	// 解析树响应
-	var treeResp struct {
-		Tree []struct {
-			Path string `json:"path"`
-			Type string `json:"type"`
-			URL  string `json:"url"`
-			Size int64  `json:"size"`
-		} `json:"tree"`
-		Truncated bool `json:"truncated"`
-	}
+	return nil, fmt.Errorf("无法获取仓库内容: %v", lastError)
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&treeResp); err != nil {
-		return nil, nil, fmt.Errorf("解析树响应失败: %w", err)
-	}
+// getTreeContents 获取文件树内容，depth 为当前子模块嵌套深度（主仓库为 0）。
+// 请求数达到 budget 上限后不再发出请求，未获取的文件只保留在文件树中，结果标记为不完整
+func (c *Client) getTreeContents(info RepoInfo, branch, token string, opts models.ProcessOptions, docsOnly bool, depth int, budget *requestBudget) (*models.ProcessResult, error) {
+	root := models.NewRootNode(c.config.GetMaxTreeNodes())
+	fileContents := make(map[string]models.FileContent)
 
-	// 如果树被截断，提供警告
-	if treeResp.Truncated {
-		log.Print("警告: 仓库树被截断，可能不包含所有文件")
+	if !budget.take() {
+		return nil, errBudgetExhausted
 	}
-
-	// 优先收集文档和重要文件
-	importantFiles := map[string]bool{
-		"README.md":        true,
-		"README":           true,
-		"LICENSE":          true,
-		"CONTRIBUTING.md":  true,
-		"go.mod":           true,
-		"package.json":     true,
-		"requirements.txt": true,
-		"Cargo.toml":       true,
-		"Dockerfile":       true,
+	treeResp, err := c.fetchTree(info, branch, token)
+	if err != nil {
+		return nil, err
 	}
 
 	// 优先处理的文件类型
-	priorityExtensions := map[string]bool{
-		".md":       true,
-		".markdown": true,
-		".txt":      true,
-		".go":       true,
-		".py":       true,
-		".js":       true,
-		".ts":       true,
-		".java":     true,
-		".c":        true,
-		".cpp":      true,
-		".h":        true,
-	}
+	priorityExtensions := c.config.GetGithubPriorityExtensions()
 
 	// 分类文件用于处理
 	var priorityPaths []string
 	var regularPaths []string
+	var sniffPaths []string // 未识别扩展名、需要按内容检测的文件
+	var submodules []treeEntry
+	maxFileSize := opts.FileSizeLimit(c.config.GetMaxFileSize())
 
 	log.Printf("找到 %d 个文件/目录节点", len(treeResp.Tree))
+	matcher := c.loadIgnoreFiles(info, branch, token, treeResp.Tree, opts, budget)
 
 	// 添加所有项目到文件树，并分类文件
 	for _, item := range treeResp.Tree {
+		// 指定了子路径时只保留该路径下的内容
+		if !info.Contains(item.Path) {
+			continue
+		}
+		// 本次请求排除的目录不下载内容，也不出现在文件树中
+		if opts.IsExcludedDir(item.Path) {
+			continue
+		}
+		// 与 ZIP 相同，被忽略文件匹配的路径不下载内容，也不出现在文件树中
+		if matcher.Match(item.Path, item.Type == "tree") {
+			log.Printf("排除 (忽略文件): %s", item.Path)
+			continue
+		}
+
+		// 符号链接不下载内容，按需以 "name -> target" 保留在文件树中
+		if item.isSymlink() {
+			if opts.IncludeSymlinks {
+				node := root.AddPath(item.Path)
+				node.Type = "symlink"
+				if budget.take() {
+					node.LinkTarget = c.getSymlinkTarget(info, branch, item.Path, token)
+				}
+			} else {
+				log.Printf("排除 (符号链接): %s", item.Path)
+			}
+			continue
+		}
+
 		// 如果是文件，检查是否要获取内容
 		if item.Type == "blob" {
 			ext := strings.ToLower(filepath.Ext(item.Path))
-			filename := filepath.Base(item.Path)
-
-			// 优先级排序
-			if importantFiles[filename] || priorityExtensions[ext] {
+			important := c.config.IsImportantFile(item.Path)
+
+			// 优先级排序；仅文档模式下只收集架构分析需要的文档
+			if (opts.SkipTests && c.config.IsTestFile(item.Path)) || (opts.SkipGenerated && c.config.IsGeneratedFile(item.Path)) {
+				// 跳过测试文件和生成代码，但仍保留在文件树中
+				log.Printf("排除 (测试文件/生成代码): %s", item.Path)
+			} else if item.Size > maxFileSize {
+				// 超过大小限制的文件不发出请求
+				log.Printf("排除 (超过大小限制): %s (%d 字节)", item.Path, item.Size)
+			} else if docsOnly {
+				if services.IsDocumentCandidate(item.Path, item.Size, important) {
+					priorityPaths = append(priorityPaths, item.Path)
+				}
+			} else if important || priorityExtensions[ext] {
 				priorityPaths = append(priorityPaths, item.Path)
-			} else if !c.config.IsExcluded(item.Path, uint64(item.Size)) && c.config.IsLikelyTextFile(item.Path) {
-				regularPaths = append(regularPaths, item.Path)
+			} else if !c.isExcluded(item.Path, item.Size, opts) {
+				if c.isLikelyTextFile(item.Path, opts) {
+					regularPaths = append(regularPaths, item.Path)
+				} else if c.config.IsContentSniffingEnabled() {
+					sniffPaths = append(sniffPaths, item.Path)
+				}
 			}
 		}
 
-		// 无论是否处理内容，都添加到文件树中
-		root.AddPath(item.Path)
+		// 无论是否处理内容，都添加到文件树中（文件带上大小）；子模块标记其指向的提交
+		node := root.AddPath(item.Path)
+		if item.Type == "blob" {
+			node.Size = item.Size
+		}
+		if item.Type == "commit" {
+			node.Type = item.Type
+			node.Commit = item.SHA
+			submodules = append(submodules, item)
+		}
 	}
 
+	// 未识别扩展名的文件排在常规文件之后，获取后按内容判断是否为文本
+	regularPaths = append(regularPaths, sniffPaths...)
+
 	// 限制常规文件数量以防止请求过多
 	const maxRegularFiles = 50
 	if len(regularPaths) > maxRegularFiles {
@@ -163,93 +269,269 @@ func (c *Client) getTreeContents(owner, repo, branch, token string, useBase64 bo
 		regularPaths = regularPaths[:maxRegularFiles]
 	}
 
+	progress := &fetchProgress{report: opts.Progress, total: len(priorityPaths) + len(regularPaths)}
+
 	// 处理优先文件
 	log.Printf("处理 %d 个优先文件", len(priorityPaths))
-	for _, path := range priorityPaths {
-		content, err := c.getFileContent(owner, repo, path, token, useBase64)
+	warnings := c.fetchFiles(info, branch, token, priorityPaths, opts, fileContents, progress, budget)
+
+	// 处理常规文件
+	log.Printf("处理 %d 个常规文件", len(regularPaths))
+	warnings = append(warnings, c.fetchFiles(info, branch, token, regularPaths, opts, fileContents, progress, budget)...)
+
+	// 按需查询文件的最近提交，优先文件在前，只覆盖本仓库的文件
+	if opts.IncludeLastModified && !docsOnly {
+		c.fetchLastCommits(info, branch, token, append(priorityPaths, regularPaths...), fileContents, budget)
+	}
+
+	// 按需获取子模块内容
+	if opts.FollowSubmodules && !docsOnly && len(submodules) > 0 {
+		if depth < c.config.GetGithubSubmoduleMaxDepth() {
+			warnings = append(warnings, c.followSubmodules(info, branch, token, opts, depth, submodules, root, fileContents, budget)...)
+		} else {
+			log.Printf("子模块嵌套深度已达上限 (%d)，不再获取 %d 个子模块", depth, len(submodules))
+		}
+	}
+
+	log.Printf("完成获取仓库内容，成功获取 %d 个文件，%d 个失败", len(fileContents), len(warnings))
+	if opts.FailOnError && len(warnings) > 0 {
+		return nil, models.NewFileProcessingError(warnings)
+	}
+
+	result := &models.ProcessResult{
+		FileTree:     root,
+		FileContents: fileContents,
+		Warnings:     warnings,
+		Partial:      budget.isExhausted(),
+	}
+	result.UpdateContentHash()
+	return result, nil
+}
+
+// loadIgnoreFiles 下载仓库中所有名为 opts.IgnoreFiles 的忽略文件（只包括位于子路径内或其上级目录中的文件），
+// 每个文件的规则相对其所在目录生效
+func (c *Client) loadIgnoreFiles(info RepoInfo, branch, token string, tree []treeEntry, opts models.ProcessOptions, budget *requestBudget) *ignore.Matcher {
+	matcher := ignore.New()
+	if len(opts.IgnoreFiles) == 0 {
+		return matcher
+	}
+
+	// 忽略文件通常没有文本扩展名（如 .dockerignore），按文本获取
+	var fetchOpts models.ProcessOptions
+	for _, name := range opts.IgnoreFiles {
+		if ext := strings.ToLower(filepath.Ext(name)); ext != "" {
+			fetchOpts.ExtraTextExtensions = append(fetchOpts.ExtraTextExtensions, ext)
+		}
+	}
+
+	for _, item := range tree {
+		if item.Type != "blob" || !slices.Contains(opts.IgnoreFiles, path.Base(item.Path)) {
+			continue
+		}
+		dir := path.Dir(item.Path)
+		if !info.Contains(item.Path) && dir != "." && !strings.HasPrefix(info.Subpath, dir+"/") {
+			continue
+		}
+		if !budget.take() {
+			break
+		}
+		content, err := c.getFileContent(info, branch, item.Path, token, fetchOpts)
 		if err != nil {
-			log.Printf("获取文件内容失败 %s: %v", path, err)
+			log.Printf("警告: 读取忽略文件 %s 失败: %v", item.Path, err)
 			continue
 		}
+		matcher.Add(dir, content)
+		log.Printf("已加载忽略文件: %s", item.Path)
+	}
+	return matcher
+}
 
-		if content != "" {
-			fileContents[path] = models.FileContent{
-				Path:     path,
-				Content:  content,
-				IsBase64: useBase64,
-			}
-		}
+// fetchTree 调用 git/trees 接口递归获取仓库结构
+func (c *Client) fetchTree(info RepoInfo, branch, token string) (*gitTree, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?recursive=1", info.APIBase(), info.Owner, info.Repo, url.PathEscape(branch))
+	log.Printf("获取仓库结构: %s", apiURL)
+
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("请求仓库树失败: %w", err)
 	}
+	defer resp.Body.Close()
 
-	// 处理常规文件
-	log.Printf("处理 %d 个常规文件", len(regularPaths))
-	for _, path := range regularPaths {
-		content, err := c.getFileContent(owner, repo, path, token, useBase64)
+	// 空仓库的 git 接口返回 409 Conflict（"Git Repository is empty."）
+	if resp.StatusCode == http.StatusConflict {
+		return nil, models.ErrEmptyRepository
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		log.Printf("API 返回错误: 状态码 %d, 响应: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("GitHub API 请求失败: %s - %s", resp.Status, string(body))
+	}
+
+	var tree gitTree
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, fmt.Errorf("解析树响应失败: %w", err)
+	}
+
+	// 如果树被截断，提供警告
+	if tree.Truncated {
+		log.Print("警告: 仓库树被截断，可能不包含所有文件")
+	}
+	return &tree, nil
+}
+
+// fetchFiles 获取文件内容并写入 fileContents，返回获取失败的文件列表。
+// 有访问令牌时先通过 GraphQL 批量获取，其余文件逐个调用 REST 接口；budget 用完后跳过剩余文件
+func (c *Client) fetchFiles(info RepoInfo, branch, token string, paths []string, opts models.ProcessOptions, fileContents map[string]models.FileContent, progress *fetchProgress, budget *requestBudget) []models.FileWarning {
+	var warnings []models.FileWarning
+	contents := make(map[string][]byte, len(paths))
+
+	restPaths := paths
+	if token != "" && c.config.IsGithubGraphQLEnabled() {
+		restPaths, warnings = c.fetchFilesGraphQL(info, branch, token, paths, opts, contents, progress, budget)
+	}
+
+	for _, path := range restPaths {
+		if !budget.take() {
+			break
+		}
+		content, err := c.getFileContent(info, branch, path, token, opts)
+		progress.done(path)
 		if err != nil {
 			log.Printf("获取文件内容失败 %s: %v", path, err)
+			warnings = append(warnings, models.FileWarning{Path: path, Reason: err.Error()})
 			continue
 		}
+		contents[path] = content
+	}
 
-		if content != "" {
-			fileContents[path] = models.FileContent{
-				Path:     path,
-				Content:  content,
-				IsBase64: useBase64,
-			}
-		}
+	for _, path := range paths {
+		c.addFileContent(path, contents[path], opts, fileContents)
+	}
+	return warnings
+}
+
+// fetchProgress 统计本仓库已处理的文件数，并通过 ProcessOptions.Progress 报告
+type fetchProgress struct {
+	report  models.ProgressFunc
+	fetched int
+	total   int
+}
+
+// done 记录一个文件已处理完（无论成功与否）
+func (p *fetchProgress) done(path string) {
+	if p == nil || p.report == nil {
+		return
+	}
+	p.fetched++
+	p.report(models.FetchProgress{Fetched: p.fetched, Total: p.total, Current: path})
+}
+
+// addFileContent 检查已下载的文件内容，文本文件写入 fileContents，空内容、非文本和带生成标记的文件跳过。
+// 与 ZIP 相同，按检测到的 MIME 类型判断是否为文本，扩展名只作为提示
+func (c *Client) addFileContent(path string, content []byte, opts models.ProcessOptions, fileContents map[string]models.FileContent) {
+	if len(content) == 0 {
+		return
+	}
+
+	if contentType, isText := c.config.DetectTextContent(path, content, opts.IsExtraTextFile(path)); !isText {
+		log.Printf("排除 (检测到二进制内容 %s): %s", contentType, path)
+		return
+	}
+
+	if opts.SkipGenerated && services.IsGeneratedContent(content) {
+		log.Printf("排除 (生成代码标记): %s", path)
+		return
 	}
 
-	log.Printf("完成获取仓库内容，成功获取 %d 个文件", len(fileContents))
-	return root, fileContents, nil
+	fileContents[path] = services.ProcessContent(path, content, opts)
 }
 
-// getFileContent 获取文件内容
-func (c *Client) getFileContent(owner, repo, path, token string, useBase64 bool) (string, error) {
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", owner, repo, path)
+// isExcluded 按配置的规则检查文件是否排除，请求额外指定的文本扩展名不受扩展名排除规则限制
+func (c *Client) isExcluded(path string, size int64, opts models.ProcessOptions) bool {
+	if opts.IsExtraTextFile(path) {
+		return size > c.config.GetMaxFileSize() || c.config.IsExcludedDir(path)
+	}
+	return c.config.IsExcluded(path, uint64(size))
+}
+
+// isLikelyTextFile 根据扩展名判断文件是否为文本，包括请求额外指定的文本扩展名
+func (c *Client) isLikelyTextFile(path string, opts models.ProcessOptions) bool {
+	return opts.IsExtraTextFile(path) || c.config.IsLikelyTextFile(path)
+}
+
+// getFileContent 获取解码后的文件内容，非文本或超过 opts 大小上限的文件返回空内容
+func (c *Client) getFileContent(info RepoInfo, branch, path, token string, opts models.ProcessOptions) ([]byte, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s", info.APIBase(), info.Owner, info.Repo, path, url.QueryEscape(branch))
 
 	resp, err := c.makeRequest(apiURL, token)
 	if err != nil {
-		return "", fmt.Errorf("请求文件失败: %w", err)
+		return nil, fmt.Errorf("请求文件失败: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("获取文件内容失败: %s - %s", resp.Status, string(body))
+		return nil, fmt.Errorf("获取文件内容失败: %s - %s", resp.Status, string(body))
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %w", err)
+		return nil, fmt.Errorf("读取响应失败: %w", err)
 	}
 
 	var content Content
 	if err := json.Unmarshal(body, &content); err != nil {
-		return "", fmt.Errorf("解析响应失败: %w", err)
+		return nil, fmt.Errorf("解析响应失败: %w", err)
 	}
 
-	if !c.config.IsLikelyTextFile(path) {
-		return "", nil
+	if !c.isLikelyTextFile(path, opts) && !c.config.IsContentSniffingEnabled() {
+		return nil, nil
 	}
 
-	// 检查文件大小
-	const maxContentSize = 100000 // 约100KB
-	if len(content.Content) > maxContentSize {
-		log.Printf("文件过大，跳过: %s", path)
-		return "", nil
+	// 按 GitHub 返回的实际文件大小检查，而不是 Base64 编码后的长度
+	maxFileSize := opts.FileSizeLimit(c.config.GetMaxFileSize())
+	if content.Size > maxFileSize {
+		log.Printf("文件过大，跳过: %s (%d 字节)", path, content.Size)
+		return nil, nil
 	}
 
 	// 尝试解码Base64内容
 	decoded, err := base64.StdEncoding.DecodeString(content.Content)
 	if err != nil {
-		return "", fmt.Errorf("解码内容失败: %w", err)
+		return nil, fmt.Errorf("解码内容失败: %w", err)
+	}
+
+	// 响应未携带 size 时以解码后的长度为准
+	if int64(len(decoded)) > maxFileSize {
+		log.Printf("文件过大，跳过: %s (%d 字节)", path, len(decoded))
+		return nil, nil
+	}
+
+	return decoded, nil
+}
+
+// getSymlinkTarget 通过 contents 接口获取符号链接的目标路径，失败时返回空字符串
+func (c *Client) getSymlinkTarget(info RepoInfo, branch, path, token string) string {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s", info.APIBase(), info.Owner, info.Repo, path, url.QueryEscape(branch))
+
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		log.Printf("获取符号链接目标失败 %s: %v", path, err)
+		return ""
 	}
+	defer resp.Body.Close()
 
-	if useBase64 {
-		return base64.StdEncoding.EncodeToString(decoded), nil
+	if resp.StatusCode != 200 {
+		log.Printf("获取符号链接目标失败 %s: %s", path, resp.Status)
+		return ""
 	}
 
-	return string(decoded), nil
+	var content Content
+	if err := json.NewDecoder(resp.Body).Decode(&content); err != nil {
+		log.Printf("解析符号链接响应失败 %s: %v", path, err)
+		return ""
+	}
+	return content.Target
 }
 
 // makeRequest 发送 HTTP 请求
@@ -265,26 +547,173 @@ func (c *Client) makeRequest(url, token string) (*http.Response, error) {
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("User-Agent", "Repo-Prompt-Web/1.0")
 
-	client := &http.Client{
-		Timeout: 20 * time.Second,
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := ssoError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
 	}
-	return client.Do(req)
+	return resp, nil
 }
 
-// ParseRepoURL 解析 GitHub 仓库 URL
-func ParseRepoURL(url string) (owner, repo string, err error) {
-	patterns := []string{
-		`github\.com[:/]([^/]+)/([^/]+?)(?:\.git)?/?$`,
-		`github\.com/([^/]+)/([^/]+)`,
+// ssoError 检查响应是否因令牌未进行 SSO 授权而被拒绝：GitHub 此时返回 403，
+// 并在 X-GitHub-SSO 头中给出 "required; url=<授权地址>"
+func ssoError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden {
+		return nil
 	}
+	sso := resp.Header.Get("X-GitHub-SSO")
+	if !strings.HasPrefix(sso, "required") {
+		return nil
+	}
+	if i := strings.Index(sso, "url="); i >= 0 {
+		return fmt.Errorf("%w，请访问 %s 为令牌授权后重试", models.ErrSSORequired, strings.TrimSpace(sso[i+len("url="):]))
+	}
+	return fmt.Errorf("%w，请在 GitHub 令牌设置中为该组织启用 SSO 授权后重试", models.ErrSSORequired)
+}
 
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(url)
-		if len(matches) == 3 {
-			return matches[1], matches[2], nil
+// RepoInfo 表示解析后的 GitHub 仓库地址
+type RepoInfo struct {
+	Host    string // 主机名，如 github.com 或企业版主机
+	Owner   string // 仓库所有者
+	Repo    string // 仓库名
+	Ref     string // 分支/标签/提交，未指定时为空
+	Subpath string // 仓库内子路径，未指定时为空
+}
+
+// APIBase 返回仓库所在主机的 API 根地址
+func (r RepoInfo) APIBase() string {
+	if r.Host == "" || r.Host == "github.com" {
+		return "https://api.github.com"
+	}
+	// GitHub Enterprise Server 的 REST API 位于 /api/v3
+	return "https://" + r.Host + "/api/v3"
+}
+
+// candidateBranches 返回依次尝试的分支：URL 中指定了分支/标签时只尝试该引用，
+// 否则使用仓库元数据中的默认分支，元数据获取失败时回退到配置的 github.default_branches（默认 main、master）
+func (c *Client) candidateBranches(info RepoInfo, token string) []string {
+	if info.Ref != "" {
+		return []string{info.Ref}
+	}
+
+	branch, err := c.fetchDefaultBranch(info, token)
+	if err != nil {
+		fallback := c.config.GetGithubDefaultBranches()
+		log.Printf("获取默认分支失败，回退到 %s: %v", strings.Join(fallback, "、"), err)
+		return fallback
+	}
+	return []string{branch}
+}
+
+// repoMetadata GitHub /repos/{owner}/{repo} 接口响应中用到的字段
+type repoMetadata struct {
+	DefaultBranch string `json:"default_branch"`
+}
+
+// fetchDefaultBranch 查询仓库元数据获取默认分支
+func (c *Client) fetchDefaultBranch(info RepoInfo, token string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", info.APIBase(), info.Owner, info.Repo)
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return "", fmt.Errorf("请求仓库信息失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("GitHub API 请求失败: %s - %s", resp.Status, string(body))
+	}
+
+	var meta repoMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return "", fmt.Errorf("解析仓库信息失败: %w", err)
+	}
+	if meta.DefaultBranch == "" {
+		return "", fmt.Errorf("仓库信息中没有默认分支")
+	}
+	log.Printf("仓库默认分支: %s", meta.DefaultBranch)
+	return meta.DefaultBranch, nil
+}
+
+// Contains 检查仓库内路径是否位于子路径范围内
+func (r RepoInfo) Contains(path string) bool {
+	if r.Subpath == "" {
+		return true
+	}
+	return path == r.Subpath || strings.HasPrefix(path, r.Subpath+"/")
+}
+
+// ParseRepoURL 解析 GitHub 仓库 URL，支持 /tree/<ref>/<path>、/blob/...、/pull/... 后缀，
+// 以及通过 enterpriseHosts 指定的 GitHub Enterprise 主机。
+// 注意：包含斜杠的分支名无法与子路径区分，仅取第一段作为引用
+func ParseRepoURL(rawURL string, enterpriseHosts ...string) (RepoInfo, error) {
+	invalid := fmt.Errorf("无效的 GitHub 仓库 URL")
+
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return RepoInfo{}, invalid
+	}
+
+	// 转换 SSH 形式：git@github.com:owner/repo.git
+	if strings.HasPrefix(rawURL, "git@") {
+		rawURL = "ssh://" + strings.Replace(strings.TrimPrefix(rawURL, "git@"), ":", "/", 1)
+	} else if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RepoInfo{}, invalid
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	if !isGitHubHost(host, enterpriseHosts) {
+		return RepoInfo{}, fmt.Errorf("不支持的 GitHub 主机: %s", host)
+	}
+
+	var segments []string
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
 		}
 	}
+	if len(segments) < 2 {
+		return RepoInfo{}, invalid
+	}
+
+	info := RepoInfo{
+		Host:  host,
+		Owner: segments[0],
+		Repo:  strings.TrimSuffix(segments[1], ".git"),
+	}
+	if info.Repo == "" {
+		return RepoInfo{}, invalid
+	}
+
+	if len(segments) > 3 {
+		switch segments[2] {
+		case "tree", "blob":
+			info.Ref = segments[3]
+			info.Subpath = strings.Join(segments[4:], "/")
+		}
+	}
+
+	return info, nil
+}
 
-	return "", "", fmt.Errorf("无效的 GitHub 仓库 URL")
+// isGitHubHost 检查主机名是否为 github.com 或配置的企业版主机
+func isGitHubHost(host string, enterpriseHosts []string) bool {
+	if host == "github.com" {
+		return true
+	}
+	for _, h := range enterpriseHosts {
+		if strings.EqualFold(host, h) {
+			return true
+		}
+	}
+	return false
 }
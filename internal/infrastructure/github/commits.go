@@ -0,0 +1,86 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+
+	"repo-prompt-web/internal/domain/models"
+)
+
+// commitInfo commits 接口返回的提交信息（仅解析需要的字段）
+type commitInfo struct {
+	Commit struct {
+		Author struct {
+			Name string `json:"name"`
+			Date string `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+	// Author 为提交者对应的 GitHub 账号，邮箱未关联账号时为 null
+	Author *struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+// fetchLastCommits 按 paths 顺序为已获取内容的文件查询最近一次提交，写入 LastModified 和 LastAuthor。
+// 每个文件一次请求，最多查询 github.last_modified_max_files 个文件，并受 budget 限制；查询失败时仅记录日志
+func (c *Client) fetchLastCommits(info RepoInfo, branch, token string, paths []string, fileContents map[string]models.FileContent, budget *requestBudget) {
+	maxFiles := c.config.GetGithubLastModifiedMaxFiles()
+	queried := 0
+	for _, path := range paths {
+		if queried >= maxFiles {
+			log.Printf("查询最近提交的文件数已达上限 (%d)，跳过其余文件", maxFiles)
+			return
+		}
+		file, ok := fileContents[path]
+		if !ok {
+			continue
+		}
+		if !budget.take() {
+			return
+		}
+		queried++
+
+		commit, err := c.getLastCommit(info, branch, path, token)
+		if err != nil {
+			log.Printf("获取最近提交失败 %s: %v", path, err)
+			continue
+		}
+		if commit == nil {
+			continue
+		}
+
+		file.LastModified = commit.Commit.Author.Date
+		file.LastAuthor = commit.Commit.Author.Name
+		if commit.Author != nil && commit.Author.Login != "" {
+			file.LastAuthor = commit.Author.Login
+		}
+		fileContents[path] = file
+	}
+}
+
+// getLastCommit 调用 commits 接口获取修改过 path 的最近一次提交，没有提交记录时返回 nil
+func (c *Client) getLastCommit(info RepoInfo, branch, path, token string) (*commitInfo, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/commits?sha=%s&path=%s&per_page=1", info.APIBase(), info.Owner, info.Repo,
+		url.QueryEscape(branch), url.QueryEscape(path))
+
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("请求提交记录失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("获取提交记录失败: %s", resp.Status)
+	}
+
+	var commits []commitInfo
+	if err := json.NewDecoder(resp.Body).Decode(&commits); err != nil {
+		return nil, fmt.Errorf("解析提交记录失败: %w", err)
+	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	return &commits[0], nil
+}
@@ -0,0 +1,112 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/internal/domain/services"
+)
+
+// compareFile compare 接口返回的单个变更文件
+type compareFile struct {
+	Filename         string `json:"filename"`
+	Status           string `json:"status"`
+	PreviousFilename string `json:"previous_filename"`
+	Additions        int    `json:"additions"`
+	Deletions        int    `json:"deletions"`
+}
+
+// compareResponse compare 接口的响应（仅解析需要的字段）
+type compareResponse struct {
+	Files []compareFile `json:"files"`
+}
+
+// maxCompareFiles compare 接口最多返回的变更文件数
+const maxCompareFiles = 300
+
+// GetChangedFiles 调用 compare 接口获取 base 与 head 之间变更的文件，只获取这些文件在 head 上的内容。
+// 删除的文件不在文件树中，但与其他变更一起记录在结果的 Changes 中
+func (c *Client) GetChangedFiles(info RepoInfo, base, head, token string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	log.Printf("获取变更文件: %s/%s %s...%s", info.Owner, info.Repo, base, head)
+
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s", info.APIBase(), info.Owner, info.Repo,
+		url.PathEscape(base), url.PathEscape(head))
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("请求变更文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, models.ErrRepoNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, models.ErrRepoForbidden
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub API 请求失败: %s - %s", resp.Status, string(body))
+	}
+
+	var compare compareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&compare); err != nil {
+		return nil, fmt.Errorf("解析变更文件失败: %w", err)
+	}
+	if len(compare.Files) >= maxCompareFiles {
+		log.Printf("警告: 变更文件数达到 compare 接口上限 (%d)，可能不包含所有变更", maxCompareFiles)
+	}
+
+	root := models.NewRootNode(c.config.GetMaxTreeNodes())
+	fileContents := make(map[string]models.FileContent)
+	changes := make([]models.FileChange, 0, len(compare.Files))
+	var paths []string
+	for _, file := range compare.Files {
+		changes = append(changes, models.FileChange{
+			Path:         file.Filename,
+			Status:       file.Status,
+			PreviousPath: file.PreviousFilename,
+			Additions:    file.Additions,
+			Deletions:    file.Deletions,
+		})
+		if file.Status == "removed" || !info.Contains(file.Filename) || opts.IsExcludedDir(file.Filename) {
+			continue
+		}
+
+		root.AddPath(file.Filename)
+		switch {
+		case (opts.SkipTests && c.config.IsTestFile(file.Filename)) || (opts.SkipGenerated && c.config.IsGeneratedFile(file.Filename)):
+			log.Printf("排除 (测试文件/生成代码): %s", file.Filename)
+		case c.isExcluded(file.Filename, 0, opts):
+			log.Printf("排除 (规则): %s", file.Filename)
+		case !c.isLikelyTextFile(file.Filename, opts) && !c.config.IsContentSniffingEnabled():
+			log.Printf("排除 (非文本扩展名): %s", file.Filename)
+		default:
+			paths = append(paths, file.Filename)
+		}
+	}
+
+	progress := &fetchProgress{report: opts.Progress, total: len(paths)}
+	warnings := c.fetchFiles(info, head, token, paths, opts, fileContents, progress, nil)
+	for path, content := range fileContents {
+		root.AddPath(path).Size = content.Size
+	}
+
+	log.Printf("完成获取变更文件，共 %d 个变更，获取 %d 个文件内容，%d 个失败", len(changes), len(fileContents), len(warnings))
+	if opts.FailOnError && len(warnings) > 0 {
+		return nil, models.NewFileProcessingError(warnings)
+	}
+
+	result := &models.ProcessResult{
+		FileTree:     root,
+		FileContents: fileContents,
+		Warnings:     append(warnings, services.LineEndingWarnings(fileContents)...),
+		Changes:      changes,
+	}
+	result.UpdateContentHash()
+	return result, nil
+}
@@ -0,0 +1,190 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/internal/domain/services"
+)
+
+// gistHost Gist 的网页主机名
+const gistHost = "gist.github.com"
+
+// GistInfo 表示解析后的 Gist 地址
+type GistInfo struct {
+	Owner string // Gist 所有者，URL 中未包含时为空
+	ID    string // Gist ID
+}
+
+// gistFile Gists 接口返回的单个文件
+type gistFile struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	RawURL    string `json:"raw_url"`
+	Truncated bool   `json:"truncated"` // 内容超过 1 MB 时被截断，需要通过 raw_url 获取
+	Content   string `json:"content"`
+}
+
+// gistResponse Gists 接口的响应
+type gistResponse struct {
+	Files map[string]gistFile `json:"files"`
+}
+
+// IsGistURL 检查 URL 是否指向 gist.github.com
+func IsGistURL(rawURL string) bool {
+	return parseGistHost(rawURL) != nil
+}
+
+// parseGistHost 解析 URL（可省略协议），主机不是 gist.github.com 时返回 nil
+func parseGistHost(rawURL string) *url.URL {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.EqualFold(u.Hostname(), gistHost) {
+		return nil
+	}
+	return u
+}
+
+// ParseGistURL 解析 gist.github.com/<user>/<id> 或 gist.github.com/<id> 形式的 Gist URL，
+// 忽略末尾的 .git 后缀和 /revisions 等子页面
+func ParseGistURL(rawURL string) (GistInfo, error) {
+	invalid := fmt.Errorf("无效的 Gist URL")
+	u := parseGistHost(rawURL)
+	if u == nil {
+		return GistInfo{}, invalid
+	}
+
+	var segments []string
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+
+	var info GistInfo
+	switch len(segments) {
+	case 0:
+		return GistInfo{}, invalid
+	case 1:
+		info.ID = segments[0]
+	default:
+		info.Owner = segments[0]
+		info.ID = segments[1]
+	}
+	info.ID = strings.TrimSuffix(info.ID, ".git")
+	if info.ID == "" {
+		return GistInfo{}, invalid
+	}
+	return info, nil
+}
+
+// GetGistContents 通过 Gists 接口获取 Gist 的所有文件，每个文件作为文件树的顶层节点，
+// 内容按仓库文件相同的规则过滤
+func (c *Client) GetGistContents(info GistInfo, token string, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	log.Printf("开始获取 Gist 内容: %s", info.ID)
+
+	apiURL := fmt.Sprintf("https://api.github.com/gists/%s", url.PathEscape(info.ID))
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("请求 Gist 失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, models.ErrGistNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub API 请求失败: %s - %s", resp.Status, string(body))
+	}
+
+	var gist gistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gist); err != nil {
+		return nil, fmt.Errorf("解析 Gist 响应失败: %w", err)
+	}
+
+	names := make([]string, 0, len(gist.Files))
+	for name := range gist.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	root := models.NewRootNode(c.config.GetMaxTreeNodes())
+	fileContents := make(map[string]models.FileContent)
+	var warnings []models.FileWarning
+	maxFileSize := opts.FileSizeLimit(c.config.GetMaxFileSize())
+	for _, name := range names {
+		file := gist.Files[name]
+		root.AddPath(name).Size = file.Size
+
+		if (opts.SkipTests && c.config.IsTestFile(name)) || (opts.SkipGenerated && c.config.IsGeneratedFile(name)) {
+			log.Printf("排除 (测试文件/生成代码): %s", name)
+			continue
+		}
+		if c.isExcluded(name, file.Size, opts) || file.Size > maxFileSize {
+			log.Printf("排除 (规则): %s", name)
+			continue
+		}
+		if !c.isLikelyTextFile(name, opts) && !c.config.IsContentSniffingEnabled() {
+			log.Printf("排除 (非文本扩展名): %s", name)
+			continue
+		}
+
+		content := []byte(file.Content)
+		if file.Truncated {
+			content, err = c.getGistRawFile(file.RawURL, token, maxFileSize)
+			if err != nil {
+				log.Printf("获取文件内容失败 %s: %v", name, err)
+				warnings = append(warnings, models.FileWarning{Path: name, Reason: err.Error()})
+				continue
+			}
+		}
+		c.addFileContent(name, content, opts, fileContents)
+	}
+
+	log.Printf("完成获取 Gist 内容，成功获取 %d 个文件，%d 个失败", len(fileContents), len(warnings))
+	if opts.FailOnError && len(warnings) > 0 {
+		return nil, models.NewFileProcessingError(warnings)
+	}
+
+	result := &models.ProcessResult{
+		FileTree:     root,
+		FileContents: fileContents,
+		Warnings:     append(warnings, services.LineEndingWarnings(fileContents)...),
+	}
+	result.UpdateContentHash()
+	return result, nil
+}
+
+// getGistRawFile 通过 raw_url 获取被 Gists 接口截断的完整文件内容，超过 maxFileSize 时返回错误
+func (c *Client) getGistRawFile(rawURL, token string, maxFileSize int64) ([]byte, error) {
+	resp, err := c.makeRequest(rawURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("请求文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取文件内容失败: %s", resp.Status)
+	}
+
+	// 文件大小已按 max_file_size 检查，多读一个字节防止响应与声明的大小不符
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if int64(len(content)) > maxFileSize {
+		return nil, fmt.Errorf("文件超过大小限制 (%d 字节)", maxFileSize)
+	}
+	return content, nil
+}
@@ -0,0 +1,153 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+)
+
+// graphQLBlob GraphQL Blob 对象中需要的字段
+type graphQLBlob struct {
+	ByteSize    int64   `json:"byteSize"`
+	IsBinary    bool    `json:"isBinary"`
+	IsTruncated bool    `json:"isTruncated"`
+	Text        *string `json:"text"`
+}
+
+// graphQLResponse GraphQL 批量获取文件的响应，repository 中每个别名对应一个文件
+type graphQLResponse struct {
+	Data struct {
+		Repository map[string]*graphQLBlob `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// GraphQLURL 返回仓库所在主机的 GraphQL 接口地址
+func (r RepoInfo) GraphQLURL() string {
+	if r.Host == "" || r.Host == "github.com" {
+		return "https://api.github.com/graphql"
+	}
+	// GitHub Enterprise Server 的 GraphQL 接口位于 /api/graphql
+	return "https://" + r.Host + "/api/graphql"
+}
+
+// fetchFilesGraphQL 通过 GraphQL 按批获取文件内容并写入 contents。
+// 返回需要回退到 REST 接口逐个获取的文件（查询失败或内容被截断）以及获取失败的文件列表
+func (c *Client) fetchFilesGraphQL(info RepoInfo, branch, token string, paths []string, opts models.ProcessOptions, contents map[string][]byte, progress *fetchProgress, budget *requestBudget) ([]string, []models.FileWarning) {
+	var fallback []string
+	var warnings []models.FileWarning
+	maxFileSize := opts.FileSizeLimit(c.config.GetMaxFileSize())
+
+	batchSize := c.config.GetGithubGraphQLBatchSize()
+	for start := 0; start < len(paths); start += batchSize {
+		end := start + batchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		batch := paths[start:end]
+
+		if !budget.take() {
+			break
+		}
+		blobs, err := c.queryBlobs(info, branch, token, batch)
+		if err != nil {
+			log.Printf("GraphQL 获取文件失败，回退到 REST 接口: %v", err)
+			fallback = append(fallback, batch...)
+			continue
+		}
+
+		for i, path := range batch {
+			blob := blobs[fmt.Sprintf("f%d", i)]
+			switch {
+			case blob == nil:
+				warnings = append(warnings, models.FileWarning{Path: path, Reason: "文件不存在或不是普通文件"})
+			case blob.IsBinary || (!c.isLikelyTextFile(path, opts) && !c.config.IsContentSniffingEnabled()):
+				contents[path] = nil
+			case blob.ByteSize > maxFileSize:
+				log.Printf("文件过大，跳过: %s (%d 字节)", path, blob.ByteSize)
+				contents[path] = nil
+			case blob.IsTruncated || blob.Text == nil:
+				// 回退到 REST 接口的文件在获取后再计入进度
+				fallback = append(fallback, path)
+				continue
+			default:
+				contents[path] = []byte(*blob.Text)
+			}
+			progress.done(path)
+		}
+	}
+
+	log.Printf("GraphQL 获取 %d 个文件，%d 个回退到 REST 接口", len(paths)-len(fallback), len(fallback))
+	return fallback, warnings
+}
+
+// queryBlobs 在一个 GraphQL 查询中获取多个文件，返回以别名 f<序号> 为键的结果
+func (c *Client) queryBlobs(info RepoInfo, branch, token string, paths []string) (map[string]*graphQLBlob, error) {
+	var query strings.Builder
+	variables := map[string]interface{}{
+		"owner": info.Owner,
+		"name":  info.Repo,
+	}
+
+	query.WriteString("query($owner: String!, $name: String!")
+	for i := range paths {
+		fmt.Fprintf(&query, ", $e%d: String!", i)
+	}
+	query.WriteString(") { repository(owner: $owner, name: $name) {")
+	for i, path := range paths {
+		fmt.Fprintf(&query, " f%d: object(expression: $e%d) { ... on Blob { byteSize isBinary isTruncated text } }", i, i)
+		variables[fmt.Sprintf("e%d", i)] = branch + ":" + path
+	}
+	query.WriteString(" } }")
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"query":     query.String(),
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化 GraphQL 请求失败: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", info.GraphQLURL(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Repo-Prompt-Web/1.0")
+
+	resp, err := c.graphqlClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 GraphQL 接口失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := ssoError(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub GraphQL 请求失败: %s - %s", resp.Status, string(body))
+	}
+
+	var result graphQLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析 GraphQL 响应失败: %w", err)
+	}
+	if result.Data.Repository == nil {
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("GraphQL 错误: %s", result.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("GraphQL 响应中没有仓库数据")
+	}
+
+	return result.Data.Repository, nil
+}
@@ -0,0 +1,153 @@
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+)
+
+// followSubmodules 获取子模块指向的仓库内容，合并到 root 中对应的子模块节点下，
+// 文件路径加上子模块路径前缀。返回无法获取的子模块及其中读取失败的文件
+func (c *Client) followSubmodules(info RepoInfo, branch, token string, opts models.ProcessOptions, depth int, submodules []treeEntry, root *models.TreeNode, fileContents map[string]models.FileContent, budget *requestBudget) []models.FileWarning {
+	var warnings []models.FileWarning
+
+	// 请求数已达上限时不再获取子模块，结果已标记为不完整
+	if !budget.take() {
+		return nil
+	}
+	content, err := c.getRawFile(info, branch, ".gitmodules", token)
+	if err != nil {
+		log.Printf("获取 .gitmodules 失败: %v", err)
+		for _, sub := range submodules {
+			warnings = append(warnings, models.FileWarning{Path: sub.Path, Reason: "无法读取 .gitmodules: " + err.Error()})
+		}
+		return warnings
+	}
+	urls := parseGitmodules(content)
+
+	for _, sub := range submodules {
+		rawURL, ok := urls[sub.Path]
+		if !ok {
+			warnings = append(warnings, models.FileWarning{Path: sub.Path, Reason: ".gitmodules 中没有该子模块"})
+			continue
+		}
+
+		subInfo, err := c.resolveSubmoduleURL(info, rawURL)
+		if err != nil {
+			log.Printf("跳过子模块 %s (%s): %v", sub.Path, rawURL, err)
+			warnings = append(warnings, models.FileWarning{Path: sub.Path, Reason: "子模块不是可访问的 GitHub 仓库: " + err.Error()})
+			continue
+		}
+		subInfo.Ref = sub.SHA
+
+		log.Printf("获取子模块 %s: %s/%s @ %s", sub.Path, subInfo.Owner, subInfo.Repo, sub.SHA)
+		subOpts := opts
+		subOpts.ExcludeDirs = opts.ExcludeDirsUnder(sub.Path)
+		// 进度只统计主仓库的文件
+		subOpts.Progress = nil
+		subResult, err := c.getTreeContents(subInfo, sub.SHA, token, subOpts, false, depth+1, budget)
+		if errors.Is(err, errBudgetExhausted) {
+			break
+		}
+		if err != nil {
+			log.Printf("获取子模块 %s 失败: %v", sub.Path, err)
+			warnings = append(warnings, models.FileWarning{Path: sub.Path, Reason: "获取子模块失败: " + err.Error()})
+			continue
+		}
+
+		node := root.AddPath(sub.Path)
+		node.IsDir = true
+		for name, child := range subResult.FileTree.Children {
+			node.Children[name] = child
+		}
+		// 子模块的文件树单独计算节点上限
+		root.Truncated = root.Truncated || subResult.FileTree.Truncated
+		for p, fc := range subResult.FileContents {
+			fc.Path = sub.Path + "/" + p
+			fileContents[fc.Path] = fc
+		}
+		for _, warning := range subResult.Warnings {
+			warning.Path = sub.Path + "/" + warning.Path
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+// resolveSubmoduleURL 将 .gitmodules 中的 URL 解析为仓库信息，支持相对父仓库的 "../" 形式
+func (c *Client) resolveSubmoduleURL(parent RepoInfo, rawURL string) (RepoInfo, error) {
+	if strings.HasPrefix(rawURL, "../") || strings.HasPrefix(rawURL, "./") {
+		host := parent.Host
+		if host == "" {
+			host = "github.com"
+		}
+		resolved := path.Join("/", parent.Owner, parent.Repo, rawURL)
+		rawURL = "https://" + host + resolved
+	}
+	return ParseRepoURL(rawURL, c.config.GetGithubEnterpriseHosts()...)
+}
+
+// parseGitmodules 解析 .gitmodules，返回子模块路径到 URL 的映射
+func parseGitmodules(content []byte) map[string]string {
+	urls := make(map[string]string)
+	var subPath, subURL string
+	flush := func() {
+		if subPath != "" && subURL != "" {
+			urls[strings.Trim(subPath, "/")] = subURL
+		}
+		subPath, subURL = "", ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "path":
+			subPath = strings.TrimSpace(value)
+		case "url":
+			subURL = strings.TrimSpace(value)
+		}
+	}
+	flush()
+	return urls
+}
+
+// getRawFile 通过 contents 接口获取任意文件的解码内容，不做文本类型和大小检查
+func (c *Client) getRawFile(info RepoInfo, branch, filePath, token string) ([]byte, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s", info.APIBase(), info.Owner, info.Repo, filePath, url.QueryEscape(branch))
+
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("请求文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("获取文件内容失败: %s - %s", resp.Status, string(body))
+	}
+
+	var content Content
+	if err := json.NewDecoder(resp.Body).Decode(&content); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(content.Content)
+}
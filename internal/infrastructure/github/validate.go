@@ -0,0 +1,54 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"repo-prompt-web/internal/domain/models"
+)
+
+// ValidateRepo 检查仓库和分支是否存在且可访问：只请求非递归的 git/trees 接口，不下载目录树和文件内容。
+// 未指定分支时依次尝试默认分支（或 main、master），返回第一个可访问的分支
+func (c *Client) ValidateRepo(info RepoInfo, token string) (string, error) {
+	var lastError error
+	for _, branch := range c.candidateBranches(info, token) {
+		err := c.checkTree(info, branch, token)
+		if err == nil {
+			return branch, nil
+		}
+		if !errors.Is(err, models.ErrRepoNotFound) {
+			return "", err
+		}
+		log.Printf("分支 %s 不可访问: %v", branch, err)
+		lastError = err
+	}
+	return "", lastError
+}
+
+// checkTree 请求分支的顶层目录树，将状态码映射为对应的错误
+func (c *Client) checkTree(info RepoInfo, branch, token string) error {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s", info.APIBase(), info.Owner, info.Repo, url.PathEscape(branch))
+
+	resp, err := c.makeRequest(apiURL, token)
+	if err != nil {
+		return fmt.Errorf("请求仓库树失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return models.ErrRepoNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", models.ErrRepoForbidden, resp.Status)
+	case http.StatusConflict:
+		// 空仓库的 git 接口返回 409 Conflict
+		return models.ErrEmptyRepository
+	default:
+		return fmt.Errorf("GitHub API 请求失败: %s", resp.Status)
+	}
+}
@@ -0,0 +1,100 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
+)
+
+var (
+	// ErrURLNotAllowed 表示 URL 的协议或主机不在配置的允许列表中
+	ErrURLNotAllowed = errors.New("URL 不在允许的协议或主机列表中")
+	// ErrTooLarge 表示远程文件超过大小限制
+	ErrTooLarge = errors.New("远程文件大小超过限制")
+)
+
+// Downloader 远程文件下载器
+type Downloader struct {
+	config     *config.Config
+	httpClient *http.Client
+}
+
+// NewDownloader 创建远程文件下载器实例
+func NewDownloader(cfg *config.Config, clients *httpclient.Factory) *Downloader {
+	d := &Downloader{config: cfg}
+	d.httpClient = clients.Client(httpclient.Options{
+		Name:    "RemoteZip",
+		Timeout: cfg.GetRemoteZipTimeout(),
+	})
+	// 重定向目标同样需要通过允许列表检查
+	d.httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("重定向次数过多")
+		}
+		if !cfg.IsRemoteURLAllowed(req.URL) {
+			return ErrURLNotAllowed
+		}
+		return nil
+	}
+	return d
+}
+
+// Download 将远程文件下载到临时文件并返回文件及其大小，超过 maxSize 时返回 ErrTooLarge。
+// 调用方负责关闭并删除返回的临时文件
+func (d *Downloader) Download(rawURL string, maxSize int64) (*os.File, int64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, fmt.Errorf("无效的 URL: %w", err)
+	}
+	if !d.config.IsRemoteURLAllowed(u) {
+		return nil, 0, ErrURLNotAllowed
+	}
+
+	log.Printf("开始下载远程文件: %s", u.Redacted())
+	resp, err := d.httpClient.Get(u.String())
+	if err != nil {
+		if errors.Is(err, ErrURLNotAllowed) {
+			return nil, 0, ErrURLNotAllowed
+		}
+		return nil, 0, fmt.Errorf("下载远程文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("下载远程文件失败: %s", resp.Status)
+	}
+
+	// 提前根据 Content-Length 拒绝过大的文件
+	if resp.ContentLength > maxSize {
+		return nil, 0, ErrTooLarge
+	}
+
+	tmpFile, err := os.CreateTemp("", "remote-zip-*.zip")
+	if err != nil {
+		return nil, 0, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+
+	// 多读一个字节用于判断是否超限
+	size, err := io.Copy(tmpFile, io.LimitReader(resp.Body, maxSize+1))
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		if errors.Is(err, ErrTooLarge) {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf("保存远程文件失败: %w", err)
+	}
+
+	log.Printf("远程文件下载完成: %s (%d 字节)", u.Redacted(), size)
+	return tmpFile, size, nil
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"repo-prompt-web/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// HandleAnalyzeStream 处理上传的 ZIP（或 zip_url），以 SSE 流式返回 DeepSeek 生成的项目架构分析，
+// 最后创建会话并在 done 事件中返回会话ID。处理 ZIP 出错时仍以 JSON 返回对应状态码
+func (h *FileHandler) HandleAnalyzeStream(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+	logger.Info("处理流式分析请求",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()))
+
+	if h.config.GetDeepseekAPIKey() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未配置 DeepSeek API 密钥"})
+		return
+	}
+
+	zipURL := c.Query("zip_url")
+	if zipURL == "" {
+		zipURL = c.PostForm("zip_url")
+	}
+
+	var files []*multipart.FileHeader
+	if zipURL == "" {
+		var status int
+		var err error
+		files, status, err = h.uploadedZips(c, requestID)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Debug("请求参数", params.logFields(requestID)...)
+
+	result, status, err := h.processZips(requestID, zipURL, files, &params)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	tempDir, err := writeTempProject(requestID, result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	// 先告知处理结果，分析生成期间客户端即可展示文件数量
+	c.SSEvent("processed", gin.H{
+		"source":     params.Source,
+		"file_count": len(result.FileContents),
+		"warnings":   result.Warnings,
+	})
+	c.Writer.Flush()
+
+	// 分析失败不影响创建会话，错误以 error 事件发送，会话仍可用于提问
+	totalLength := 0
+	projectAnalysis, err := h.promptService.GetProjectAnalysisStream(tempDir, func(delta string) {
+		totalLength += len(delta)
+		c.SSEvent("message", delta)
+		c.Writer.Flush()
+	})
+	if err != nil {
+		logger.Warn("流式生成项目架构分析失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		c.SSEvent("error", gin.H{"error": err.Error()})
+		c.Writer.Flush()
+	}
+
+	sessionID, err := h.createSession(c, requestID, params, result, projectAnalysis)
+	if err != nil {
+		c.SSEvent("error", gin.H{"error": err.Error()})
+		c.Writer.Flush()
+		return
+	}
+
+	done := gin.H{
+		"session_id":   sessionID,
+		"has_analysis": projectAnalysis != nil,
+		"total_length": totalLength,
+	}
+	if projectAnalysis != nil {
+		done["primary_language"] = projectAnalysis.PrimaryLanguage
+		done["frameworks"] = projectAnalysis.Frameworks
+	}
+	c.SSEvent("done", done)
+	c.Writer.Flush()
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"repo-prompt-web/internal/infrastructure/gemini"
+	"repo-prompt-web/pkg/logger"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// answerStream 一次流式发送回答的结果
+type answerStream struct {
+	answer       string // 本次连接收到的回答文本，继续读取时不含 offset 之前的部分
+	finishReason string
+	usage        *gemini.UsageMetadata
+	completed    bool // 回答正常结束，而不是出错或客户端断开
+}
+
+// setSSEHeaders 设置事件流响应头
+func setSSEHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Transfer-Encoding", "chunked")
+}
+
+// streamAnswerEvents 将 responseChan 中的回答以 message 事件发送，直到回答结束、出错或客户端断开。
+// 每个 message 事件的 ID 为截至该事件客户端已收到的回答字节数（从 offset 起算），断线后作为 Last-Event-ID 继续读取；
+// 客户端断开后在后台读完通道，使回答继续生成并保存到会话
+func (h *FileHandler) streamAnswerEvents(c *gin.Context, responseChan <-chan gemini.StreamChunk, offset int) answerStream {
+	var result answerStream
+	clientGone := c.Writer.CloseNotify()
+	answerBuilder := strings.Builder{}
+	sent := offset
+
+	// 长时间没有数据块时发送 SSE 注释，防止代理关闭空闲连接
+	var keepAliveC <-chan time.Time
+	keepAliveInterval := h.config.GetSSEKeepAliveInterval()
+	if keepAliveInterval > 0 {
+		keepAliveTicker := time.NewTicker(keepAliveInterval)
+		defer keepAliveTicker.Stop()
+		keepAliveC = keepAliveTicker.C
+	}
+	lastSent := time.Now()
+
+	// 合并数据块：累计达到 flushChars 个字符或每隔 flushInterval 发送一次，均为 0 时逐块立即发送
+	flushChars := getIntParam(c, "flush_chars", h.config.GetSSEFlushChars())
+	flushInterval := time.Duration(getIntParam(c, "flush_interval_ms", int(h.config.GetSSEFlushInterval()/time.Millisecond))) * time.Millisecond
+	var flushC <-chan time.Time
+	if flushInterval > 0 {
+		flushTicker := time.NewTicker(flushInterval)
+		defer flushTicker.Stop()
+		flushC = flushTicker.C
+	}
+	// 按 Markdown 语法边界切分时，可能被截断的语法暂存在 chunker 中，结束或出错前一并发送
+	var chunker *markdownChunker
+	if getBoolParamDefault(c, "markdown_safe", h.config.IsSSEMarkdownSafe()) {
+		chunker = newMarkdownChunker()
+	}
+	var pending strings.Builder
+	pendingChars := 0
+	flush := func() {
+		if pending.Len() == 0 {
+			return
+		}
+		sent += pending.Len()
+		c.Render(-1, sse.Event{Id: strconv.Itoa(sent), Event: "message", Data: pending.String()})
+		pending.Reset()
+		pendingChars = 0
+		lastSent = time.Now()
+	}
+	// finish 在回答结束或出错时发送包括暂存语法在内的全部剩余内容
+	finish := func() {
+		if chunker != nil {
+			pending.WriteString(chunker.Flush())
+		}
+		flush()
+	}
+
+	failed := false
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-clientGone:
+			// 客户端断开连接
+			return false
+		case <-flushC:
+			flush()
+			return true
+		case <-keepAliveC:
+			if time.Since(lastSent) >= keepAliveInterval {
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return false
+				}
+				lastSent = time.Now()
+			}
+			return true
+		case chunk, ok := <-responseChan:
+			if !ok {
+				// 通道已关闭
+				finish()
+				result.completed = true
+				return false
+			}
+
+			if chunk.Error != nil {
+				// 发生错误
+				finish()
+				c.SSEvent("error", gin.H{"error": chunk.Error.Error()})
+				failed = true
+				return false
+			}
+
+			if chunk.FinishReason != "" {
+				result.finishReason = chunk.FinishReason
+			}
+			if chunk.Usage != nil {
+				result.usage = chunk.Usage
+			}
+
+			// 发送数据块
+			answerBuilder.WriteString(chunk.Text)
+			text := chunk.Text
+			if chunker != nil {
+				text = chunker.Push(text)
+			}
+			pending.WriteString(text)
+			pendingChars += utf8.RuneCountInString(text)
+			if (flushChars == 0 && flushInterval == 0) || (flushChars > 0 && pendingChars >= flushChars) {
+				flush()
+			}
+			return true
+		}
+	})
+
+	if !result.completed && !failed {
+		go func() {
+			for range responseChan {
+			}
+		}()
+	}
+	result.answer = answerBuilder.String()
+	return result
+}
+
+// sendDoneEvent 发送结束事件，totalLength 为完整回答的字节数
+func (h *FileHandler) sendDoneEvent(c *gin.Context, sessionID string, stream answerStream, totalLength int) {
+	provider, model := h.aiService.ModelInfo()
+	done := gin.H{
+		"finish_reason": stream.finishReason,
+		"total_length":  totalLength,
+		"provider":      provider,
+		"model":         model,
+		"tokens_used":   h.aiService.SessionTokensUsed(sessionID),
+	}
+	if stream.usage != nil {
+		done["usage"] = stream.usage
+	}
+	c.SSEvent("done", done)
+	c.Writer.Flush()
+}
+
+// resumeAnswerStream 处理 resume=true：继续发送会话最近一次流式回答中客户端尚未收到的部分。
+// 起始位置为 Last-Event-ID 请求头（或 last_event_id 参数），未提供时从头重新发送已生成的内容；
+// 回答仍在生成时随进度继续发送，结束后发送 done 事件
+func (h *FileHandler) resumeAnswerStream(c *gin.Context, requestID string) {
+	sessionID := getStringParam(c, "session_id", "")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resume=true 时必须提供 session_id"})
+		return
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = getStringParam(c, "last_event_id", "0")
+	}
+	offset, err := strconv.Atoi(lastEventID)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID 必须是非负整数"})
+		return
+	}
+
+	if _, exists := h.sessions.Acquire(sessionID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
+		return
+	}
+	defer h.sessions.Release(sessionID)
+
+	question, offset, responseChan, err := h.aiService.ResumeAnswerStream(sessionID, offset)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Info("继续发送流式回答",
+		zap.String("request_id", requestID),
+		zap.String("session_id", sessionID),
+		zap.String("question", question),
+		zap.Int("offset", offset))
+
+	setSSEHeaders(c)
+	stream := h.streamAnswerEvents(c, responseChan, offset)
+	if stream.completed {
+		h.sendDoneEvent(c, sessionID, stream, offset+len(stream.answer))
+	}
+}
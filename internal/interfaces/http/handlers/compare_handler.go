@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"repo-prompt-web/internal/app/service"
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// compareSide 对比请求中的一个项目
+type compareSide struct {
+	SessionID string `json:"session_id"`
+	Source    string `json:"source"`
+	input     service.CompareInput
+}
+
+// HandleCompare 对比两个项目（GitHub 仓库 URL 或已有会话），生成共同技术、差异和迁移注意事项。
+// 通过 URL 提供的项目会创建新会话，可用于后续提问
+func (h *FileHandler) HandleCompare(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+	logger.Info("处理项目对比请求",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()))
+
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 对比依赖架构分析和文件结构，不支持仅架构分析模式下的文档过滤
+	params.PromptOnly = false
+	logger.Debug("请求参数", params.logFields(requestID)...)
+
+	a, status, err := h.resolveCompareSide(c, requestID, "a", params)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	defer h.sessions.Release(a.SessionID)
+	b, status, err := h.resolveCompareSide(c, requestID, "b", params)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	defer h.sessions.Release(b.SessionID)
+
+	comparison, err := h.aiService.CompareProjects(requestID, a.input, b.input)
+	if err != nil {
+		logger.Error("生成项目对比失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		if respondIfAIBusy(c, h.config, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider, model := h.aiService.ModelInfo()
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"a":          a,
+		"b":          b,
+		"comparison": comparison,
+		"provider":   provider,
+		"model":      model,
+	})
+}
+
+// resolveCompareSide 根据 url_<label> 或 session_<label> 获取一个项目的处理结果和架构分析，
+// 返回的会话已通过 Acquire 标记为使用中，调用方需在使用完毕后 Release。出错时返回对应的 HTTP 状态码
+func (h *FileHandler) resolveCompareSide(c *gin.Context, requestID, label string, params processParams) (*compareSide, int, error) {
+	sessionID := getStringParam(c, "session_"+label, "")
+	repoURL := getStringParam(c, "url_"+label, "")
+
+	source := "session:" + sessionID
+	if sessionID == "" {
+		source = repoURL
+		if repoURL == "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("请提供 url_%s 或 session_%s", label, label)
+		}
+
+		result, status, err := h.fetchGitHubRepo(c, repoURL, &params)
+		if err != nil {
+			return nil, status, err
+		}
+		var projectAnalysis *models.ProjectAnalysis
+		if h.config.GetDeepseekAPIKey() != "" {
+			projectAnalysis, err = h.generateProjectAnalysis(requestID, result)
+			if err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+		}
+		sessionID, err = h.createSession(c, requestID, params, result, projectAnalysis)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, models.ErrTooManySessions) {
+				status = http.StatusServiceUnavailable
+			}
+			return nil, status, err
+		}
+	}
+
+	sessionData, exists := h.sessions.Acquire(sessionID)
+	if !exists {
+		return nil, http.StatusNotFound, fmt.Errorf("会话 %s 不存在或已过期，请重新上传代码", sessionID)
+	}
+
+	// 已有会话缺少架构分析时补充生成，仅用于本次对比
+	projectAnalysis := sessionData.ProjectAnalysis
+	if projectAnalysis == nil && h.config.GetDeepseekAPIKey() != "" {
+		analysis, err := h.generateProjectAnalysis(requestID, sessionData.Result)
+		if err != nil {
+			h.sessions.Release(sessionID)
+			return nil, http.StatusInternalServerError, err
+		}
+		projectAnalysis = analysis
+	}
+
+	return &compareSide{
+		SessionID: sessionID,
+		Source:    source,
+		input: service.CompareInput{
+			Name:     source,
+			Result:   sessionData.Result,
+			Analysis: projectAnalysis,
+		},
+	}, http.StatusOK, nil
+}
@@ -1,500 +1,816 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"repo-prompt-web/internal/app/service"
 	"repo-prompt-web/internal/application"
 	"repo-prompt-web/internal/domain/models"
 	"repo-prompt-web/internal/infrastructure/github"
+	"repo-prompt-web/internal/infrastructure/remote"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/limiter"
 	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/stats"
 	"repo-prompt-web/pkg/types"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
-// SessionData 存储会话数据
-type SessionData struct {
-	Result          *types.ProcessResult
-	ProjectAnalysis *models.ProjectAnalysis
-	CreatedAt       time.Time
+// FileHandler HTTP 处理器
+type FileHandler struct {
+	fileService   *application.FileService
+	promptService *application.PromptService
+	githubClient  *github.Client
+	downloader    *remote.Downloader
+	aiService     *service.AIService
+	sessions      SessionStore
+	config        *config.Config
 }
 
-// SessionStorage 会话数据存储
-type SessionStorage struct {
-	sessions  map[string]SessionData
-	expiresIn time.Duration
-	mu        sync.RWMutex
+// NewFileHandler 创建 HTTP 处理器实例，sessions 为 nil 时使用 30 分钟过期、
+// 数量上限为 sessions.max_sessions 的内存会话存储
+func NewFileHandler(fileService *application.FileService, promptService *application.PromptService, githubClient *github.Client, downloader *remote.Downloader, aiService *service.AIService, sessions SessionStore, cfg *config.Config) *FileHandler {
+	if sessions == nil {
+		storage := NewSessionStorage(30 * time.Minute)
+		storage.SetMaxSessions(cfg.GetMaxSessions())
+		sessions = storage
+	}
+
+	return &FileHandler{
+		fileService:   fileService,
+		promptService: promptService,
+		githubClient:  githubClient,
+		downloader:    downloader,
+		aiService:     aiService,
+		sessions:      sessions,
+		config:        cfg,
+	}
 }
 
-// NewSessionStorage 创建新的会话存储
-func NewSessionStorage(expiresIn time.Duration) *SessionStorage {
-	if expiresIn <= 0 {
-		expiresIn = 30 * time.Minute
+// HandleCombineCode 处理文件合并请求，支持上传 ZIP 文件或通过 zip_url 指定远程 ZIP
+func (h *FileHandler) HandleCombineCode(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+	logger.Info("处理合并代码请求",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()))
+
+	// 远程 ZIP 地址，提供时无需上传文件
+	zipURL := c.Query("zip_url")
+	if zipURL == "" {
+		zipURL = c.PostForm("zip_url")
 	}
 
-	ss := &SessionStorage{
-		sessions:  make(map[string]SessionData),
-		expiresIn: expiresIn,
+	// 支持同一请求上传多个 codeZip 文件
+	var files []*multipart.FileHeader
+	if zipURL == "" {
+		var status int
+		var err error
+		files, status, err = h.uploadedZips(c, requestID)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	// 启动清理过期会话的后台任务
-	go ss.cleanExpiredSessions()
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Debug("请求参数", params.logFields(requestID)...)
 
-	return ss
-}
+	result, status, err := h.processZips(requestID, zipURL, files, &params)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
-// cleanExpiredSessions 清理过期会话
-func (ss *SessionStorage) cleanExpiredSessions() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		ss.mu.Lock()
-		for id, session := range ss.sessions {
-			if time.Since(session.CreatedAt) > ss.expiresIn {
-				delete(ss.sessions, id)
-				logger.Debug("已清理过期会话", zap.String("session_id", id))
-			}
+	// 如果需要生成项目架构分析
+	var projectAnalysis *models.ProjectAnalysis
+	if (params.GeneratePrompt || params.PromptOnly) && h.config.GetDeepseekAPIKey() != "" {
+		projectAnalysis, err = h.generateProjectAnalysis(requestID, result)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-		ss.mu.Unlock()
 	}
+
+	h.respondWithResult(c, requestID, params, result, projectAnalysis)
 }
 
-// Put 存储会话数据
-func (ss *SessionStorage) Put(result *types.ProcessResult, analysis *models.ProjectAnalysis) string {
-	ss.mu.Lock()
-	defer ss.mu.Unlock()
+// uploadedZips 读取请求中上传的 codeZip 文件并检查总大小，出错时返回对应的 HTTP 状态码
+func (h *FileHandler) uploadedZips(c *gin.Context, requestID string) ([]*multipart.FileHeader, int, error) {
+	var files []*multipart.FileHeader
+	form, formErr := c.MultipartForm()
+	if formErr == nil {
+		files = form.File["codeZip"]
+	}
+	if isBodyTooLarge(formErr) {
+		logger.Warn("请求体超过大小限制",
+			zap.String("request_id", requestID),
+			zap.Error(formErr))
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("请求体超过大小限制")
+	}
+	if len(files) == 0 {
+		logger.Warn("未上传ZIP文件",
+			zap.String("request_id", requestID),
+			zap.Error(formErr))
+		return nil, http.StatusBadRequest, fmt.Errorf("请上传 ZIP 文件或提供 zip_url")
+	}
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+		logger.Debug("接收到文件上传",
+			zap.String("request_id", requestID),
+			zap.String("file_name", file.Filename),
+			zap.Int64("file_size", file.Size))
+	}
+
+	if maxSize := uploadLimit(c, h.config.GetMaxUploadSize()); totalSize > maxSize {
+		logger.Warn("文件大小超过限制",
+			zap.String("request_id", requestID),
+			zap.Int("file_count", len(files)),
+			zap.Int64("file_size", totalSize),
+			zap.Int64("max_size", maxSize))
+		return nil, http.StatusBadRequest, fmt.Errorf("文件大小超过限制")
+	}
+	return files, http.StatusOK, nil
+}
 
-	sessionID := uuid.New().String()
-	ss.sessions[sessionID] = SessionData{
-		Result:          result,
-		ProjectAnalysis: analysis,
-		CreatedAt:       time.Now(),
+// processZips 处理远程 ZIP（zipURL 非空时）或上传的 ZIP 文件，并将代码来源记录到 params.Source。
+// 出错时返回对应的 HTTP 状态码
+func (h *FileHandler) processZips(requestID, zipURL string, files []*multipart.FileHeader, params *processParams) (*models.ProcessResult, int, error) {
+	var result *models.ProcessResult
+	var sourceName string
+	var err error
+	if zipURL != "" {
+		sourceName = zipURL
+		result, err = h.processRemoteZip(zipURL, params.MaxUploadSize, params.Options)
+	} else if len(files) == 1 {
+		sourceName = files[0].Filename
+		result, err = h.fileService.ProcessZipFile(files[0], params.Options)
+	} else {
+		// 多个归档合并处理，每个归档的文件位于以文件名命名的目录下
+		names := make([]string, len(files))
+		for i, file := range files {
+			names[i] = file.Filename
+		}
+		sourceName = strings.Join(names, ", ")
+		result, err = h.fileService.ProcessZipFiles(files, params.Options)
+	}
+	if err != nil {
+		logger.Error("处理ZIP文件失败",
+			zap.String("request_id", requestID),
+			zap.String("file_name", sourceName),
+			zap.Error(err))
+		status := http.StatusInternalServerError
+		if errors.Is(err, remote.ErrURLNotAllowed) || errors.Is(err, remote.ErrTooLarge) || errors.Is(err, models.ErrUnsupportedArchive) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, models.ErrExtractedTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		} else if errors.Is(err, models.ErrFileProcessing) {
+			status = http.StatusUnprocessableEntity
+		}
+		return nil, status, err
 	}
 
-	return sessionID
+	logger.Info("ZIP文件处理成功",
+		zap.String("request_id", requestID),
+		zap.String("file_name", sourceName),
+		zap.Int("files_count", len(result.FileContents)))
+	params.Source = sourceName
+	return result, http.StatusOK, nil
 }
 
-// Get 获取会话数据
-func (ss *SessionStorage) Get(sessionID string) (SessionData, bool) {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
+// HandleGitHubRepo 处理 GitHub 仓库请求
+func (h *FileHandler) HandleGitHubRepo(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+	logger.Info("处理GitHub仓库请求",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()))
 
-	session, exists := ss.sessions[sessionID]
-	if !exists {
-		return SessionData{}, false
+	repoURL := c.Query("url")
+	if repoURL == "" {
+		repoURL = c.PostForm("url")
+		if repoURL == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请提供 GitHub 仓库 URL"})
+			return
+		}
 	}
 
-	// 检查是否过期
-	if time.Since(session.CreatedAt) > ss.expiresIn {
-		return SessionData{}, false
+	// HEAD 请求或 validate=true 时只检查仓库和分支是否可访问，不获取内容
+	if c.Request.Method == http.MethodHead || getBoolParam(c, "validate") {
+		h.validateGitHubRepo(c, requestID, repoURL)
+		return
 	}
 
-	return session, true
-}
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Debug("请求参数", params.logFields(requestID)...)
 
-// 全局会话存储
-var sessionStorage = NewSessionStorage(30 * time.Minute)
+	if getBoolParam(c, "stream") {
+		h.streamGitHubRepo(c, requestID, repoURL, params)
+		return
+	}
 
-// FileHandler HTTP 处理器
-type FileHandler struct {
-	fileService   *application.FileService
-	promptService *application.PromptService
-	githubClient  *github.Client
-	aiService     *service.AIService
-	config        *config.Config
-}
+	result, status, err := h.fetchGitHubRepo(c, repoURL, &params)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
-// NewFileHandler 创建 HTTP 处理器实例
-func NewFileHandler(fileService *application.FileService, promptService *application.PromptService, githubClient *github.Client, aiService *service.AIService, cfg *config.Config) *FileHandler {
-	return &FileHandler{
-		fileService:   fileService,
-		promptService: promptService,
-		githubClient:  githubClient,
-		aiService:     aiService,
-		config:        cfg,
+	// 如果需要生成项目架构分析
+	var projectAnalysis *models.ProjectAnalysis
+	if (params.GeneratePrompt || params.PromptOnly) && h.config.GetDeepseekAPIKey() != "" {
+		projectAnalysis, err = h.generateProjectAnalysis(requestID, result)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
+
+	h.respondWithResult(c, requestID, params, result, projectAnalysis)
 }
 
-// HandleCombineCode 处理文件合并请求
-func (h *FileHandler) HandleCombineCode(c *gin.Context) {
+// HandleGitHubDiff 只处理 GitHub 仓库两个引用之间变更的文件（取 head 上的内容），适合代码评审。
+// 输出格式和处理参数与 /api/github-code 相同，响应额外包含变更列表 changes
+func (h *FileHandler) HandleGitHubDiff(c *gin.Context) {
 	requestID := c.GetString("RequestID")
-	logger.Info("处理合并代码请求",
-		zap.String("request_id", requestID),
-		zap.String("client_ip", c.ClientIP()))
 
-	file, err := c.FormFile("codeZip")
+	repo := getStringParam(c, "repo", "")
+	base := getStringParam(c, "base", "")
+	head := getStringParam(c, "head", "")
+	if repo == "" || base == "" || head == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供 repo（owner/repo 或仓库 URL）、base 和 head"})
+		return
+	}
+
+	// repo 可以是 owner/repo 简写或完整的仓库 URL
+	repoInfo, err := github.ParseRepoURL(repo, h.config.GetGithubEnterpriseHosts()...)
 	if err != nil {
-		logger.Warn("未上传ZIP文件",
-			zap.String("request_id", requestID),
-			zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传 ZIP 文件"})
+		repoInfo, err = github.ParseRepoURL("github.com/"+repo, h.config.GetGithubEnterpriseHosts()...)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if file.Size > h.config.GetMaxUploadSize() {
-		logger.Warn("文件大小超过限制",
-			zap.String("request_id", requestID),
-			zap.String("file_name", file.Filename),
-			zap.Int64("file_size", file.Size),
-			zap.Int64("max_size", h.config.GetMaxUploadSize()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小超过限制"})
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	params.Source = fmt.Sprintf("%s/%s@%s...%s", repoInfo.Owner, repoInfo.Repo, base, head)
 
-	logger.Debug("接收到文件上传",
+	logger.Info("获取GitHub变更文件",
 		zap.String("request_id", requestID),
-		zap.String("file_name", file.Filename),
-		zap.Int64("file_size", file.Size))
+		zap.String("owner", repoInfo.Owner),
+		zap.String("repo", repoInfo.Repo),
+		zap.String("base", base),
+		zap.String("head", head))
+	logger.Debug("请求参数", params.logFields(requestID)...)
 
-	// 从表单和URL查询参数中获取参数
-	formatQuery := c.DefaultQuery("format", "text")
-	formatForm := c.PostForm("format")
-	format := formatQuery
-	if formatForm != "" {
-		format = formatForm
+	result, err := h.githubClient.GetChangedFiles(repoInfo, base, head, h.githubToken(c), params.Options)
+	if err != nil {
+		logger.Error("获取变更文件失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		c.JSON(githubErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
-	useBase64Query := c.DefaultQuery("base64", "false") == "true"
-	useBase64Form := c.PostForm("base64") == "true"
-	useBase64 := useBase64Query || useBase64Form
+	h.respondWithResult(c, requestID, params, result, nil)
+}
 
-	// 是否生成项目架构分析
-	generatePromptQuery := c.DefaultQuery("generate_prompt", "false") == "true"
-	generatePromptForm := c.PostForm("generate_prompt") == "true"
-	generatePrompt := generatePromptQuery || generatePromptForm
+// streamGitHubRepo 以 SSE 返回获取仓库的进度：每处理完一个文件发送 progress 事件，
+// 最后以 result 事件返回与 format=json（或 format=paths）相同的响应体，出错时发送 error 事件
+func (h *FileHandler) streamGitHubRepo(c *gin.Context, requestID, repoURL string, params processParams) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
 
-	// 是否只返回提示词而不包含文件内容
-	promptOnlyQuery := c.DefaultQuery("prompt_only", "false") == "true"
-	promptOnlyForm := c.PostForm("prompt_only") == "true"
-	promptOnly := promptOnlyQuery || promptOnlyForm
+	sendError := func(status int, err error) {
+		c.SSEvent("error", gin.H{"error": err.Error(), "status": status})
+		c.Writer.Flush()
+	}
 
-	// 是否包含文件内容（与 promptOnly 互斥）
-	includeContentQuery := c.DefaultQuery("include_content", "false") == "true"
-	includeContentForm := c.PostForm("include_content") == "true"
-	includeContent := (includeContentQuery || includeContentForm) && !promptOnly
+	params.Options.Progress = func(progress models.FetchProgress) {
+		c.SSEvent("progress", progress)
+		c.Writer.Flush()
+	}
+	result, status, err := h.fetchGitHubRepo(c, repoURL, &params)
+	if err != nil {
+		sendError(status, err)
+		return
+	}
 
-	logger.Debug("请求参数",
-		zap.String("request_id", requestID),
-		zap.String("format", format),
-		zap.Bool("use_base64", useBase64),
-		zap.Bool("generate_prompt", generatePrompt),
-		zap.Bool("prompt_only", promptOnly),
-		zap.Bool("include_content", includeContent))
-
-	// 处理 ZIP 文件
-	result, err := h.fileService.ProcessZipFile(file, useBase64)
+	var projectAnalysis *models.ProjectAnalysis
+	if (params.GeneratePrompt || params.PromptOnly) && h.config.GetDeepseekAPIKey() != "" {
+		projectAnalysis, err = h.generateProjectAnalysis(requestID, result)
+		if err != nil {
+			sendError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	saved, err := h.saveResult(c, requestID, params, result, projectAnalysis)
 	if err != nil {
-		logger.Error("处理ZIP文件失败",
+		sendError(http.StatusServiceUnavailable, err)
+		return
+	}
+
+	c.SSEvent("result", resultResponse(params, saved, result, projectAnalysis))
+	c.Writer.Flush()
+}
+
+// validateGitHubRepo 检查仓库和分支是否存在且可访问，返回 200/404/403 等状态码和简短的 JSON
+func (h *FileHandler) validateGitHubRepo(c *gin.Context, requestID, repoURL string) {
+	repoInfo, err := github.ParseRepoURL(repoURL, h.config.GetGithubEnterpriseHosts()...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	branch, err := h.githubClient.ValidateRepo(repoInfo, h.githubToken(c))
+	if err != nil {
+		logger.Info("GitHub仓库校验未通过",
 			zap.String("request_id", requestID),
-			zap.String("file_name", file.Filename),
+			zap.String("owner", repoInfo.Owner),
+			zap.String("repo", repoInfo.Repo),
 			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(githubErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	logger.Info("ZIP文件处理成功",
-		zap.String("request_id", requestID),
-		zap.String("file_name", file.Filename),
-		zap.Int("files_count", len(result.FileContents)))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"owner":   repoInfo.Owner,
+		"repo":    repoInfo.Repo,
+		"branch":  branch,
+	})
+}
 
-	// 如果需要生成项目架构分析
-	var projectAnalysis *models.ProjectAnalysis
-	if (generatePrompt || promptOnly) && h.config.GetDeepseekAPIKey() != "" {
-		logger.Info("开始生成项目架构分析",
-			zap.String("request_id", requestID))
+// fetchGitHubRepo 获取 GitHub 仓库内容，并将代码来源记录到 params.Source。出错时返回对应的 HTTP 状态码。
+// 仅获取文档时设置 params.NoSession，只含文档的结果不能用于后续提问
+func (h *FileHandler) fetchGitHubRepo(c *gin.Context, repoURL string, params *processParams) (*models.ProcessResult, int, error) {
+	params.Source = repoURL
+	token := h.githubToken(c)
 
-		// 将处理结果写入临时文件夹
-		tempDir, err := os.MkdirTemp("", "repo-prompt-*")
+	// Gist 文件较少，始终获取全部内容
+	if github.IsGistURL(repoURL) {
+		gistInfo, err := github.ParseGistURL(repoURL)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建临时目录"})
-			return
+			return nil, http.StatusBadRequest, err
+		}
+		result, err := h.githubClient.GetGistContents(gistInfo, token, params.Options)
+		if err != nil {
+			return nil, githubErrorStatus(err), err
 		}
-		defer os.RemoveAll(tempDir)
+		return result, http.StatusOK, nil
+	}
 
-		// 创建临时项目结构
-		for path, content := range result.FileContents {
-			fullPath := filepath.Join(tempDir, path)
-			dirPath := filepath.Dir(fullPath)
+	repoInfo, err := github.ParseRepoURL(repoURL, h.config.GetGithubEnterpriseHosts()...)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
-			// 创建目录
-			if err := os.MkdirAll(dirPath, 0755); err != nil {
-				continue
-			}
+	// 仅需要架构分析时只下载文档文件，跳过大量文件内容的获取
+	var result *types.ProcessResult
+	if params.PromptOnly && h.config.GetDeepseekAPIKey() != "" {
+		result, err = h.githubClient.GetRepoDocuments(repoInfo, token, params.Options)
+		params.NoSession = true
+	} else {
+		result, err = h.githubClient.GetRepoContents(repoInfo, token, params.Options)
+	}
+	if err != nil {
+		return nil, githubErrorStatus(err), err
+	}
+	return result, http.StatusOK, nil
+}
 
-			// 写入文件内容
-			fileContent := content.Content
-			if content.IsBase64 {
-				// 这里应该有 base64 解码逻辑，但为简化示例，跳过
-				continue
-			}
+// respondIfAIBusy 在 AI 请求排队超时时返回 503 并设置 Retry-After，已响应时返回 true
+func respondIfAIBusy(c *gin.Context, cfg *config.Config, err error) bool {
+	if !errors.Is(err, limiter.ErrBusy) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(cfg.GetAIRetryAfterSeconds()))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	return true
+}
 
-			if err := os.WriteFile(fullPath, []byte(fileContent), 0644); err != nil {
-				continue
-			}
-		}
+// githubErrorStatus 将获取 GitHub 仓库时的错误映射为 HTTP 状态码
+func githubErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrSSORequired), errors.Is(err, models.ErrRepoForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrRepoNotFound), errors.Is(err, models.ErrGistNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrFileProcessing), errors.Is(err, models.ErrEmptyRepository):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
 
-		// 使用临时目录生成项目架构分析
-		projectAnalysis, err = h.promptService.GetProjectAnalysis(tempDir)
-		if err != nil {
-			logger.Warn("项目架构分析生成失败",
-				zap.String("request_id", requestID),
-				zap.Error(err))
-		} else {
-			logger.Info("项目架构分析生成成功",
-				zap.String("request_id", requestID))
+// validateQuestion 去除问题首尾空白并校验：不能为空，其余规则同 validateUserText
+func validateQuestion(question string, maxBytes int) (string, error) {
+	question, err := validateUserText("问题", question, maxBytes)
+	if err != nil {
+		return "", err
+	}
+	if question == "" {
+		return "", fmt.Errorf("请提供问题内容")
+	}
+	return question, nil
+}
+
+// validateUserText 去除用户输入文本的首尾空白并校验：不能超过 maxBytes 字节、
+// 必须是有效的 UTF-8 且不包含换行和制表符以外的控制字符。label 用于错误信息
+func validateUserText(label, text string, maxBytes int) (string, error) {
+	text = strings.TrimSpace(text)
+	if len(text) > maxBytes {
+		return "", fmt.Errorf("%s过长: %d 字节，最大允许 %d 字节", label, len(text), maxBytes)
+	}
+	if !utf8.ValidString(text) {
+		return "", fmt.Errorf("%s不是有效的 UTF-8 文本", label)
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return "", fmt.Errorf("%s包含非法控制字符", label)
 		}
 	}
+	return text, nil
+}
 
-	// 根据参数和格式决定返回方式
-	logger.Info("返回响应",
-		zap.String("request_id", requestID),
-		zap.String("format", format),
-		zap.Bool("prompt_only", promptOnly),
-		zap.Bool("generate_prompt", generatePrompt),
-		zap.Bool("has_prompt", projectAnalysis != nil))
-
-	// 保存会话数据以便后续提问
-	sessionID := sessionStorage.Put(result, projectAnalysis)
-	logger.Debug("已创建会话",
+// HandleSessionStats 返回当前会话数量，用于监控
+func (h *FileHandler) HandleSessionStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":     h.sessions.Count(),
+		"ai_sessions":  h.aiService.SessionCount(),
+		"max_sessions": h.config.GetMaxSessions(),
+	})
+}
+
+// HandleStats 返回进程启动以来的累计统计（创建的会话数、AI 调用次数、处理的字节数、运行时长）和当前会话数
+func (h *FileHandler) HandleStats(c *gin.Context) {
+	snapshot := stats.Get()
+	c.JSON(http.StatusOK, gin.H{
+		"sessions_created": snapshot.SessionsCreated,
+		"active_sessions":  h.sessions.Count(),
+		"ai_sessions":      h.aiService.SessionCount(),
+		"ai_calls":         snapshot.AICalls,
+		"bytes_processed":  snapshot.BytesProcessed,
+		"started_at":       snapshot.StartedAt,
+		"uptime_seconds":   snapshot.UptimeSeconds,
+	})
+}
+
+// HandleSessionPrompt 以纯文本导出会话的初始提示词（即问答时发送给模型的完整上下文）
+func (h *FileHandler) HandleSessionPrompt(c *gin.Context) {
+	sessionID := c.Param("id")
+	sessionData, exists := h.sessions.Get(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
+		return
+	}
+
+	prompt := h.aiService.InitialPrompt(sessionData.Result, sessionData.ProjectAnalysis, sessionID, sessionData.ContextExclude, sessionData.Dedupe)
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", sessionID+"-prompt.txt"))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(prompt))
+}
+
+// HandleSessionAnalysis 以 Markdown 附件下载会话保存的项目架构分析，include_tree=true 时在分析之前附上文件结构
+func (h *FileHandler) HandleSessionAnalysis(c *gin.Context) {
+	sessionID := c.Param("id")
+	sessionData, exists := h.sessions.Get(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
+		return
+	}
+	analysis := sessionData.ProjectAnalysis
+	if analysis == nil || len(analysis.PromptSuggestions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话中没有项目架构分析，处理代码时请设置 generate_prompt=true"})
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("# 项目架构分析\n\n")
+	if getBoolParam(c, "include_tree") && sessionData.Result.FileTree != nil {
+		var tree bytes.Buffer
+		sessionData.Result.FileTree.PrintDepth(&tree, "", true, getIntParam(c, "tree_max_depth", h.config.GetTreeMaxDepth()))
+		b.WriteString("## 项目结构\n\n```text\n")
+		b.Write(tree.Bytes())
+		b.WriteString("```\n\n")
+	}
+	b.WriteString(analysis.PromptSuggestions[0])
+	b.WriteString("\n")
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sessionID+"-analysis.md"))
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(b.String()))
+}
+
+// HandleSessionCombined 从会话保存的处理结果重新生成合并输出，无需重新上传或获取代码。
+// format 与 /api/combine-code 相同（text、json 或 paths），文本格式同样支持 tree_max_depth 等输出参数
+func (h *FileHandler) HandleSessionCombined(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+	sessionID := c.Param("id")
+	sessionData, exists := h.sessions.Get(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
+		return
+	}
+
+	params := processParams{
+		Format:       c.DefaultQuery("format", h.config.GetDefaultFormat()),
+		PreviewBytes: getIntParam(c, "preview_bytes", 0),
+		Output:       parseOutputOptions(c, h.config),
+		Sections:     getBoolParam(c, "sections"),
+	}
+	result := sessionData.Result
+	logger.Info("导出会话合并输出",
 		zap.String("request_id", requestID),
-		zap.String("session_id", sessionID))
-
-	if promptOnly && projectAnalysis != nil {
-		// 只返回提示词
-		if format == "json" {
-			c.JSON(http.StatusOK, gin.H{
-				"success":          true,
-				"session_id":       sessionID,
-				"project_analysis": projectAnalysis,
-			})
-		} else {
-			c.String(http.StatusOK, fmt.Sprintf("# 会话ID\n%s\n\n# 项目架构分析\n\n%s", sessionID, projectAnalysis.PromptSuggestions[0]))
-		}
-	} else if generatePrompt && projectAnalysis != nil {
-		// 返回提示词和内容
-		if format == "json" {
-			response := gin.H{
-				"success":          true,
-				"session_id":       sessionID,
-				"project_analysis": projectAnalysis,
-			}
+		zap.String("session_id", sessionID),
+		zap.String("format", params.Format))
 
-			// 如果需要包含文件内容
-			if includeContent {
-				response["file_tree"] = result.FileTree
-				response["file_contents"] = result.FileContents
-			} else {
-				response["result"] = result
-			}
+	if params.Format == "paths" || params.Format == "json" {
+		c.JSON(http.StatusOK, resultResponse(params, savedResult{sessionID: sessionID}, result, nil))
+		return
+	}
+	if params.Sections {
+		h.streamSectionsOutput(c, requestID, resultSections(savedResult{sessionID: sessionID}, result), result, params.Output, true)
+		return
+	}
 
-			c.JSON(http.StatusOK, response)
-		} else {
-			output := fmt.Sprintf("# 会话ID\n%s\n\n# 项目架构分析\n\n%s\n\n", sessionID, projectAnalysis.PromptSuggestions[0])
-			if includeContent {
-				output += fmt.Sprintf("# 文件内容\n\n%s", h.fileService.FormatOutput(result))
-			}
-			c.String(http.StatusOK, output)
+	header := fmt.Sprintf("# 会话ID\n%s\n\n", sessionID)
+	if len(result.Changes) > 0 {
+		header += formatChanges(result.Changes)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", sessionID+"-combined.txt"))
+	h.streamTextOutput(c, requestID, header+"# 文件内容\n\n", result, params.Output)
+}
+
+// HandleSessionFile 返回会话中单个文件的完整内容，用于预览模式下按需获取被截断的文件
+func (h *FileHandler) HandleSessionFile(c *gin.Context) {
+	sessionID := c.Param("id")
+	sessionData, exists := h.sessions.Get(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
+		return
+	}
+
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供文件路径"})
+		return
+	}
+	content, ok := sessionData.Result.FileContents[path]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话中不存在该文件: " + path})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// contextExcludeRequest 设置上下文排除规则的 JSON 请求体
+type contextExcludeRequest struct {
+	Exclude []string `json:"exclude"`
+}
+
+// HandleSessionContextExclude 替换会话的上下文排除规则，匹配的文件内容不再放入后续提问的提示词。
+// 规则可为完整路径、以 "/" 结尾的目录或通配符（匹配完整路径或文件名），传空列表清除排除
+func (h *FileHandler) HandleSessionContextExclude(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	var patterns []string
+	if c.ContentType() == "application/json" {
+		var request contextExcludeRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数", "details": err.Error()})
+			return
 		}
+		patterns = request.Exclude
 	} else {
-		// 正常响应，不包含提示词
-		if format == "json" {
-			c.JSON(http.StatusOK, gin.H{
-				"success":    true,
-				"session_id": sessionID,
-				"result":     result,
-			})
-		} else {
-			output := fmt.Sprintf("# 会话ID\n%s\n\n# 文件内容\n\n%s", sessionID, h.fileService.FormatOutput(result))
-			c.String(http.StatusOK, output)
+		patterns = getListParam(c, "exclude")
+	}
+
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(filepath.ToSlash(strings.TrimSpace(pattern)), "./")
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的排除规则: " + pattern})
+			return
 		}
+		normalized = append(normalized, pattern)
 	}
+
+	if !h.sessions.SetContextExclude(sessionID, normalized) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
+		return
+	}
+	sessionData, _ := h.sessions.Get(sessionID)
+
+	excludedFiles := []string{}
+	for filePath := range sessionData.Result.FileContents {
+		if service.IsContextExcluded(filePath, normalized) {
+			excludedFiles = append(excludedFiles, filePath)
+		}
+	}
+	sort.Strings(excludedFiles)
+
+	logger.Info("已更新会话的上下文排除规则",
+		zap.String("request_id", c.GetString("RequestID")),
+		zap.String("session_id", sessionID),
+		zap.Strings("exclude", normalized),
+		zap.Int("excluded_files", len(excludedFiles)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"session_id":     sessionID,
+		"exclude":        normalized,
+		"excluded_files": excludedFiles,
+	})
 }
 
-// HandleGitHubRepo 处理 GitHub 仓库请求
-func (h *FileHandler) HandleGitHubRepo(c *gin.Context) {
+// HandleRepoTree 只返回 GitHub 仓库的目录树（含文件大小和类型），不获取文件内容
+func (h *FileHandler) HandleRepoTree(c *gin.Context) {
 	requestID := c.GetString("RequestID")
-	logger.Info("处理GitHub仓库请求",
-		zap.String("request_id", requestID),
-		zap.String("client_ip", c.ClientIP()))
 
 	repoURL := c.Query("url")
 	if repoURL == "" {
-		repoURL = c.PostForm("url")
-		if repoURL == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "请提供 GitHub 仓库 URL"})
-			return
-		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供 GitHub 仓库 URL"})
+		return
 	}
 
-	// 从表单和URL查询参数中获取参数
-	formatQuery := c.DefaultQuery("format", "text")
-	formatForm := c.PostForm("format")
-	format := formatQuery
-	if formatForm != "" {
-		format = formatForm
+	repoInfo, err := github.ParseRepoURL(repoURL, h.config.GetGithubEnterpriseHosts()...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// branch 参数优先于 URL 中的引用
+	if branch := c.Query("branch"); branch != "" {
+		repoInfo.Ref = branch
 	}
 
-	useBase64Query := c.DefaultQuery("base64", "false") == "true"
-	useBase64Form := c.PostForm("base64") == "true"
-	useBase64 := useBase64Query || useBase64Form
-
-	// 是否生成项目架构分析
-	generatePromptQuery := c.DefaultQuery("generate_prompt", "false") == "true"
-	generatePromptForm := c.PostForm("generate_prompt") == "true"
-	generatePrompt := generatePromptQuery || generatePromptForm
+	logger.Info("获取GitHub仓库目录树",
+		zap.String("request_id", requestID),
+		zap.String("owner", repoInfo.Owner),
+		zap.String("repo", repoInfo.Repo),
+		zap.String("ref", repoInfo.Ref))
 
-	// 是否只返回提示词而不包含文件内容
-	promptOnlyQuery := c.DefaultQuery("prompt_only", "false") == "true"
-	promptOnlyForm := c.PostForm("prompt_only") == "true"
-	promptOnly := promptOnlyQuery || promptOnlyForm
+	tree, err := h.githubClient.GetRepoTree(repoInfo, h.githubToken(c))
+	if err != nil {
+		logger.Error("获取仓库目录树失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		c.JSON(githubErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	// 是否包含文件内容（与 promptOnly 互斥）
-	includeContentQuery := c.DefaultQuery("include_content", "false") == "true"
-	includeContentForm := c.PostForm("include_content") == "true"
-	includeContent := (includeContentQuery || includeContentForm) && !promptOnly
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"branch":    tree.Branch,
+		"truncated": tree.Truncated,
+		"file_tree": tree.FileTree,
+	})
+}
 
+// githubToken 返回请求中的 GitHub 令牌，未提供时使用配置中的密钥
+func (h *FileHandler) githubToken(c *gin.Context) string {
 	token := c.Query("token")
 	if token == "" {
 		token = c.PostForm("token")
-		if token == "" {
-			token = h.config.GetGithubAPIKey()
-		}
 	}
+	if token == "" {
+		token = h.config.GetGithubAPIKey()
+	}
+	return token
+}
 
-	owner, repo, err := github.ParseRepoURL(repoURL)
+// processRemoteZip 下载不超过 maxSize 字节的远程 ZIP，并按上传文件相同的流程处理
+func (h *FileHandler) processRemoteZip(zipURL string, maxSize int64, opts models.ProcessOptions) (*models.ProcessResult, error) {
+	tmpFile, size, err := h.downloader.Download(zipURL, maxSize)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return nil, err
+	}
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+
+	// 远程 gzip 文件以 URL 路径的最后一级作为文件名
+	name := zipURL
+	if u, err := url.Parse(zipURL); err == nil {
+		name = path.Base(u.Path)
 	}
+	return h.fileService.ProcessZipReader(tmpFile, size, name, opts)
+}
+
+// generateProjectAnalysis 将处理结果写入临时目录并生成项目架构分析。
+// 分析失败只记录日志并返回 nil 分析，在无法创建临时目录或只含文档的结果分析失败时返回错误
+func (h *FileHandler) generateProjectAnalysis(requestID string, result *types.ProcessResult) (*models.ProjectAnalysis, error) {
+	logger.Info("开始生成项目架构分析",
+		zap.String("request_id", requestID))
 
-	result, err := h.githubClient.GetRepoContents(owner, repo, token, useBase64)
+	tempDir, err := writeTempProject(requestID, result)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, err
 	}
+	defer os.RemoveAll(tempDir)
 
-	// 如果需要生成项目架构分析
-	var projectAnalysis *models.ProjectAnalysis
-	if (generatePrompt || promptOnly) && h.config.GetDeepseekAPIKey() != "" {
-		logger.Info("开始生成项目架构分析",
-			zap.String("request_id", requestID))
-
-		// 将处理结果写入临时文件夹
-		tempDir, err := os.MkdirTemp("", "repo-prompt-*")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建临时目录"})
-			return
+	// 使用临时目录生成项目架构分析
+	projectAnalysis, err := h.promptService.GetProjectAnalysis(tempDir)
+	if err != nil {
+		logger.Warn("项目架构分析生成失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		// 只含文档的结果仅用于架构分析，分析失败时没有可返回的内容
+		if result.DocsOnly {
+			return nil, fmt.Errorf("项目架构分析生成失败: %w", err)
 		}
-		defer os.RemoveAll(tempDir)
+		return nil, nil
+	}
 
-		// 创建临时项目结构
-		for path, content := range result.FileContents {
-			fullPath := filepath.Join(tempDir, path)
-			dirPath := filepath.Dir(fullPath)
+	logger.Info("项目架构分析生成成功",
+		zap.String("request_id", requestID))
+	return projectAnalysis, nil
+}
 
-			// 创建目录
-			if err := os.MkdirAll(dirPath, 0755); err != nil {
-				continue
-			}
+// writeTempProject 将处理结果写入新建的临时目录，供生成架构分析使用，调用方负责删除返回的目录
+func writeTempProject(requestID string, result *types.ProcessResult) (string, error) {
+	// 将处理结果写入临时文件夹
+	tempDir, err := os.MkdirTemp("", "repo-prompt-*")
+	if err != nil {
+		return "", fmt.Errorf("无法创建临时目录")
+	}
 
-			// 写入文件内容
-			fileContent := content.Content
-			if content.IsBase64 {
-				// 这里应该有 base64 解码逻辑，但为简化示例，跳过
-				continue
-			}
+	// 创建临时项目结构
+	for path, content := range result.FileContents {
+		fullPath, ok := safeJoin(tempDir, path)
+		if !ok {
+			logger.Warn("跳过超出临时目录的文件路径",
+				zap.String("request_id", requestID),
+				zap.String("path", path))
+			continue
+		}
+		dirPath := filepath.Dir(fullPath)
 
-			if err := os.WriteFile(fullPath, []byte(fileContent), 0644); err != nil {
-				continue
-			}
+		// 创建目录
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			continue
 		}
 
-		// 使用临时目录生成项目架构分析
-		projectAnalysis, err = h.promptService.GetProjectAnalysis(tempDir)
-		if err != nil {
-			logger.Warn("项目架构分析生成失败",
-				zap.String("request_id", requestID),
-				zap.Error(err))
-		} else {
-			logger.Info("项目架构分析生成成功",
-				zap.String("request_id", requestID))
+		// 写入文件内容
+		fileContent := content.Content
+		if content.IsBase64 {
+			// 这里应该有 base64 解码逻辑，但为简化示例，跳过
+			continue
+		}
+
+		if err := os.WriteFile(fullPath, []byte(fileContent), 0644); err != nil {
+			continue
 		}
 	}
 
-	// 根据参数和格式决定返回方式
-	logger.Info("返回响应",
-		zap.String("request_id", requestID),
-		zap.String("format", format),
-		zap.Bool("prompt_only", promptOnly),
-		zap.Bool("generate_prompt", generatePrompt),
-		zap.Bool("has_prompt", projectAnalysis != nil))
-
-	// 保存会话数据以便后续提问
-	sessionID := sessionStorage.Put(result, projectAnalysis)
-	logger.Debug("已创建会话",
-		zap.String("request_id", requestID),
-		zap.String("session_id", sessionID))
-
-	if promptOnly && projectAnalysis != nil {
-		// 只返回提示词
-		if format == "json" {
-			c.JSON(http.StatusOK, gin.H{
-				"success":          true,
-				"session_id":       sessionID,
-				"project_analysis": projectAnalysis,
-			})
-		} else {
-			c.String(http.StatusOK, fmt.Sprintf("# 会话ID\n%s\n\n# 项目架构分析\n\n%s", sessionID, projectAnalysis.PromptSuggestions[0]))
-		}
-	} else if generatePrompt && projectAnalysis != nil {
-		// 返回提示词和内容
-		if format == "json" {
-			response := gin.H{
-				"success":          true,
-				"session_id":       sessionID,
-				"project_analysis": projectAnalysis,
+	// 只获取文档时，未下载内容的文件写入空占位文件，使目录结构保持完整
+	if result.DocsOnly && result.FileTree != nil {
+		for _, path := range result.FileTree.FilePaths() {
+			if _, ok := result.FileContents[path]; ok {
+				continue
 			}
-
-			// 如果需要包含文件内容
-			if includeContent {
-				response["file_tree"] = result.FileTree
-				response["file_contents"] = result.FileContents
-			} else {
-				response["result"] = result
+			fullPath, ok := safeJoin(tempDir, path)
+			if !ok {
+				continue
 			}
-
-			c.JSON(http.StatusOK, response)
-		} else {
-			output := fmt.Sprintf("# 会话ID\n%s\n\n# 项目架构分析\n\n%s\n\n", sessionID, projectAnalysis.PromptSuggestions[0])
-			if includeContent {
-				output += fmt.Sprintf("# 文件内容\n\n%s", h.fileService.FormatOutput(result))
+			if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+				continue
 			}
-			c.String(http.StatusOK, output)
-		}
-	} else {
-		// 正常响应，不包含提示词
-		if format == "json" {
-			c.JSON(http.StatusOK, gin.H{
-				"success":    true,
-				"session_id": sessionID,
-				"result":     result,
-			})
-		} else {
-			output := fmt.Sprintf("# 会话ID\n%s\n\n# 文件内容\n\n%s", sessionID, h.fileService.FormatOutput(result))
-			c.String(http.StatusOK, output)
+			_ = os.WriteFile(fullPath, nil, 0644)
 		}
 	}
+	return tempDir, nil
 }
 
 // HandleAskCodeQuestion 处理关于代码的问题
@@ -504,6 +820,12 @@ func (h *FileHandler) HandleAskCodeQuestion(c *gin.Context) {
 		zap.String("request_id", requestID),
 		zap.String("client_ip", c.ClientIP()))
 
+	// 断线后继续读取上一次的流式回答，无需重新提问
+	if getBoolParam(c, "resume") {
+		h.resumeAnswerStream(c, requestID)
+		return
+	}
+
 	// 获取问题
 	question := c.Query("question")
 	if question == "" {
@@ -513,49 +835,133 @@ func (h *FileHandler) HandleAskCodeQuestion(c *gin.Context) {
 			return
 		}
 	}
+	question, err := validateQuestion(question, h.config.GetMaxQuestionBytes())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// 获取会话ID (用于关联先前上传的ZIP文件)
+	// 获取会话ID (用于关联先前上传的ZIP文件)，未提供时根据 url、zip_url 或上传的 codeZip 创建一次性会话
 	sessionID := c.Query("session_id")
 	if sessionID == "" {
 		sessionID = c.PostForm("session_id")
-		if sessionID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "请提供会话ID"})
+	}
+	oneShot := sessionID == ""
+	if oneShot {
+		var status int
+		sessionID, status, err = h.createOneShotSession(c, requestID)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 	}
 
-	// 检查会话数据是否存在
-	sessionData, exists := sessionStorage.Get(sessionID)
+	// 检查会话数据是否存在，处理期间标记为使用中以免被淘汰
+	sessionData, exists := h.sessions.Acquire(sessionID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期，请重新上传代码"})
 		return
 	}
+	defer h.sessions.Release(sessionID)
+
+	logger.Audit("question_asked",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()),
+		zap.String("session_id", sessionID),
+		zap.String("question", question))
+
+	// 限定放入上下文的文件，必须是会话中存在的文本文件
+	files := getListParam(c, "files")
+	for _, path := range files {
+		content, ok := sessionData.Result.FileContents[path]
+		if !ok || content.IsBase64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "会话中不存在该文本文件: " + path})
+			return
+		}
+	}
+
+	// 追加到系统提示的额外要求（instructions 或 system），对该会话后续提问持续生效
+	instructions := getStringParam(c, "instructions", getStringParam(c, "system", ""))
+	instructions, err = validateUserText("额外要求", instructions, h.config.GetMaxQuestionBytes())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 是否从回答中提取引用的文件和行号
+	citations := getBoolParam(c, "citations")
+	askOpts := service.AskOptions{
+		Files:        files,
+		Instructions: instructions,
+		Citations:    citations,
+		Exclude:      sessionData.ContextExclude,
+		Dedupe:       sessionData.Dedupe,
+	}
 
 	// 获取流式参数
 	streamParam := c.DefaultQuery("stream", "false")
 	useStream := streamParam == "true"
 
+	// answer_format=json 时要求模型按 answer_schema（未提供时使用默认 Schema）以 JSON 回答
+	answerFormat := getStringParam(c, "answer_format", "text")
+	switch answerFormat {
+	case "text":
+	case "json":
+		if useStream {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "answer_format=json 不支持流式回答"})
+			return
+		}
+		schemaText, err := validateUserText("answer_schema", getStringParam(c, "answer_schema", ""), h.config.GetMaxQuestionBytes())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if schemaText == "" {
+			schemaText = service.DefaultAnswerSchema
+		}
+		askOpts.AnswerSchema, err = service.ParseAnswerSchema(schemaText)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "answer_format 只支持 text 或 json"})
+		return
+	}
+
+	// 是否生成追问建议（额外一次模型调用，默认关闭）
+	suggestFollowups := getBoolParam(c, "suggest_followups")
+
 	logger.Debug("问题参数",
 		zap.String("request_id", requestID),
 		zap.String("question", question),
 		zap.String("session_id", sessionID),
-		zap.Bool("stream", useStream))
+		zap.Bool("stream", useStream),
+		zap.Bool("suggest_followups", suggestFollowups),
+		zap.Bool("citations", citations),
+		zap.String("answer_format", answerFormat),
+		zap.Strings("files", files),
+		zap.Bool("has_instructions", instructions != ""))
 
 	// 根据是否流式处理选择不同的方法
 	if useStream {
-		// 流式处理
-		c.Header("Content-Type", "text/event-stream")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
-		c.Header("Transfer-Encoding", "chunked")
-
-		// 获取响应通道
+		// 流式处理，获取响应通道
 		responseChan, err := h.aiService.AskQuestionAboutCodeStream(
 			sessionData.Result,
 			sessionData.ProjectAnalysis,
 			question,
 			sessionID, // 传递sessionID用于会话记忆
+			requestID,
+			askOpts,
 		)
+		// 排队超时时尚未开始事件流，以 503 返回便于客户端按 Retry-After 重试
+		if err != nil && respondIfAIBusy(c, h.config, err) {
+			return
+		}
+		if errors.Is(err, models.ErrTokenBudgetExceeded) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		setSSEHeaders(c)
 		if err != nil {
 			logger.Error("流式处理代码问题失败",
 				zap.String("request_id", requestID),
@@ -565,30 +971,38 @@ func (h *FileHandler) HandleAskCodeQuestion(c *gin.Context) {
 			return
 		}
 
-		// 设置请求上下文，以便在客户端断开连接时取消处理
-		clientGone := c.Writer.CloseNotify()
-		c.Stream(func(w io.Writer) bool {
-			select {
-			case <-clientGone:
-				// 客户端断开连接
-				return false
-			case chunk, ok := <-responseChan:
-				if !ok {
-					// 通道已关闭
-					return false
-				}
-
-				if chunk.Error != nil {
-					// 发生错误
-					c.SSEvent("error", gin.H{"error": chunk.Error.Error()})
-					return false
-				}
-
-				// 发送数据块
-				c.SSEvent("message", chunk.Text)
-				return true
+		// 一次性提问时先告知新建的会话ID，便于客户端继续追问
+		if oneShot {
+			c.SSEvent("session", gin.H{"session_id": sessionID})
+			c.Writer.Flush()
+		}
+
+		stream := h.streamAnswerEvents(c, responseChan, 0)
+		completed := stream.completed
+
+		// 回答完整结束后再发送追问建议
+		if completed && suggestFollowups {
+			followups, err := h.aiService.SuggestFollowups(requestID, question, stream.answer)
+			if err != nil {
+				logger.Warn("生成追问建议失败",
+					zap.String("request_id", requestID),
+					zap.Error(err))
+			} else {
+				c.SSEvent("followups", followups)
+				c.Writer.Flush()
 			}
-		})
+		}
+
+		// 引用在完整回答生成后才能提取
+		if completed && citations {
+			c.SSEvent("citations", service.ExtractCitations(stream.answer, sessionData.Result))
+			c.Writer.Flush()
+		}
+
+		// 发送结束事件，便于客户端区分正常结束和连接中断
+		if completed {
+			h.sendDoneEvent(c, sessionID, stream, len(stream.answer))
+		}
 	} else {
 		// 非流式处理
 		response, err := h.aiService.AskQuestionAboutCode(
@@ -596,12 +1010,23 @@ func (h *FileHandler) HandleAskCodeQuestion(c *gin.Context) {
 			sessionData.ProjectAnalysis,
 			question,
 			sessionID, // 传递sessionID用于会话记忆
+			requestID,
+			askOpts,
 		)
 		if err != nil {
 			logger.Error("处理代码问题失败",
 				zap.String("request_id", requestID),
 				zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			if respondIfAIBusy(c, h.config, err) {
+				return
+			}
+			status := http.StatusInternalServerError
+			if errors.Is(err, models.ErrTooManySessions) {
+				status = http.StatusServiceUnavailable
+			} else if errors.Is(err, models.ErrTokenBudgetExceeded) {
+				status = http.StatusTooManyRequests
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 
@@ -611,10 +1036,101 @@ func (h *FileHandler) HandleAskCodeQuestion(c *gin.Context) {
 			zap.Int("response_length", len(response)))
 
 		// 返回结果
-		c.JSON(http.StatusOK, gin.H{
-			"success":  true,
-			"question": question,
-			"answer":   response,
-		})
+		provider, model := h.aiService.ModelInfo()
+		result := gin.H{
+			"success":     true,
+			"question":    question,
+			"answer":      response,
+			"provider":    provider,
+			"model":       model,
+			"tokens_used": h.aiService.SessionTokensUsed(sessionID),
+		}
+		if oneShot {
+			result["session_id"] = sessionID
+		}
+		if askOpts.AnswerSchema != nil {
+			// 重试后仍未通过校验时以原始文本返回，answer_format 标明实际的格式
+			if parsed, err := service.ParseJSONAnswer(response, askOpts.AnswerSchema); err != nil {
+				logger.Warn("JSON 回答未通过校验，以文本返回",
+					zap.String("request_id", requestID),
+					zap.Error(err))
+				result["answer_format"] = "text"
+				result["answer_error"] = err.Error()
+			} else {
+				result["answer"] = parsed
+				result["answer_format"] = "json"
+			}
+		}
+		if citations {
+			result["citations"] = service.ExtractCitations(response, sessionData.Result)
+		}
+
+		if suggestFollowups {
+			followups, err := h.aiService.SuggestFollowups(requestID, question, response)
+			if err != nil {
+				// 追问建议失败不影响主回答
+				logger.Warn("生成追问建议失败",
+					zap.String("request_id", requestID),
+					zap.Error(err))
+			} else {
+				result["followups"] = followups
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// createOneShotSession 在提问时未提供会话ID的情况下，处理 url（GitHub 仓库）、zip_url 或上传的 codeZip
+// 并创建新会话，处理参数与 /api/github-code、/api/combine-code 相同。出错时返回对应的 HTTP 状态码
+func (h *FileHandler) createOneShotSession(c *gin.Context, requestID string) (string, int, error) {
+	repoURL := getStringParam(c, "url", "")
+	zipURL := getStringParam(c, "zip_url", "")
+
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	// 提问需要完整的文件内容和会话，不支持仅架构分析模式和 no_session
+	params.PromptOnly = false
+	params.NoSession = false
+	logger.Debug("一次性提问参数", params.logFields(requestID)...)
+
+	var result *models.ProcessResult
+	var status int
+	switch {
+	case repoURL != "":
+		result, status, err = h.fetchGitHubRepo(c, repoURL, &params)
+	case zipURL != "":
+		result, status, err = h.processZips(requestID, zipURL, nil, &params)
+	default:
+		var files []*multipart.FileHeader
+		if c.ContentType() == "multipart/form-data" {
+			files, status, err = h.uploadedZips(c, requestID)
+			if err != nil {
+				return "", status, err
+			}
+		}
+		if len(files) == 0 {
+			return "", http.StatusBadRequest, fmt.Errorf("请提供会话ID，或提供 url、zip_url 或上传 codeZip 进行一次性提问")
+		}
+		result, status, err = h.processZips(requestID, "", files, &params)
+	}
+	if err != nil {
+		return "", status, err
+	}
+
+	var projectAnalysis *models.ProjectAnalysis
+	if params.GeneratePrompt && h.config.GetDeepseekAPIKey() != "" {
+		projectAnalysis, err = h.generateProjectAnalysis(requestID, result)
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+	}
+
+	sessionID, err := h.createSession(c, requestID, params, result, projectAnalysis)
+	if err != nil {
+		return "", http.StatusServiceUnavailable, err
 	}
+	return sessionID, http.StatusOK, nil
 }
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"repo-prompt-web/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// idempotencyHeader 客户端提供的幂等键请求头
+const idempotencyHeader = "Idempotency-Key"
+
+// maxUnreadBodyBytes 处理器返回后最多再读取的请求体字节数，剩余更多时不读取，响应也不缓存
+const maxUnreadBodyBytes = 64 << 10
+
+var (
+	// errIdempotencyInFlight 表示相同幂等键的首个请求仍在处理中
+	errIdempotencyInFlight = errors.New("相同 Idempotency-Key 的请求正在处理中，请稍后重试")
+	// errIdempotencyStoreFull 表示缓存条目已达上限且均在处理中，无法登记新的幂等键
+	errIdempotencyStoreFull = errors.New("处理中的 Idempotency-Key 请求已达上限，请稍后重试")
+)
+
+// cachedResponse 按幂等键缓存的响应，pending 为 true 表示首个请求仍在处理中
+type cachedResponse struct {
+	status      int
+	contentType string
+	body        []byte
+	fingerprint string // 首个请求的查询参数和请求体的哈希，用于发现同一个键被用于不同的请求
+	pending     bool
+	createdAt   time.Time
+}
+
+// IdempotencyStore 按幂等键缓存响应，超过 expiresIn 的条目会被清理
+type IdempotencyStore struct {
+	entries      map[string]*cachedResponse
+	expiresIn    time.Duration
+	maxEntries   int   // 最大缓存条目数，超出时淘汰最早的已完成条目
+	maxBodyBytes int64 // 单个响应体的最大缓存字节数，超出时不缓存
+	mu           sync.Mutex
+}
+
+// NewIdempotencyStore 创建幂等响应缓存
+func NewIdempotencyStore(expiresIn time.Duration, maxEntries int, maxBodyBytes int64) *IdempotencyStore {
+	store := &IdempotencyStore{
+		entries:      make(map[string]*cachedResponse),
+		expiresIn:    expiresIn,
+		maxEntries:   maxEntries,
+		maxBodyBytes: maxBodyBytes,
+	}
+
+	// 启动清理过期条目的后台任务
+	go store.cleanExpiredEntries()
+
+	return store
+}
+
+// cleanExpiredEntries 定期清理过期条目
+func (s *IdempotencyStore) cleanExpiredEntries() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if !entry.pending && time.Since(entry.createdAt) > s.expiresIn {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// begin 查找幂等键对应的缓存响应。不存在时登记为处理中并返回 nil, nil；
+// 已有处理中的请求时返回 errIdempotencyInFlight，条目已达上限且没有可淘汰的已完成条目时返回 errIdempotencyStoreFull
+func (s *IdempotencyStore) begin(key string) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, exists := s.entries[key]; exists && (entry.pending || time.Since(entry.createdAt) <= s.expiresIn) {
+		if entry.pending {
+			return nil, errIdempotencyInFlight
+		}
+		return entry, nil
+	}
+
+	if s.maxEntries > 0 && len(s.entries) >= s.maxEntries && !s.evictOldest() {
+		return nil, errIdempotencyStoreFull
+	}
+	s.entries[key] = &cachedResponse{pending: true, createdAt: time.Now()}
+	return nil, nil
+}
+
+// evictOldest 淘汰最早创建的已完成条目，所有条目均在处理中时返回 false。调用方需持有锁
+func (s *IdempotencyStore) evictOldest() bool {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range s.entries {
+		if entry.pending {
+			continue
+		}
+		if oldestKey == "" || entry.createdAt.Before(oldest) {
+			oldestKey = key
+			oldest = entry.createdAt
+		}
+	}
+	if oldestKey == "" {
+		return false
+	}
+	delete(s.entries, oldestKey)
+	return true
+}
+
+// finish 保存请求的响应；status 为 5xx 或响应不可缓存时删除登记，允许客户端使用同一个键重试
+func (s *IdempotencyStore) finish(key string, status int, contentType string, body []byte, fingerprint string, cacheable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status >= http.StatusInternalServerError || !cacheable {
+		delete(s.entries, key)
+		return
+	}
+	s.entries[key] = &cachedResponse{
+		status:      status,
+		contentType: contentType,
+		body:        body,
+		fingerprint: fingerprint,
+		createdAt:   time.Now(),
+	}
+}
+
+// recordingWriter 在写出响应的同时记录响应体，响应体超过 limit 或以流式发送时放弃记录
+type recordingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool // 响应体超过 limit
+	streamed bool // 处理器调用了 Flush，响应以流式发送
+}
+
+// record 记录写出的数据，超过 limit 后丢弃已记录的内容
+func (w *recordingWriter) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if int64(w.body.Len()+len(data)) > w.limit {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *recordingWriter) Flush() {
+	w.streamed = true
+	w.ResponseWriter.Flush()
+}
+
+// cacheable 判断响应是否可以缓存：SSE、multipart 分节等流式响应和超过大小上限的响应不缓存
+func (w *recordingWriter) cacheable() bool {
+	contentType := w.Header().Get("Content-Type")
+	return !w.overflow && !w.streamed &&
+		!strings.HasPrefix(contentType, "text/event-stream") && !strings.HasPrefix(contentType, "multipart/")
+}
+
+// requestHasher 在处理器读取请求体的同时计算查询参数和请求体的哈希，不在内存中保留请求体
+type requestHasher struct {
+	hash     hash.Hash
+	body     io.Reader
+	stripper *boundaryStripper // multipart 请求体去掉 boundary 后再计算哈希，未使用时为 nil
+}
+
+// newRequestHasher 将 req.Body 替换为边读取边计算哈希的读取器，查询参数按名称排序后计入哈希。
+// 客户端每次发送 multipart 请求都会生成新的 boundary，因此 boundary 不计入哈希
+func newRequestHasher(req *http.Request) *requestHasher {
+	h := &requestHasher{hash: sha256.New()}
+	io.WriteString(h.hash, req.URL.Query().Encode())
+	h.hash.Write([]byte{0})
+	if req.Body == nil {
+		return h
+	}
+
+	var sink io.Writer = h.hash
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		h.stripper = &boundaryStripper{w: h.hash, boundary: []byte(params["boundary"])}
+		sink = h.stripper
+	}
+	h.body = io.TeeReader(req.Body, sink)
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{h.body, req.Body}
+	return h
+}
+
+// sum 读完整个请求体，返回十六进制的哈希，用于重放缓存的响应前与首个请求比较
+func (h *requestHasher) sum() string {
+	if h.body != nil {
+		_, _ = io.Copy(io.Discard, h.body)
+	}
+	return h.digest()
+}
+
+// sumConsumed 返回处理器返回后的哈希。处理器未读完请求体时（如提前以 400 拒绝）最多再读取 maxUnreadBodyBytes，
+// 剩余更多时不再读取并返回 false，避免为计算哈希接收整个上传
+func (h *requestHasher) sumConsumed() (string, bool) {
+	if h.body != nil {
+		n, _ := io.Copy(io.Discard, io.LimitReader(h.body, maxUnreadBodyBytes+1))
+		if n > maxUnreadBodyBytes {
+			return "", false
+		}
+	}
+	return h.digest(), true
+}
+
+// digest 返回已读取部分的十六进制哈希
+func (h *requestHasher) digest() string {
+	if h.stripper != nil {
+		h.stripper.flush()
+	}
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// boundaryStripper 去掉数据中所有的 boundary 后写入 w。末尾可能是 boundary 开头的部分暂存到下一次写入，
+// 全部写入后需调用 flush
+type boundaryStripper struct {
+	w        io.Writer
+	boundary []byte
+	pending  []byte
+}
+
+func (s *boundaryStripper) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	for {
+		i := bytes.Index(s.pending, s.boundary)
+		if i < 0 {
+			break
+		}
+		s.w.Write(s.pending[:i])
+		s.pending = s.pending[i+len(s.boundary):]
+	}
+	if keep := len(s.boundary) - 1; len(s.pending) > keep {
+		s.w.Write(s.pending[:len(s.pending)-keep])
+		s.pending = append([]byte(nil), s.pending[len(s.pending)-keep:]...)
+	}
+	return len(p), nil
+}
+
+// flush 写入暂存的剩余数据
+func (s *boundaryStripper) flush() {
+	s.w.Write(s.pending)
+	s.pending = nil
+}
+
+// Idempotency 返回幂等中间件：请求带有 Idempotency-Key 时缓存响应，相同方法、路径和键的重复请求直接返回缓存结果
+// （带 Idempotent-Replayed: true 响应头）；首个请求仍在处理中时返回 409，处理中的请求已达缓存上限时返回 503，
+// 同一个键用于查询参数或请求体不同的请求时返回 422
+func Idempotency(store *IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idempotencyKey := c.GetHeader(idempotencyHeader)
+		if idempotencyKey == "" {
+			c.Next()
+			return
+		}
+
+		requestID := c.GetString("RequestID")
+		key := c.Request.Method + " " + c.Request.URL.Path + " " + idempotencyKey
+		hasher := newRequestHasher(c.Request)
+		cached, err := store.begin(key)
+		if err != nil {
+			logger.Warn("无法登记幂等键",
+				zap.String("request_id", requestID),
+				zap.String("idempotency_key", idempotencyKey),
+				zap.Error(err))
+			status := http.StatusConflict
+			if errors.Is(err, errIdempotencyStoreFull) {
+				status = http.StatusServiceUnavailable
+			}
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if cached != nil {
+			if hasher.sum() != cached.fingerprint {
+				logger.Warn("幂等键已用于不同的请求",
+					zap.String("request_id", requestID),
+					zap.String("idempotency_key", idempotencyKey))
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key 已用于查询参数或请求体不同的请求，请使用新的键"})
+				return
+			}
+			logger.Info("返回幂等键缓存的响应",
+				zap.String("request_id", requestID),
+				zap.String("idempotency_key", idempotencyKey),
+				zap.Int("status", cached.status))
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(cached.status, cached.contentType, cached.body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer, limit: store.maxBodyBytes}
+		c.Writer = writer
+		defer func() {
+			// 处理器 panic 时不缓存，允许重试
+			if r := recover(); r != nil {
+				store.finish(key, http.StatusInternalServerError, "", nil, "", false)
+				panic(r)
+			}
+			fingerprint, consumed := hasher.sumConsumed()
+			cacheable := writer.cacheable() && consumed
+			if !cacheable {
+				logger.Info("流式、过大或未读完请求体的响应不缓存",
+					zap.String("request_id", requestID),
+					zap.String("idempotency_key", idempotencyKey))
+			}
+			store.finish(key, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes(), fingerprint, cacheable)
+		}()
+		c.Next()
+	}
+}
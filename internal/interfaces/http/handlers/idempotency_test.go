@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newIdempotencyRouter 返回挂载了幂等中间件的路由，handler 处理 POST /run
+func newIdempotencyRouter(store *IdempotencyStore, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/run", Idempotency(store), handler)
+	return router
+}
+
+// doIdempotent 发送带幂等键的 POST 请求
+func doIdempotent(router *gin.Engine, key, query, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/run"+query, strings.NewReader(body))
+	req.Header.Set(idempotencyHeader, key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// countingHandler 返回记录调用次数、回显请求体的处理器
+func countingHandler(calls *int32) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := atomic.AddInt32(calls, 1)
+		body, _ := c.GetRawData()
+		c.JSON(http.StatusOK, gin.H{"call": n, "body": string(body)})
+	}
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	var calls int32
+	router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 10, 1<<20), countingHandler(&calls))
+
+	first := doIdempotent(router, "k1", "?a=1&b=2", "payload")
+	// 查询参数顺序不同视为相同的请求
+	second := doIdempotent(router, "k1", "?b=2&a=1", "payload")
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("second response is not marked as replayed")
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("replayed response %d %q differs from original %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+
+	// 不同的幂等键正常处理
+	doIdempotent(router, "k2", "?a=1&b=2", "payload")
+	if calls != 2 {
+		t.Errorf("handler called %d times for a new key, want 2", calls)
+	}
+}
+
+func TestIdempotencyRejectsDifferentRequest(t *testing.T) {
+	var calls int32
+	router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 10, 1<<20), countingHandler(&calls))
+
+	doIdempotent(router, "k", "?a=1", "payload")
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "different body", query: "?a=1", body: "other"},
+		{name: "different query", query: "?a=2", body: "payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doIdempotent(router, "k", tt.query, tt.body)
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+// multipartRequest 构造以 boundary 分隔、包含一个上传文件的 multipart 请求
+func multipartRequest(key, boundary, content string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.SetBoundary(boundary)
+	part, _ := mw.CreateFormFile("codeZip", "project.zip")
+	part.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/run", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set(idempotencyHeader, key)
+	return req
+}
+
+func TestIdempotencyMultipartIgnoresBoundary(t *testing.T) {
+	var calls int32
+	router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 10, 1<<20), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		if _, err := c.FormFile("codeZip"); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, "done")
+	})
+
+	// 客户端重试时会生成新的 boundary，内容相同仍视为同一个请求
+	router.ServeHTTP(httptest.NewRecorder(), multipartRequest("k", "boundary-first", "zip data"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, multipartRequest("k", "boundary-second-longer", "zip data"))
+	if w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("multipart retry with a new boundary is not replayed: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, multipartRequest("k", "boundary-third", "other zip data"))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("multipart request with different content status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyInFlightConflict(t *testing.T) {
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 10, 1<<20), func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+		}
+		c.String(http.StatusOK, "done")
+	})
+
+	firstDone := make(chan *httptest.ResponseRecorder)
+	go func() {
+		firstDone <- doIdempotent(router, "k", "", "payload")
+	}()
+	<-started
+
+	if w := doIdempotent(router, "k", "", "payload"); w.Code != http.StatusConflict {
+		t.Errorf("in-flight duplicate status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	close(release)
+	if w := <-firstDone; w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doIdempotent(router, "k", "", "payload"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("request after completion is not replayed")
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyExpires(t *testing.T) {
+	var calls int32
+	router := newIdempotencyRouter(NewIdempotencyStore(50*time.Millisecond, 10, 1<<20), countingHandler(&calls))
+
+	doIdempotent(router, "k", "", "payload")
+	time.Sleep(100 * time.Millisecond)
+	w := doIdempotent(router, "k", "", "payload")
+
+	if calls != 2 {
+		t.Errorf("handler called %d times after expiry, want 2", calls)
+	}
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expired response was replayed")
+	}
+}
+
+func TestIdempotencySkipsUncacheableResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+	}{
+		{
+			name: "server error",
+			handler: func(c *gin.Context) {
+				c.String(http.StatusInternalServerError, "failed")
+			},
+		},
+		{
+			name: "body over limit",
+			handler: func(c *gin.Context) {
+				c.String(http.StatusOK, strings.Repeat("x", 64))
+			},
+		},
+		{
+			name: "server-sent events",
+			handler: func(c *gin.Context) {
+				c.SSEvent("message", "chunk")
+				c.Writer.Flush()
+			},
+		},
+		{
+			name: "multipart sections",
+			handler: func(c *gin.Context) {
+				c.Data(http.StatusOK, "multipart/mixed; boundary=x", []byte("--x--"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 10, 32), func(c *gin.Context) {
+				atomic.AddInt32(&calls, 1)
+				tt.handler(c)
+			})
+
+			doIdempotent(router, "k", "", "payload")
+			w := doIdempotent(router, "k", "", "payload")
+
+			if calls != 2 {
+				t.Errorf("handler called %d times, want 2", calls)
+			}
+			if w.Header().Get("Idempotent-Replayed") != "" {
+				t.Errorf("uncacheable response was replayed")
+			}
+		})
+	}
+}
+
+func TestIdempotencyRejectsWhenStoreIsFull(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 1, 1<<20), func(c *gin.Context) {
+		if c.GetHeader(idempotencyHeader) == "k1" {
+			close(started)
+			<-release
+		}
+		c.String(http.StatusOK, "done")
+	})
+
+	firstDone := make(chan struct{})
+	go func() {
+		doIdempotent(router, "k1", "", "payload")
+		close(firstDone)
+	}()
+	<-started
+
+	// 唯一的条目在处理中，无法淘汰
+	if w := doIdempotent(router, "k2", "", "payload"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status with a full store = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+	<-firstDone
+	// 首个请求完成后其条目可被淘汰
+	if w := doIdempotent(router, "k2", "", "payload"); w.Code != http.StatusOK {
+		t.Errorf("status after the entry completed = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// countingReader 记录被读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestIdempotencyDoesNotDrainUnreadBody(t *testing.T) {
+	var calls int32
+	router := newIdempotencyRouter(NewIdempotencyStore(time.Minute, 10, 1<<20), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(http.StatusBadRequest, "rejected")
+	})
+
+	send := func() (*httptest.ResponseRecorder, *countingReader) {
+		body := &countingReader{r: strings.NewReader(strings.Repeat("x", 4*maxUnreadBodyBytes))}
+		req := httptest.NewRequest(http.MethodPost, "/run", body)
+		req.Header.Set(idempotencyHeader, "k")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w, body
+	}
+
+	if _, body := send(); body.n > 2*maxUnreadBodyBytes {
+		t.Errorf("read %d bytes of a body the handler ignored", body.n)
+	}
+	// 未读完请求体的响应不缓存，重试时重新处理
+	if w, _ := send(); w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("response to a partially read request was replayed")
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+
+	// 请求体较小时读完剩余部分，响应照常缓存
+	doIdempotent(router, "small", "", "payload")
+	if w := doIdempotent(router, "small", "", "payload"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("response to a small unread body is not replayed")
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// validateKeyRequest 校验 API 密钥的 JSON 请求体
+type validateKeyRequest struct {
+	Provider string `json:"provider"`
+	ApiKey   string `json:"api_key"`
+}
+
+// HandleValidateKey 使用请求中的 API 密钥调用提供方的模型列表接口，返回密钥是否有效及可访问的模型。
+// 密钥只用于本次请求，不会保存，也不会写入日志
+func (h *FileHandler) HandleValidateKey(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+
+	var request validateKeyRequest
+	if c.ContentType() == "application/json" {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数", "details": err.Error()})
+			return
+		}
+	} else {
+		request.Provider = c.PostForm("provider")
+		request.ApiKey = c.PostForm("api_key")
+	}
+
+	provider := strings.ToLower(strings.TrimSpace(request.Provider))
+	apiKey := strings.TrimSpace(request.ApiKey)
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_key 不能为空"})
+		return
+	}
+
+	// 问答模型的密钥通过当前配置的提供方（ai.provider）校验
+	aiProvider, _ := h.aiService.ModelInfo()
+	var modelNames []string
+	var err error
+	switch provider {
+	case "deepseek":
+		modelNames, err = h.promptService.ListDeepSeekModels(apiKey)
+	case aiProvider:
+		modelNames, err = h.aiService.ListModels(apiKey)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider 只支持 deepseek 或 " + aiProvider})
+		return
+	}
+
+	if errors.Is(err, models.ErrInvalidAPIKey) {
+		logger.Info("API 密钥校验未通过",
+			zap.String("request_id", requestID),
+			zap.String("provider", provider))
+		c.JSON(http.StatusOK, gin.H{
+			"provider": provider,
+			"valid":    false,
+			"error":    err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logger.Warn("API 密钥校验失败",
+			zap.String("request_id", requestID),
+			zap.String("provider", provider),
+			zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "无法连接 AI 服务提供方: " + err.Error()})
+		return
+	}
+
+	logger.Info("API 密钥校验通过",
+		zap.String("request_id", requestID),
+		zap.String("provider", provider),
+		zap.Int("model_count", len(modelNames)))
+	c.JSON(http.StatusOK, gin.H{
+		"provider": provider,
+		"valid":    true,
+		"models":   modelNames,
+	})
+}
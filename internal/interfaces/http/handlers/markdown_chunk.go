@@ -0,0 +1,113 @@
+package handlers
+
+import "strings"
+
+// maxLinkHoldBytes 等待链接语法闭合时最多暂存的字节数，超过后按普通文本发送，避免未闭合的 "[" 阻塞输出
+const maxLinkHoldBytes = 256
+
+// markdownChunker 重新切分流式回答，暂不发送可能被截断的 Markdown 语法（代码块围栏行、结尾的反引号、
+// 未闭合的链接），保证每次发送的内容都可以直接增量渲染。拼接所有输出与原始文本完全相同
+type markdownChunker struct {
+	pending     string // 尚未发送的文本
+	inFence     bool   // 已发送的文本是否位于未闭合的代码块中
+	atLineStart bool   // 已发送的文本是否以换行结束（或尚未发送任何内容）
+}
+
+// newMarkdownChunker 创建 Markdown 分块器
+func newMarkdownChunker() *markdownChunker {
+	return &markdownChunker{atLineStart: true}
+}
+
+// Push 追加模型返回的文本，返回可以安全发送的部分（可能为空）
+func (m *markdownChunker) Push(text string) string {
+	m.pending += text
+	return m.emit(m.safeLength())
+}
+
+// Flush 返回剩余的全部文本，在回答结束或出错时调用
+func (m *markdownChunker) Flush() string {
+	return m.emit(len(m.pending))
+}
+
+// emit 取出 pending 的前 n 个字节，并更新代码块和行首状态
+func (m *markdownChunker) emit(n int) string {
+	out := m.pending[:n]
+	m.pending = m.pending[n:]
+	if out == "" {
+		return ""
+	}
+
+	m.inFence = m.fenceStateAfter(out)
+	m.atLineStart = strings.HasSuffix(out, "\n")
+	return out
+}
+
+// fenceStateAfter 返回在已发送内容之后追加 text 的完整行时是否位于代码块中
+func (m *markdownChunker) fenceStateAfter(text string) bool {
+	inFence := m.inFence
+	lines := strings.Split(text, "\n")
+	for i, line := range lines[:len(lines)-1] {
+		// 第一段只有在已发送内容以换行结束时才是完整的一行
+		if i == 0 && !m.atLineStart {
+			continue
+		}
+		if isFenceLine(line) {
+			inFence = !inFence
+		}
+	}
+	return inFence
+}
+
+// safeLength 返回 pending 中可以安全发送的前缀长度
+func (m *markdownChunker) safeLength() int {
+	p := m.pending
+	lineStart := strings.LastIndex(p, "\n") + 1
+	line := p[lineStart:]
+	if line == "" {
+		return len(p)
+	}
+
+	// 未结束的一行可能是代码块围栏（如 "``" 后面还有 "`go"），等到换行再发送
+	if lineStart > 0 || m.atLineStart {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "`") || strings.HasPrefix(trimmed, "~") {
+			return lineStart
+		}
+	}
+
+	// 代码块中的方括号和反引号都是普通文本
+	if m.fenceStateAfter(p[:lineStart]) {
+		return len(p)
+	}
+
+	// 结尾的反引号可能是行内代码或更长分隔符的一部分
+	cut := len(line)
+	for cut > 0 && line[cut-1] == '`' {
+		cut--
+	}
+
+	// 未闭合的链接或图片：[text、[text] 或 [text](url
+	if open := strings.LastIndex(line[:cut], "["); open >= 0 && cut-open <= maxLinkHoldBytes {
+		rest := line[open:cut]
+		closeIdx := strings.Index(rest, "]")
+		unclosed := closeIdx < 0 ||
+			closeIdx == len(rest)-1 ||
+			(rest[closeIdx+1] == '(' && !strings.Contains(rest[closeIdx+1:], ")"))
+		if unclosed {
+			cut = open
+			if cut > 0 && line[cut-1] == '!' {
+				cut--
+			}
+		}
+	}
+	return lineStart + cut
+}
+
+// isFenceLine 判断一行是否为代码块围栏（最多 3 个空格缩进后接 ``` 或 ~~~）
+func isFenceLine(line string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
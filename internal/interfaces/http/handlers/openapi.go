@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec 手写的 OpenAPI 3 文档，修改处理器参数或响应结构时需同步更新 openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// HandleOpenAPI 返回 OpenAPI 文档
+func HandleOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPISpec)
+}
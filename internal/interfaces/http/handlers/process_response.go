@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/stats"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// processParams 代码处理类请求（ZIP 上传、GitHub 仓库）的公共参数
+type processParams struct {
+	Format         string                // 输出格式: text 或 json
+	GeneratePrompt bool                  // 是否生成项目架构分析
+	PromptOnly     bool                  // 是否只返回提示词而不包含文件内容
+	IncludeContent bool                  // 是否包含文件内容（与 PromptOnly 互斥）
+	Output         models.OutputOptions  // 文本输出格式
+	Sections       bool                  // 文本格式是否以 multipart/mixed 分节返回，文件树和文件内容各为一节
+	Options        models.ProcessOptions // 文件处理选项
+	IncludeTree    bool                  // prompt_only 的 JSON 响应中是否包含文件树
+	PreviewBytes   int                   // JSON 响应中每个文件内容的最大字节数，0 表示返回完整内容
+	Intro          bool                  // 是否在响应中附带 AI 生成的项目开场介绍
+	NoSession      bool                  // 不创建会话，响应中不返回 session_id，处理结果不在内存中保留
+	MaxUploadSize  int64                 // 本次请求的上传和远程 ZIP 下载大小上限（字节）
+	Source         string                // 代码来源（仓库 URL 或上传文件名），由处理器设置，用于审计日志
+}
+
+// parseProcessParams 从表单和URL查询参数中获取公共参数，互斥参数同时开启时返回错误
+func (h *FileHandler) parseProcessParams(c *gin.Context) (processParams, error) {
+	format := c.DefaultQuery("format", h.config.GetDefaultFormat())
+	if formatForm := c.PostForm("format"); formatForm != "" {
+		format = formatForm
+	}
+
+	promptOnly := getBoolParam(c, "prompt_only")
+	includeContent := getBoolParam(c, "include_content")
+	if promptOnly && includeContent {
+		return processParams{}, fmt.Errorf("prompt_only 与 include_content 不能同时为 true，需要架构分析和文件内容时请使用 generate_prompt=true&include_content=true")
+	}
+
+	lineEndings := getStringParam(c, "line_endings", models.LineEndingsKeep)
+	switch lineEndings {
+	case models.LineEndingsKeep, models.LineEndingsLF, models.LineEndingsCRLF:
+	default:
+		return processParams{}, fmt.Errorf("line_endings 只支持 keep、lf 或 crlf")
+	}
+
+	// 开场介绍由问答模型基于会话生成，无会话时无法提供
+	noSession := getBoolParam(c, "no_session")
+	intro := getBoolParam(c, "intro")
+	if noSession && intro {
+		return processParams{}, fmt.Errorf("no_session 与 intro 不能同时为 true")
+	}
+
+	// base64=auto 时逐个文件决定：合法 UTF-8 按原文返回，其余以 base64 返回
+	base64Mode := getStringParam(c, "base64", strconv.FormatBool(h.config.IsDefaultBase64()))
+
+	return processParams{
+		Format:         format,
+		GeneratePrompt: getBoolParamDefault(c, "generate_prompt", h.config.IsDefaultGeneratePrompt()),
+		PromptOnly:     promptOnly,
+		IncludeContent: includeContent,
+		IncludeTree:    getBoolParamDefault(c, "include_tree", true),
+		PreviewBytes:   getIntParam(c, "preview_bytes", 0),
+		Intro:          intro,
+		NoSession:      noSession,
+		MaxUploadSize:  uploadLimit(c, h.config.GetMaxUploadSize()),
+		Output:         parseOutputOptions(c, h.config),
+		Sections:       getBoolParam(c, "sections"),
+		Options: models.ProcessOptions{
+			UseBase64:           base64Mode == "true",
+			AutoBase64:          base64Mode == "auto",
+			SkipTests:           getBoolParam(c, "skip_tests"),
+			SkipGenerated:       getBoolParam(c, "skip_generated"),
+			FailOnError:         getBoolParam(c, "fail_on_error"),
+			IncludeBinary:       getBoolParam(c, "include_binary"),
+			IncludeSymlinks:     getBoolParam(c, "include_symlinks"),
+			FollowSubmodules:    getBoolParamDefault(c, "follow_submodules", h.config.IsGithubFollowSubmodules()),
+			IncludeLastModified: getBoolParam(c, "include_last_modified"),
+			IgnoreFiles:         parseIgnoreFiles(c, h.config.GetIgnoreFiles()),
+			ExcludeDirs:         parseExcludeDirs(c),
+			ExtraTextExtensions: parseExtraTextExtensions(c),
+			LineEndings:         lineEndings,
+			MaxFileSize:         int64(getIntParam(c, "max_file_size", 0)),
+		},
+	}, nil
+}
+
+// parseOutputOptions 读取文本输出格式参数，未提供时使用配置中的默认值
+func parseOutputOptions(c *gin.Context, cfg *config.Config) models.OutputOptions {
+	return models.OutputOptions{
+		TreeMaxDepth:  getIntParam(c, "tree_max_depth", cfg.GetTreeMaxDepth()),
+		TreeHeader:    getStringParam(c, "tree_header", cfg.GetOutputTreeHeader()),
+		ContentHeader: getStringParam(c, "content_header", cfg.GetOutputContentHeader()),
+		FileHeader:    getStringParam(c, "file_header", cfg.GetOutputFileHeader()),
+		FileFooter:    getStringParam(c, "file_footer", cfg.GetOutputFileFooter()),
+		Dedupe:        getBoolParam(c, "dedupe"),
+	}
+}
+
+// getStringParam 从查询参数或表单中读取字符串参数，缺失时返回默认值
+func getStringParam(c *gin.Context, name, defaultValue string) string {
+	if value, ok := c.GetQuery(name); ok {
+		return value
+	}
+	if value, ok := c.GetPostForm(name); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// parseIgnoreFiles 解析逗号分隔的 ignore_files 参数，未提供时返回 defaults
+func parseIgnoreFiles(c *gin.Context, defaults []string) []string {
+	if names := getListParam(c, "ignore_files"); len(names) > 0 {
+		return names
+	}
+	return defaults
+}
+
+// getListParam 从查询参数和表单中读取列表参数，支持重复参数和逗号分隔
+func getListParam(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range append(c.QueryArray(name), c.PostFormArray(name)...) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parseExcludeDirs 读取可重复的 exclude_dir 参数，统一为相对项目根目录、以 "/" 结尾的目录前缀
+func parseExcludeDirs(c *gin.Context) []string {
+	var dirs []string
+	for _, dir := range getListParam(c, "exclude_dir") {
+		dir = strings.Trim(strings.TrimPrefix(filepath.ToSlash(dir), "./"), "/")
+		if dir == "" || dir == "." {
+			continue
+		}
+		dirs = append(dirs, dir+"/")
+	}
+	return dirs
+}
+
+// parseExtraTextExtensions 读取可重复的 extra_text_ext 参数，统一为小写、以 "." 开头的扩展名
+func parseExtraTextExtensions(c *gin.Context) []string {
+	var exts []string
+	for _, ext := range getListParam(c, "extra_text_ext") {
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if ext == "" {
+			continue
+		}
+		exts = append(exts, "."+ext)
+	}
+	return exts
+}
+
+// getBoolParam 从查询参数或表单中读取布尔参数，任一处为 "true" 即为真
+func getBoolParam(c *gin.Context, name string) bool {
+	return c.Query(name) == "true" || c.PostForm(name) == "true"
+}
+
+// getBoolParamDefault 从查询参数或表单中读取布尔参数，未提供时返回默认值
+func getBoolParamDefault(c *gin.Context, name string, defaultValue bool) bool {
+	return getStringParam(c, name, strconv.FormatBool(defaultValue)) == "true"
+}
+
+// getIntParam 从查询参数或表单中读取非负整数参数，缺失或无效时返回默认值
+func getIntParam(c *gin.Context, name string, defaultValue int) int {
+	value := c.Query(name)
+	if value == "" {
+		value = c.PostForm(name)
+	}
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return defaultValue
+	}
+	return n
+}
+
+// uploadLimit 返回本次请求的上传大小上限：参数 max_upload_size（字节）只能调小配置值 configured
+func uploadLimit(c *gin.Context, configured int64) int64 {
+	if n := int64(getIntParam(c, "max_upload_size", 0)); n > 0 && n < configured {
+		return n
+	}
+	return configured
+}
+
+// isBodyTooLarge 判断错误是否由请求体超过 http.MaxBytesReader 限制引起
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// safeJoin 将压缩包内的相对路径拼接到 baseDir 下，防止 Zip Slip：
+// 清理后的路径不在 baseDir 内（如包含 "../" 或为绝对路径）时返回 false
+func safeJoin(baseDir, path string) (string, bool) {
+	fullPath := filepath.Join(baseDir, path)
+	rel, err := filepath.Rel(baseDir, fullPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return fullPath, true
+}
+
+// logFields 返回用于日志记录的参数字段
+func (p processParams) logFields(requestID string) []zap.Field {
+	return []zap.Field{
+		zap.String("request_id", requestID),
+		zap.String("format", p.Format),
+		zap.Bool("use_base64", p.Options.UseBase64),
+		zap.Bool("auto_base64", p.Options.AutoBase64),
+		zap.Bool("generate_prompt", p.GeneratePrompt),
+		zap.Bool("prompt_only", p.PromptOnly),
+		zap.Bool("include_content", p.IncludeContent),
+		zap.Int("preview_bytes", p.PreviewBytes),
+		zap.Bool("intro", p.Intro),
+		zap.Bool("no_session", p.NoSession),
+		zap.Bool("skip_tests", p.Options.SkipTests),
+		zap.Bool("skip_generated", p.Options.SkipGenerated),
+		zap.Bool("fail_on_error", p.Options.FailOnError),
+		zap.Bool("include_binary", p.Options.IncludeBinary),
+		zap.Bool("include_symlinks", p.Options.IncludeSymlinks),
+		zap.Bool("follow_submodules", p.Options.FollowSubmodules),
+		zap.Bool("include_last_modified", p.Options.IncludeLastModified),
+		zap.Strings("ignore_files", p.Options.IgnoreFiles),
+		zap.Strings("exclude_dirs", p.Options.ExcludeDirs),
+		zap.Strings("extra_text_ext", p.Options.ExtraTextExtensions),
+		zap.String("line_endings", p.Options.LineEndings),
+		zap.Int64("max_file_size", p.Options.MaxFileSize),
+		zap.Int64("max_upload_size", p.MaxUploadSize),
+	}
+}
+
+// createSession 保存处理结果为新会话并记录审计日志，会话数已达上限时返回 models.ErrTooManySessions
+func (h *FileHandler) createSession(c *gin.Context, requestID string, params processParams, result *models.ProcessResult, projectAnalysis *models.ProjectAnalysis) (string, error) {
+	sessionID, err := h.sessions.Put(result, projectAnalysis)
+	if err != nil {
+		logger.Warn("创建会话失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		return "", err
+	}
+	if params.Output.Dedupe {
+		h.sessions.SetDedupe(sessionID, true)
+	}
+	stats.SessionCreated()
+	logger.Debug("已创建会话",
+		zap.String("request_id", requestID),
+		zap.String("session_id", sessionID))
+	recordProcessed(c, requestID, sessionID, params, result)
+	return sessionID, nil
+}
+
+// recordProcessed 统计处理的字节数并记录审计日志，未创建会话时 sessionID 为空
+func recordProcessed(c *gin.Context, requestID, sessionID string, params processParams, result *models.ProcessResult) {
+	var totalBytes int64
+	for _, content := range result.FileContents {
+		totalBytes += content.Size
+	}
+	stats.AddBytesProcessed(totalBytes)
+	logger.Audit("code_processed",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()),
+		zap.String("session_id", sessionID),
+		zap.String("source", params.Source),
+		zap.Int("file_count", len(result.FileContents)))
+}
+
+// respondWithResult 保存会话并根据参数和格式返回处理结果
+func (h *FileHandler) respondWithResult(c *gin.Context, requestID string, params processParams, result *models.ProcessResult, projectAnalysis *models.ProjectAnalysis) {
+	// 根据参数和格式决定返回方式
+	logger.Info("返回响应",
+		zap.String("request_id", requestID),
+		zap.String("format", params.Format),
+		zap.Bool("prompt_only", params.PromptOnly),
+		zap.Bool("generate_prompt", params.GeneratePrompt),
+		zap.Bool("has_prompt", projectAnalysis != nil),
+		zap.Int("warnings", len(result.Warnings)),
+		zap.Bool("partial", result.Partial))
+
+	// 保存会话数据以便后续提问
+	saved, err := h.saveResult(c, requestID, params, result, projectAnalysis)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	if params.Format == "paths" || params.Format == "json" {
+		c.JSON(http.StatusOK, resultResponse(params, saved, result, projectAnalysis))
+		return
+	}
+
+	if params.Sections {
+		sections := resultSections(saved, result)
+		// 返回哪些部分与下面的文本格式相同
+		includeFiles := true
+		if projectAnalysis != nil && (params.PromptOnly || params.GeneratePrompt) {
+			sections = append(sections, textSection{name: "analysis", text: projectAnalysis.PromptSuggestions[0]})
+			includeFiles = !params.PromptOnly && params.IncludeContent
+		}
+		h.streamSectionsOutput(c, requestID, sections, result, params.Output, includeFiles)
+		return
+	}
+
+	var sessionHeader string
+	if saved.sessionID != "" {
+		sessionHeader = fmt.Sprintf("# 会话ID\n%s\n\n", saved.sessionID)
+	}
+	if saved.intro != "" {
+		sessionHeader += fmt.Sprintf("# 项目介绍\n\n%s\n\n", saved.intro)
+	}
+	if len(result.Changes) > 0 {
+		sessionHeader += formatChanges(result.Changes)
+	}
+
+	if params.PromptOnly && projectAnalysis != nil {
+		c.String(http.StatusOK, fmt.Sprintf("%s# 项目架构分析\n\n%s", sessionHeader, projectAnalysis.PromptSuggestions[0]))
+	} else if params.GeneratePrompt && projectAnalysis != nil {
+		output := fmt.Sprintf("%s# 项目架构分析\n\n%s\n\n", sessionHeader, projectAnalysis.PromptSuggestions[0])
+		if params.IncludeContent {
+			h.streamTextOutput(c, requestID, output+"# 文件内容\n\n", result, params.Output)
+			return
+		}
+		c.String(http.StatusOK, output)
+	} else {
+		header := sessionHeader + "# 文件内容\n\n"
+		h.streamTextOutput(c, requestID, header, result, params.Output)
+	}
+}
+
+// savedResult 处理结果保存为会话后的信息
+type savedResult struct {
+	sessionID  string // params.NoSession 时为空
+	intro      string // AI 生成的项目开场介绍，仅 params.Intro 时生成
+	introError string
+}
+
+// saveResult 将处理结果保存为会话，并按需生成项目开场介绍；params.NoSession 时只记录审计日志
+func (h *FileHandler) saveResult(c *gin.Context, requestID string, params processParams, result *models.ProcessResult, projectAnalysis *models.ProjectAnalysis) (savedResult, error) {
+	if params.NoSession {
+		recordProcessed(c, requestID, "", params, result)
+		return savedResult{}, nil
+	}
+
+	sessionID, err := h.createSession(c, requestID, params, result, projectAnalysis)
+	if err != nil {
+		return savedResult{}, err
+	}
+	saved := savedResult{sessionID: sessionID}
+
+	// 生成开场介绍失败不影响处理结果，错误信息随响应返回
+	if params.Intro {
+		saved.intro, err = h.aiService.IntroduceProject(result, projectAnalysis, sessionID, requestID, params.Output.Dedupe)
+		if err != nil {
+			logger.Warn("生成项目开场介绍失败",
+				zap.String("request_id", requestID),
+				zap.String("session_id", sessionID),
+				zap.Error(err))
+			saved.introError = err.Error()
+		}
+	}
+	return saved, nil
+}
+
+// resultResponse 构造 paths 格式或 JSON 格式的响应体，其他格式均按 JSON 格式构造
+func resultResponse(params processParams, saved savedResult, result *models.ProcessResult, projectAnalysis *models.ProjectAnalysis) gin.H {
+	// paths 格式只返回排序后的文件路径列表，便于程序处理，无需解析文本目录树
+	if params.Format == "paths" {
+		paths := []string{}
+		if result.FileTree != nil {
+			paths = append(paths, result.FileTree.FilePaths()...)
+		}
+		response := gin.H{
+			"success":      true,
+			"content_hash": result.ContentHash,
+			"paths":        paths,
+		}
+		if saved.sessionID != "" {
+			response["session_id"] = saved.sessionID
+		}
+		if len(result.Warnings) > 0 {
+			response["warnings"] = result.Warnings
+		}
+		if len(result.Changes) > 0 {
+			response["changes"] = result.Changes
+		}
+		if result.Partial {
+			response["partial"] = true
+		}
+		if result.FileTree != nil && result.FileTree.Truncated {
+			response["tree_truncated"] = true
+		}
+		if projectAnalysis != nil {
+			response["project_analysis"] = projectAnalysis
+		}
+		if saved.intro != "" {
+			response["intro"] = saved.intro
+		}
+		if saved.introError != "" {
+			response["intro_error"] = saved.introError
+		}
+		return response
+	}
+
+	// 预览模式下只截断响应中的内容，会话中仍保存完整文件
+	result = result.WithPreview(params.PreviewBytes)
+	response := gin.H{
+		"success": true,
+		// 客户端可据此判断项目是否有变化，无需重复上传
+		"content_hash": result.ContentHash,
+	}
+	if saved.sessionID != "" {
+		response["session_id"] = saved.sessionID
+	}
+	if len(result.Warnings) > 0 {
+		response["warnings"] = result.Warnings
+	}
+	if len(result.Changes) > 0 {
+		response["changes"] = result.Changes
+	}
+	if result.Partial {
+		response["partial"] = true
+	}
+	if result.FileTree != nil && result.FileTree.Truncated {
+		response["tree_truncated"] = true
+	}
+	if saved.intro != "" {
+		response["intro"] = saved.intro
+	}
+	if saved.introError != "" {
+		response["intro_error"] = saved.introError
+	}
+
+	if params.PromptOnly && projectAnalysis != nil {
+		// 只返回提示词，文件树已在处理结果中，默认一并返回便于展示项目结构
+		response["project_analysis"] = projectAnalysis
+		if params.IncludeTree {
+			response["file_tree"] = result.FileTree
+		}
+	} else if params.GeneratePrompt && projectAnalysis != nil {
+		// 返回提示词和内容
+		response["project_analysis"] = projectAnalysis
+
+		// 如果需要包含文件内容
+		if params.IncludeContent {
+			response["file_tree"] = result.FileTree
+			response["file_contents"] = result.FileContents
+		} else {
+			response["result"] = result
+		}
+	} else {
+		// 正常响应，不包含提示词
+		response["result"] = result
+	}
+	return response
+}
+
+// formatChanges 以文本列出变更文件，每行为状态、路径和增删行数
+func formatChanges(changes []models.FileChange) string {
+	var b strings.Builder
+	b.WriteString("# 变更文件\n\n")
+	for _, change := range changes {
+		path := change.Path
+		if change.PreviousPath != "" {
+			path = change.PreviousPath + " -> " + change.Path
+		}
+		fmt.Fprintf(&b, "- %s %s (+%d -%d)\n", change.Status, path, change.Additions, change.Deletions)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// textSection multipart/mixed 响应中的一节，name 写入该节的 Content-Disposition
+type textSection struct {
+	name string
+	text string
+}
+
+// resultSections 返回分节输出中位于文件树之前的会话ID、开场介绍和变更文件，未创建会话时不含 session 一节
+func resultSections(saved savedResult, result *models.ProcessResult) []textSection {
+	var sections []textSection
+	if saved.sessionID != "" {
+		sections = append(sections, textSection{name: "session", text: saved.sessionID})
+	}
+	if saved.intro != "" {
+		sections = append(sections, textSection{name: "intro", text: saved.intro})
+	}
+	if len(result.Changes) > 0 {
+		sections = append(sections, textSection{name: "changes", text: formatChanges(result.Changes)})
+	}
+	return sections
+}
+
+// streamSectionsOutput 以 multipart/mixed 返回文本输出：先依次写入 sections，includeFiles 为 true 时
+// 再写入 tree（文件树）和 content（文件内容）两节。每节的 Content-Disposition 为 inline; name="<名称>"，
+// 分节已有明确边界，因此不写入 tree_header 和 content_header
+func (h *FileHandler) streamSectionsOutput(c *gin.Context, requestID string, sections []textSection, result *models.ProcessResult, opts models.OutputOptions, includeFiles bool) {
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	c.Status(http.StatusOK)
+
+	writePart := func(name string, write func(io.Writer) error) error {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {"text/plain; charset=utf-8"},
+			"Content-Disposition": {fmt.Sprintf("inline; name=%q", name)},
+		})
+		if err != nil {
+			return err
+		}
+		return write(part)
+	}
+
+	var err error
+	for _, section := range sections {
+		text := section.text
+		if err = writePart(section.name, func(w io.Writer) error {
+			_, err := io.WriteString(w, text)
+			return err
+		}); err != nil {
+			break
+		}
+	}
+	if err == nil && includeFiles {
+		err = writePart("tree", func(w io.Writer) error {
+			return h.fileService.WriteTree(w, result, opts)
+		})
+		if err == nil {
+			err = writePart("content", func(w io.Writer) error {
+				return h.fileService.WriteContents(w, result, opts)
+			})
+		}
+	}
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		// 响应头已发送，只能记录日志
+		logger.Warn("写入分节响应失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+	}
+}
+
+// streamTextOutput 先写入 header，再将文件树和文件内容逐个写入响应，
+// 避免大型仓库在内存中拼接完整文本
+func (h *FileHandler) streamTextOutput(c *gin.Context, requestID, header string, result *models.ProcessResult, opts models.OutputOptions) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	_, err := io.WriteString(c.Writer, header)
+	if err == nil {
+		err = h.fileService.WriteOutput(c.Writer, result, opts)
+	}
+	if err != nil {
+		// 响应头已发送，只能记录日志
+		logger.Warn("写入文本响应失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+	}
+}
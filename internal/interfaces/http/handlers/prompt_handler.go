@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,8 +10,10 @@ import (
 	"repo-prompt-web/internal/application"
 	"repo-prompt-web/internal/domain/models"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/logger"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 // PromptHandler 提示词 HTTP 处理器
@@ -51,7 +54,14 @@ func (h *PromptHandler) HandleGeneratePrompt(c *gin.Context) {
 	// 生成提示词
 	response, err := h.promptService.GeneratePromptWithApiKey(request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成提示词失败", "details": err.Error()})
+		if respondIfAIBusy(c, h.config, err) {
+			return
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrEmptyAnalysis) {
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{"error": "生成提示词失败", "details": err.Error()})
 		return
 	}
 
@@ -60,7 +70,25 @@ func (h *PromptHandler) HandleGeneratePrompt(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	body := gin.H{
+		"success":             true,
+		"prompt_suggestions":  response.Prompt.PromptSuggestions,
+		"directory_structure": response.Prompt.DirectoryStructure,
+		"documents":           response.Prompt.Documents,
+		"language_stats":      response.Prompt.LanguageStats,
+		"primary_language":    response.Prompt.PrimaryLanguage,
+		"frameworks":          response.Prompt.Frameworks,
+		"generated_at":        models.FormatTimestamp(response.Prompt.GeneratedAt),
+		"provider":            response.Prompt.Provider,
+		"model":               response.Prompt.Model,
+	}
+
+	// 仅在显式请求时返回 DeepSeek 原始响应，可通过请求体的 debug 字段或 debug=true 查询参数开启
+	if request.Debug || getBoolParam(c, "debug") {
+		body["debug"] = response.Prompt.Debug
+	}
+
+	c.JSON(http.StatusOK, body)
 }
 
 // HandlePreProcess 处理 ZIP 文件预处理并生成提示词
@@ -78,13 +106,17 @@ func (h *PromptHandler) HandlePreProcess(c *gin.Context) {
 
 	// 获取 ZIP 文件
 	file, err := c.FormFile("codeZip")
+	if isBodyTooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "请求体超过大小限制"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传 ZIP 文件"})
 		return
 	}
 
 	// 检查文件大小
-	if file.Size > h.config.GetMaxUploadSize() {
+	if file.Size > uploadLimit(c, h.config.GetMaxUploadSize()) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小超过限制"})
 		return
 	}
@@ -98,19 +130,39 @@ func (h *PromptHandler) HandlePreProcess(c *gin.Context) {
 	defer os.RemoveAll(tempDir)
 
 	// 保存上传的文件到临时目录
-	tempFile := filepath.Join(tempDir, file.Filename)
+	tempFile, ok := safeJoin(tempDir, filepath.Base(file.Filename))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名"})
+		return
+	}
 	if err := c.SaveUploadedFile(file, tempFile); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存文件失败: %v", err)})
 		return
 	}
 
 	// 处理 ZIP 文件内容
-	result, err := h.fileService.ProcessZipFile(file, false)
+	result, err := h.fileService.ProcessZipFile(file, models.ProcessOptions{
+		SkipTests:           getBoolParam(c, "skip_tests"),
+		SkipGenerated:       getBoolParam(c, "skip_generated"),
+		IgnoreFiles:         parseIgnoreFiles(c, h.config.GetIgnoreFiles()),
+		ExcludeDirs:         parseExcludeDirs(c),
+		ExtraTextExtensions: parseExtraTextExtensions(c),
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("处理 ZIP 文件失败: %v", err)})
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrUnsupportedArchive) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": fmt.Sprintf("处理 ZIP 文件失败: %v", err)})
 		return
 	}
 
+	logger.Audit("prompt_preprocessed",
+		zap.String("request_id", c.GetString("RequestID")),
+		zap.String("client_ip", c.ClientIP()),
+		zap.String("source", file.Filename),
+		zap.Int("file_count", len(result.FileContents)))
+
 	// 将处理结果写入临时文件夹
 	extractDir := filepath.Join(tempDir, "extracted")
 	if err := os.MkdirAll(extractDir, 0755); err != nil {
@@ -120,7 +172,11 @@ func (h *PromptHandler) HandlePreProcess(c *gin.Context) {
 
 	// 写入文件内容
 	for path, content := range result.FileContents {
-		fullPath := filepath.Join(extractDir, path)
+		// 跳过超出解压目录的路径（如 "../../etc/passwd"），防止 Zip Slip
+		fullPath, ok := safeJoin(extractDir, path)
+		if !ok {
+			continue
+		}
 		dirPath := filepath.Dir(fullPath)
 
 		// 创建目录
@@ -137,11 +193,19 @@ func (h *PromptHandler) HandlePreProcess(c *gin.Context) {
 	// 生成提示词响应格式
 	format := c.DefaultQuery("format", "json")
 	includeContent := c.DefaultQuery("include_content", "false") == "true"
+	outputOptions := parseOutputOptions(c, h.config)
 
 	// 使用临时目录生成项目架构分析
 	contextPrompt, err := h.promptService.GenerateContextPrompt(extractDir)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("生成提示词失败: %v", err)})
+		if respondIfAIBusy(c, h.config, err) {
+			return
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrEmptyAnalysis) {
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{"error": fmt.Sprintf("生成提示词失败: %v", err)})
 		return
 	}
 
@@ -150,7 +214,10 @@ func (h *PromptHandler) HandlePreProcess(c *gin.Context) {
 		response := gin.H{
 			"success":            true,
 			"prompt_suggestions": contextPrompt.PromptSuggestions,
-			"generated_at":       contextPrompt.GeneratedAt,
+			"language_stats":     contextPrompt.LanguageStats,
+			"primary_language":   contextPrompt.PrimaryLanguage,
+			"frameworks":         contextPrompt.Frameworks,
+			"generated_at":       models.FormatTimestamp(contextPrompt.GeneratedAt),
 		}
 
 		// 如果需要包含文件内容
@@ -172,7 +239,7 @@ func (h *PromptHandler) HandlePreProcess(c *gin.Context) {
 		if includeContent {
 			output += fmt.Sprintf("# 目录结构\n\n%s\n\n# 文件内容\n\n%s",
 				contextPrompt.DirectoryStructure,
-				h.fileService.FormatOutput(result))
+				h.fileService.FormatOutput(result, outputOptions))
 		}
 
 		c.String(http.StatusOK, output)
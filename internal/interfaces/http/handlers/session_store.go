@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/logger"
+	"repo-prompt-web/pkg/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SessionData 存储会话数据
+type SessionData struct {
+	Result          *types.ProcessResult
+	ProjectAnalysis *models.ProjectAnalysis
+	CreatedAt       time.Time
+	LastAccess      time.Time // 最后访问时间，用于达到上限时淘汰最久未使用的会话
+	ContextExclude  []string  // 不放入 AI 上下文的文件规则，通过 /api/sessions/:id/context-exclude 设置
+	Dedupe          bool      // 创建会话时传入 dedupe=true，重复内容的文件只放入一次 AI 上下文
+}
+
+// SessionStore 会话存储接口。FileHandler 通过它保存和读取会话，便于在测试中替换，
+// 或换成持久化的实现
+type SessionStore interface {
+	// Put 保存处理结果为新会话并返回会话ID，会话数已达上限且无法淘汰时返回 models.ErrTooManySessions
+	Put(result *types.ProcessResult, analysis *models.ProjectAnalysis) (string, error)
+	// Get 获取未过期的会话数据并更新最后访问时间
+	Get(sessionID string) (SessionData, bool)
+	// Acquire 获取会话数据并标记为使用中（不会被淘汰或清理），使用完毕后必须调用 Release
+	Acquire(sessionID string) (SessionData, bool)
+	// Release 取消 Acquire 设置的使用中标记
+	Release(sessionID string)
+	// SetContextExclude 替换会话的上下文排除规则，会话不存在或已过期时返回 false
+	SetContextExclude(sessionID string, patterns []string) bool
+	// SetDedupe 设置会话构建 AI 上下文时是否去除重复内容的文件，会话不存在或已过期时返回 false
+	SetDedupe(sessionID string, dedupe bool) bool
+	// Count 返回当前会话数量
+	Count() int
+}
+
+// SessionStorage 内存中的会话存储，实现 SessionStore
+type SessionStorage struct {
+	sessions    map[string]SessionData
+	inUse       map[string]int // 正在处理请求的会话引用计数，使用中的会话不会被淘汰
+	expiresIn   time.Duration
+	maxSessions int // 最大会话数量，0 表示不限制
+	mu          sync.RWMutex
+}
+
+// NewSessionStorage 创建新的会话存储
+func NewSessionStorage(expiresIn time.Duration) *SessionStorage {
+	if expiresIn <= 0 {
+		expiresIn = 30 * time.Minute
+	}
+
+	ss := &SessionStorage{
+		sessions:  make(map[string]SessionData),
+		inUse:     make(map[string]int),
+		expiresIn: expiresIn,
+	}
+
+	// 启动清理过期会话的后台任务
+	go ss.cleanExpiredSessions()
+
+	return ss
+}
+
+// cleanExpiredSessions 清理过期会话
+func (ss *SessionStorage) cleanExpiredSessions() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ss.mu.Lock()
+		for id, session := range ss.sessions {
+			if time.Since(session.CreatedAt) > ss.expiresIn && ss.inUse[id] == 0 {
+				delete(ss.sessions, id)
+				logger.Debug("已清理过期会话", zap.String("session_id", id))
+			}
+		}
+		ss.mu.Unlock()
+	}
+}
+
+// SetMaxSessions 设置最大会话数量，0 表示不限制
+func (ss *SessionStorage) SetMaxSessions(maxSessions int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.maxSessions = maxSessions
+}
+
+// Put 存储会话数据。达到上限时淘汰最久未使用的空闲会话，
+// 所有会话都在使用中时返回 models.ErrTooManySessions
+func (ss *SessionStorage) Put(result *types.ProcessResult, analysis *models.ProjectAnalysis) (string, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.maxSessions > 0 && len(ss.sessions) >= ss.maxSessions && !ss.evictLeastRecentlyUsed() {
+		return "", models.ErrTooManySessions
+	}
+
+	now := time.Now()
+	sessionID := uuid.New().String()
+	ss.sessions[sessionID] = SessionData{
+		Result:          result,
+		ProjectAnalysis: analysis,
+		CreatedAt:       now,
+		LastAccess:      now,
+	}
+
+	return sessionID, nil
+}
+
+// evictLeastRecentlyUsed 淘汰最久未使用且不在使用中的会话，调用方需持有写锁
+func (ss *SessionStorage) evictLeastRecentlyUsed() bool {
+	var oldestID string
+	var oldest time.Time
+	for id, session := range ss.sessions {
+		if ss.inUse[id] > 0 {
+			continue
+		}
+		if oldestID == "" || session.LastAccess.Before(oldest) {
+			oldestID = id
+			oldest = session.LastAccess
+		}
+	}
+	if oldestID == "" {
+		return false
+	}
+
+	delete(ss.sessions, oldestID)
+	logger.Info("会话数量达到上限，已淘汰最久未使用的会话",
+		zap.String("session_id", oldestID),
+		zap.Int("max_sessions", ss.maxSessions))
+	return true
+}
+
+// Get 获取会话数据并更新最后访问时间
+func (ss *SessionStorage) Get(sessionID string) (SessionData, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return ss.get(sessionID)
+}
+
+// get 获取未过期的会话数据，调用方需持有写锁
+func (ss *SessionStorage) get(sessionID string) (SessionData, bool) {
+	session, exists := ss.sessions[sessionID]
+	if !exists {
+		return SessionData{}, false
+	}
+
+	// 检查是否过期
+	if time.Since(session.CreatedAt) > ss.expiresIn {
+		return SessionData{}, false
+	}
+
+	session.LastAccess = time.Now()
+	ss.sessions[sessionID] = session
+	return session, true
+}
+
+// Acquire 获取会话数据并标记为使用中，使用完毕后必须调用 Release
+func (ss *SessionStorage) Acquire(sessionID string) (SessionData, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.get(sessionID)
+	if ok {
+		ss.inUse[sessionID]++
+	}
+	return session, ok
+}
+
+// Release 取消 Acquire 设置的使用中标记
+func (ss *SessionStorage) Release(sessionID string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.inUse[sessionID] <= 1 {
+		delete(ss.inUse, sessionID)
+		return
+	}
+	ss.inUse[sessionID]--
+}
+
+// SetContextExclude 替换会话的上下文排除规则，会话不存在或已过期时返回 false
+func (ss *SessionStorage) SetContextExclude(sessionID string, patterns []string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.get(sessionID)
+	if !ok {
+		return false
+	}
+	session.ContextExclude = patterns
+	ss.sessions[sessionID] = session
+	return true
+}
+
+// SetDedupe 设置会话构建 AI 上下文时是否去除重复内容的文件，会话不存在或已过期时返回 false
+func (ss *SessionStorage) SetDedupe(sessionID string, dedupe bool) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.get(sessionID)
+	if !ok {
+		return false
+	}
+	session.Dedupe = dedupe
+	ss.sessions[sessionID] = session
+	return true
+}
+
+// Count 返回当前会话数量
+func (ss *SessionStorage) Count() int {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return len(ss.sessions)
+}
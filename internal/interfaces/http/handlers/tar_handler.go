@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"repo-prompt-web/internal/domain/models"
+	"repo-prompt-web/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// HandleCombineTar 处理以请求体直接上传的 tar 归档（可为 gzip 压缩），边接收边读取，无需先保存到本地。
+// 请求体即归档本身，处理参数只能通过查询参数传递
+func (h *FileHandler) HandleCombineTar(c *gin.Context) {
+	requestID := c.GetString("RequestID")
+	logger.Info("处理 tar 流合并请求",
+		zap.String("request_id", requestID),
+		zap.String("client_ip", c.ClientIP()),
+		zap.String("content_type", c.ContentType()))
+
+	// 表单请求体会在解析参数时被读取，无法再作为 tar 流处理
+	switch c.ContentType() {
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "请求体应为 tar 归档（如 application/x-tar），上传 ZIP 文件请使用 /api/combine-code"})
+		return
+	}
+
+	params, err := h.parseProcessParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Debug("请求参数", params.logFields(requestID)...)
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, params.MaxUploadSize)
+	result, err := h.fileService.ProcessTarStream(body, params.Options)
+	if err != nil {
+		logger.Error("处理 tar 流失败",
+			zap.String("request_id", requestID),
+			zap.Error(err))
+		status := http.StatusBadRequest
+		if isBodyTooLarge(err) || errors.Is(err, models.ErrExtractedTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		} else if errors.Is(err, models.ErrFileProcessing) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("tar 流处理成功",
+		zap.String("request_id", requestID),
+		zap.Int("files_count", len(result.FileContents)))
+	params.Source = "tar stream"
+
+	// 如果需要生成项目架构分析
+	var projectAnalysis *models.ProjectAnalysis
+	if (params.GeneratePrompt || params.PromptOnly) && h.config.GetDeepseekAPIKey() != "" {
+		projectAnalysis, err = h.generateProjectAnalysis(requestID, result)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	h.respondWithResult(c, requestID, params, result, projectAnalysis)
+}
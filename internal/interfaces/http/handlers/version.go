@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersion 响应结构版本。只新增字段时不变，删除字段或改变已有字段含义时递增
+const APIVersion = "1"
+
+// apiVersionHeader 所有响应都带有的版本响应头，SSE、纯文本等非 JSON 响应可据此判断版本
+const apiVersionHeader = "X-API-Version"
+
+// versionWriter 在 JSON 对象响应体的开头插入 api_version 字段
+type versionWriter struct {
+	gin.ResponseWriter
+	written bool
+}
+
+func (w *versionWriter) Write(data []byte) (int, error) {
+	// gin 渲染 JSON 时一次写出完整响应体，只需处理第一次写入
+	if w.written {
+		return w.ResponseWriter.Write(data)
+	}
+	w.written = true
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || len(data) == 0 || data[0] != '{' {
+		return w.ResponseWriter.Write(data)
+	}
+
+	field := `{"api_version":"` + APIVersion + `"`
+	rest := data[1:]
+	// 空对象不需要逗号
+	if trimmed := bytes.TrimSpace(rest); len(trimmed) > 0 && trimmed[0] != '}' {
+		field += ","
+	}
+	if _, err := w.ResponseWriter.WriteString(field); err != nil {
+		return 0, err
+	}
+	if _, err := w.ResponseWriter.Write(rest); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *versionWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// APIVersionMiddleware 为所有响应添加 X-API-Version 响应头，并在 JSON 对象响应中加入顶层 api_version 字段，
+// 便于客户端在响应结构调整时做兼容处理
+func APIVersionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(apiVersionHeader, APIVersion)
+		c.Writer = &versionWriter{ResponseWriter: c.Writer}
+		c.Next()
+	}
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"repo-prompt-web/internal/app/service"
 	"repo-prompt-web/internal/application"
 	"repo-prompt-web/internal/domain/services"
+	"repo-prompt-web/internal/infrastructure/anthropic"
 	"repo-prompt-web/internal/infrastructure/github"
+	"repo-prompt-web/internal/infrastructure/remote"
 	"repo-prompt-web/internal/interfaces/http/handlers"
 	"repo-prompt-web/pkg/config"
+	"repo-prompt-web/pkg/httpclient"
+	"repo-prompt-web/pkg/limiter"
 	"repo-prompt-web/pkg/logger"
 
 	"github.com/gin-gonic/gin"
@@ -68,12 +77,31 @@ func LoggerMiddleware() gin.HandlerFunc {
 	}
 }
 
+// BodyLimitMiddleware 限制请求体大小，超过限制时返回 413。
+// MaxMultipartMemory 只控制内存缓冲，超出部分仍会写入磁盘，因此需要在读取前限制
+func BodyLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			logger.Warn("请求体超过大小限制",
+				zap.String("request_id", c.GetString("RequestID")),
+				zap.Int64("content_length", c.Request.ContentLength),
+				zap.Int64("max_size", maxBytes))
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "请求体超过大小限制"})
+			return
+		}
+
+		// 分块传输等未声明长度的请求在读取时截断
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
 // CORSMiddleware 添加CORS支持
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -85,6 +113,22 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
+// watchReloadSignal 监听 SIGHUP 信号并重新加载文件过滤规则
+func watchReloadSignal(configPath string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if err := config.Reload(configPath); err != nil {
+			logger.Error("重新加载配置失败，继续使用当前配置",
+				zap.String("config_path", configPath),
+				zap.Error(err))
+			continue
+		}
+		logger.Info("已重新加载文件过滤规则", zap.String("config_path", configPath))
+	}
+}
+
 func main() {
 	// 加载配置文件
 	configPath := filepath.Join(".", "config.yml")
@@ -94,26 +138,83 @@ func main() {
 
 	// 初始化日志
 	cfg := config.Get()
-	logger.Init(cfg.GetLogLevel(), cfg.GetLogOutputPath())
+	logger.Init(logger.Options{
+		ConsoleLevel: cfg.GetLogConsoleLevel(),
+		FileLevel:    cfg.GetLogFileLevel(),
+		OutputPath:   cfg.GetLogOutputPath(),
+		MaxSizeMB:    cfg.GetLogMaxSizeMB(),
+		MaxBackups:   cfg.GetLogMaxBackups(),
+		MaxAgeDays:   cfg.GetLogMaxAgeDays(),
+	})
 	defer logger.Sync()
 
+	// 初始化审计日志（可选）
+	if cfg.IsAuditEnabled() {
+		if err := logger.InitAudit(cfg.GetAuditFilePath()); err != nil {
+			logger.Fatal("初始化审计日志失败", zap.Error(err))
+		}
+		defer logger.SyncAudit()
+		logger.Info("已启用审计日志", zap.String("file_path", cfg.GetAuditFilePath()))
+	}
+
 	logger.Info("服务启动", zap.String("config_path", configPath))
 
+	// 收到 SIGHUP 时热更新排除/文本列表
+	go watchReloadSignal(configPath)
+
 	// 获取环境变量中的 DeepSeek API 密钥
 	deepseekAPIKey := cfg.GetDeepseekAPIKey()
 
 	// 创建依赖
 	fileProcessor := services.NewFileProcessor(cfg)
-	fileService := application.NewFileService(fileProcessor)
-	githubClient := github.NewClient(cfg)
-	aiService := service.NewAIService(cfg)
+	fileService := application.NewFileService(fileProcessor, cfg)
+	// 所有外部 API 的 HTTP 客户端共享连接池配置
+	clients := httpclient.NewFactory(httpclient.PoolOptions{
+		MaxIdleConns:          cfg.GetHTTPMaxIdleConns(),
+		MaxIdleConnsPerHost:   cfg.GetHTTPMaxIdleConnsPerHost(),
+		IdleConnTimeout:       cfg.GetHTTPIdleConnTimeout(),
+		DialTimeout:           cfg.GetHTTPDialTimeout(),
+		TLSHandshakeTimeout:   cfg.GetHTTPTLSHandshakeTimeout(),
+		ResponseHeaderTimeout: cfg.GetHTTPResponseHeaderTimeout(),
+	})
+	githubClient := github.NewClient(cfg, clients)
+	downloader := remote.NewDownloader(cfg, clients)
+	// Gemini 和 DeepSeek 共享同一个并发限制
+	aiLimiter := limiter.New(cfg.GetAIMaxConcurrentRequests(), cfg.GetAIQueueTimeout())
+	// 代码问答的模型提供方，nil 表示使用 Gemini
+	var llm service.LLMClient
+	switch cfg.GetAIProvider() {
+	case "gemini":
+	case "anthropic":
+		llm = anthropic.NewClient(cfg, clients)
+	default:
+		logger.Fatal("不支持的 ai.provider，可选 gemini 或 anthropic", zap.String("provider", cfg.GetAIProvider()))
+	}
+	aiService := service.NewAIService(cfg, clients, aiLimiter, llm)
 
 	// 创建提示词服务和处理器
-	promptService := application.NewPromptService(deepseekAPIKey)
+	promptService := application.NewPromptService(deepseekAPIKey, services.PromptGeneratorOptions{
+		IgnoreFiles:    cfg.GetIgnoreFiles(),
+		ImportantFiles: cfg.GetImportantFiles(),
+		Limits: services.DocumentLimits{
+			MaxTotal:       cfg.GetMaxDocuments(),
+			MaxPerType:     cfg.GetMaxDocumentsPerType(),
+			MaxDocsPerType: cfg.GetMaxDocFilesPerType(),
+		},
+		LanguageForPath: cfg.LanguageForPath,
+		HTTPClient: clients.Client(httpclient.Options{
+			Name:     "DeepSeek",
+			Timeout:  120 * time.Second,
+			ProxyURL: cfg.GetDeepseekProxyURL(),
+			// 非流式调用在生成结束后才返回响应头，只受整体超时限制
+			ResponseHeaderTimeout: httpclient.NoResponseHeaderTimeout,
+		}),
+		Limiter: aiLimiter,
+	})
 	promptHandler := handlers.NewPromptHandler(promptService, fileService, cfg)
 
-	// 创建文件处理器
-	fileHandler := handlers.NewFileHandler(fileService, promptService, githubClient, aiService, cfg)
+	// 创建文件处理器，会话存储传 nil 时使用内存实现
+	fileHandler := handlers.NewFileHandler(fileService, promptService, githubClient, downloader, aiService, nil, cfg)
 
 	// 创建 Gin 引擎
 	router := gin.Default()
@@ -121,22 +222,45 @@ func main() {
 	// 添加中间件
 	router.Use(CORSMiddleware())
 	router.Use(RequestIDMiddleware())
+	router.Use(handlers.APIVersionMiddleware())
 	router.Use(LoggerMiddleware())
+	router.Use(BodyLimitMiddleware(cfg.GetMaxRequestSize()))
 
 	// 设置上传限制
 	router.MaxMultipartMemory = cfg.GetMaxUploadSize()
 
+	// 带 Idempotency-Key 的重复请求直接返回缓存的响应
+	idempotency := handlers.Idempotency(handlers.NewIdempotencyStore(cfg.GetIdempotencyTTL(), cfg.GetIdempotencyMaxEntries(), cfg.GetIdempotencyMaxBodyBytes()))
+
 	// 注册文件处理路由
-	router.POST("/api/combine-code", fileHandler.HandleCombineCode)
+	router.POST("/api/combine-code", idempotency, fileHandler.HandleCombineCode)
+	router.GET("/api/combine-code", fileHandler.HandleCombineCode)
+	router.POST("/api/combine-tar", idempotency, fileHandler.HandleCombineTar)
 	router.GET("/api/github-code", fileHandler.HandleGitHubRepo)
+	router.HEAD("/api/github-code", fileHandler.HandleGitHubRepo)
+	router.GET("/api/tree", fileHandler.HandleRepoTree)
+	router.GET("/api/github-diff", fileHandler.HandleGitHubDiff)
 
 	// 注册提示词生成路由
-	router.POST("/api/generate-prompt", promptHandler.HandleGeneratePrompt)
+	router.POST("/api/generate-prompt", idempotency, promptHandler.HandleGeneratePrompt)
 	router.POST("/api/preprocess-zip", promptHandler.HandlePreProcess)
+	router.POST("/api/analyze-stream", fileHandler.HandleAnalyzeStream)
 
 	// 注册代码问答路由
 	router.POST("/api/ask-code-question", fileHandler.HandleAskCodeQuestion)
 	router.GET("/api/ask-code-question", fileHandler.HandleAskCodeQuestion)
+	router.GET("/api/sessions/stats", fileHandler.HandleSessionStats)
+	router.GET("/api/stats", fileHandler.HandleStats)
+	router.POST("/api/compare", fileHandler.HandleCompare)
+	router.POST("/api/validate-key", fileHandler.HandleValidateKey)
+	router.GET("/api/sessions/:id/prompt", fileHandler.HandleSessionPrompt)
+	router.GET("/api/sessions/:id/combined", fileHandler.HandleSessionCombined)
+	router.GET("/api/sessions/:id/analysis.md", fileHandler.HandleSessionAnalysis)
+	router.GET("/api/sessions/:id/file", fileHandler.HandleSessionFile)
+	router.POST("/api/sessions/:id/context-exclude", fileHandler.HandleSessionContextExclude)
+
+	// 注册 API 文档路由
+	router.GET("/openapi.json", handlers.HandleOpenAPI)
 
 	// 定义监听地址
 	listenAddr := ":8080"
@@ -149,8 +273,15 @@ func main() {
 		logger.Info("已配置 DeepSeek API 密钥，提示词生成功能可用")
 	}
 
-	// 检查Gemini API密钥
-	if cfg.GetGeminiAPIKey() == "" {
+	// 检查代码问答使用的 API 密钥
+	if cfg.GetAIProvider() == "anthropic" {
+		if cfg.GetAnthropicAPIKey() == "" {
+			logger.Warn("未设置 Anthropic API 密钥，代码问答功能将无法使用")
+			logger.Info("请在 config.yml 文件中配置 api_keys.anthropic 或设置环境变量 ANTHROPIC_API_KEY")
+		} else {
+			logger.Info("已配置 Anthropic API 密钥，代码问答功能可用", zap.String("model", cfg.GetAnthropicModel()))
+		}
+	} else if cfg.GetGeminiAPIKey() == "" {
 		logger.Warn("未设置 Gemini API 密钥，代码问答功能将无法使用")
 		logger.Info("请在 config.yml 文件中配置 api_keys.gemini 或设置环境变量 GEMINI_API_KEY")
 	} else {
@@ -161,10 +292,23 @@ func main() {
 	logger.Info("启动服务", zap.String("listen_addr", listenAddr))
 	logger.Info("API使用方法",
 		zap.String("combine_code", "POST http://localhost"+listenAddr+"/api/combine-code"),
+		zap.String("combine_remote_zip", "GET http://localhost"+listenAddr+"/api/combine-code?zip_url=<zip_url>"),
+		zap.String("combine_tar", "POST http://localhost"+listenAddr+"/api/combine-tar (请求体为 tar 或 tar.gz)"),
 		zap.String("github_code", "GET http://localhost"+listenAddr+"/api/github-code?url=<repo_url>"),
+		zap.String("repo_tree", "GET http://localhost"+listenAddr+"/api/tree?url=<repo_url>&branch=<branch>"),
+		zap.String("github_diff", "GET http://localhost"+listenAddr+"/api/github-diff?repo=<owner/repo>&base=<base>&head=<head>"),
 		zap.String("generate_prompt", "POST http://localhost"+listenAddr+"/api/generate-prompt"),
 		zap.String("preprocess_zip", "POST http://localhost"+listenAddr+"/api/preprocess-zip"),
-		zap.String("ask_code_question", "GET/POST http://localhost"+listenAddr+"/api/ask-code-question?session_id=<id>&question=<question>&stream=true|false"))
+		zap.String("ask_code_question", "GET/POST http://localhost"+listenAddr+"/api/ask-code-question?session_id=<id>&question=<question>&stream=true|false"),
+		zap.String("session_prompt", "GET http://localhost"+listenAddr+"/api/sessions/<id>/prompt"),
+		zap.String("session_combined", "GET http://localhost"+listenAddr+"/api/sessions/<id>/combined?format=text|json"),
+		zap.String("session_analysis", "GET http://localhost"+listenAddr+"/api/sessions/<id>/analysis.md"),
+		zap.String("session_file", "GET http://localhost"+listenAddr+"/api/sessions/<id>/file?path=<path>"),
+		zap.String("session_stats", "GET http://localhost"+listenAddr+"/api/sessions/stats"),
+		zap.String("stats", "GET http://localhost"+listenAddr+"/api/stats"),
+		zap.String("compare", "POST http://localhost"+listenAddr+"/api/compare?url_a=<repo_url>&url_b=<repo_url>"),
+		zap.String("validate_key", "POST http://localhost"+listenAddr+"/api/validate-key"),
+		zap.String("openapi", "GET http://localhost"+listenAddr+"/openapi.json"))
 
 	if err := router.Run(listenAddr); err != nil {
 		logger.Fatal("启动 Gin 服务失败", zap.Error(err))
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,17 +20,35 @@ type Config struct {
 	FileLimits struct {
 		MaxUploadSize  int64 `yaml:"max_upload_size"`
 		MaxFileSize    int64 `yaml:"max_file_size"`
-		ReadBufferSize int   `yaml:"read_buffer_size"`
+		MaxRequestSize int64 `yaml:"max_request_size"` // 请求体上限，0 表示 max_upload_size 加 1MB 表单开销
+		MaxBinaryBytes int64 `yaml:"max_binary_bytes"` // include_binary 时单个二进制文件的上限（字节）
+		MaxTreeNodes   int   `yaml:"max_tree_nodes"`   // 文件树的最大节点数（文件和目录），超出后不再加入并标记为已截断
+		// MaxExtractedSize 解压压缩的 tar 归档时读取的最大字节数，0 表示 max_upload_size 的 4 倍
+		MaxExtractedSize int64 `yaml:"max_extracted_size"`
+		ReadBufferSize   int   `yaml:"read_buffer_size"`
 	} `yaml:"file_limits"`
 
 	Output struct {
-		Filename string `yaml:"filename"`
+		Filename     string `yaml:"filename"`
+		TreeMaxDepth int    `yaml:"tree_max_depth"` // 文件树最大渲染深度，0 表示不限制
+
+		// 文本输出模板，留空使用默认格式；FileHeader 支持 {path}、{size}、{language} 占位符
+		TreeHeader    string `yaml:"tree_header"`
+		ContentHeader string `yaml:"content_header"`
+		FileHeader    string `yaml:"file_header"`
+		FileFooter    string `yaml:"file_footer"`
 	} `yaml:"output"`
 
 	ApiKeys struct {
-		Deepseek string `yaml:"deepseek"`
-		Github   string `yaml:"github"`
-		Gemini   string `yaml:"gemini"`
+		Deepseek  string `yaml:"deepseek"`
+		Github    string `yaml:"github"`
+		Gemini    string `yaml:"gemini"`
+		Anthropic string `yaml:"anthropic"`
+		// 从文件读取密钥（如 Docker/Kubernetes secret 挂载路径），优先于上面的明文配置
+		DeepseekFile  string `yaml:"deepseek_file"`
+		GithubFile    string `yaml:"github_file"`
+		GeminiFile    string `yaml:"gemini_file"`
+		AnthropicFile string `yaml:"anthropic_file"`
 	} `yaml:"api_keys"`
 
 	Gemini struct {
@@ -32,23 +56,241 @@ type Config struct {
 		ApiEndpoint string `yaml:"api_endpoint"`
 		Model       string `yaml:"model"`
 		ProxyURL    string `yaml:"proxy_url"`
+
+		MaxRetries        int `yaml:"max_retries"`         // 普通请求最大尝试次数，默认 3
+		StreamMaxRetries  int `yaml:"stream_max_retries"`  // 流式请求最大尝试次数，默认 2
+		RetryDelaySeconds int `yaml:"retry_delay_seconds"` // 首次重试前等待秒数，之后每次翻倍，默认 2
 	} `yaml:"gemini"`
 
+	Deepseek struct {
+		ProxyURL string `yaml:"proxy_url"` // 调用 DeepSeek API 使用的代理，可被环境变量 DEEPSEEK_PROXY 覆盖
+	} `yaml:"deepseek"`
+
+	Anthropic struct {
+		ApiEndpoint string `yaml:"api_endpoint"` // Messages API 根地址，默认 https://api.anthropic.com/v1
+		Model       string `yaml:"model"`        // 回答问题使用的模型
+		MaxTokens   int    `yaml:"max_tokens"`   // 单次回答的最大输出 token 数，默认 8192
+		ProxyURL    string `yaml:"proxy_url"`    // 代理服务器地址，可被环境变量 ANTHROPIC_PROXY 覆盖
+	} `yaml:"anthropic"`
+
+	Analysis struct {
+		MaxDocuments        int `yaml:"max_documents"`          // 架构分析最多收集的文档数，默认 10
+		MaxDocumentsPerType int `yaml:"max_documents_per_type"` // 每种非文档类型（如 go.mod、Dockerfile）最多收集的文件数，默认 1
+		MaxDocFilesPerType  int `yaml:"max_doc_files_per_type"` // 每种文档扩展名（如 .md）最多收集的文件数，默认 4
+	} `yaml:"analysis"`
+
+	AI struct {
+		Provider            string `yaml:"provider"`              // 代码问答使用的模型提供方: gemini（默认）或 anthropic
+		MaxQuestionBytes    int    `yaml:"max_question_bytes"`    // 代码问答中单个问题的最大字节数，默认 16KB
+		PromptTemplateFile  string `yaml:"prompt_template_file"`  // 代码问答提示词模板文件，为空时使用内置布局
+		MaxPromptChars      int    `yaml:"max_prompt_chars"`      // 发送给模型的提示词最大字符数，超出时省略部分文件和较早的对话，默认 400000
+		SummarizeLargeFiles bool   `yaml:"summarize_large_files"` // 超出单文件长度上限的文件用模型生成的摘要代替截断内容，摘要按会话缓存
+		SessionTokenBudget  int    `yaml:"session_token_budget"`  // 单个会话累计可消耗的 token 数，超出后拒绝继续提问，0 表示不限制
+
+		MaxConcurrentRequests int `yaml:"max_concurrent_requests"` // 同时进行的 Gemini/DeepSeek 请求数上限，0 表示不限制
+		QueueTimeoutSeconds   int `yaml:"queue_timeout_seconds"`   // 名额已满时排队等待的最长时间（秒），超时返回 503，默认 30
+		RetryAfterSeconds     int `yaml:"retry_after_seconds"`     // 返回 503 时 Retry-After 响应头的秒数，默认 5
+	} `yaml:"ai"`
+
+	Sessions struct {
+		MaxSessions int `yaml:"max_sessions"` // 代码会话和 AI 对话上下文各自的最大数量，超出时淘汰最久未使用的会话，默认 1000
+	} `yaml:"sessions"`
+
+	SSE struct {
+		KeepAliveSeconds int  `yaml:"keepalive_seconds"` // 无数据时发送保活注释的间隔（秒），负数表示禁用
+		FlushChars       int  `yaml:"flush_chars"`       // 累计达到该字符数时发送一次 message 事件，0 表示不按字符数合并
+		FlushIntervalMs  int  `yaml:"flush_interval_ms"` // 合并数据块的最长发送间隔（毫秒），0 表示不按时间合并
+		MarkdownSafe     bool `yaml:"markdown_safe"`     // 暂存可能被截断的 Markdown 语法（代码块围栏、链接），只发送可以直接渲染的内容
+	} `yaml:"sse"`
+
+	HTTPClient struct {
+		MaxIdleConns                 int `yaml:"max_idle_conns"`                  // 所有主机的最大空闲连接数，默认 100
+		MaxIdleConnsPerHost          int `yaml:"max_idle_conns_per_host"`         // 每个主机的最大空闲连接数，默认 10
+		IdleConnTimeoutSeconds       int `yaml:"idle_conn_timeout_seconds"`       // 空闲连接保留时间（秒），默认 90
+		DialTimeoutSeconds           int `yaml:"dial_timeout_seconds"`            // 建立连接超时（秒），默认 30
+		TLSHandshakeTimeoutSeconds   int `yaml:"tls_handshake_timeout_seconds"`   // TLS 握手超时（秒），默认 30
+		ResponseHeaderTimeoutSeconds int `yaml:"response_header_timeout_seconds"` // 等待响应头超时（秒），默认 60
+	} `yaml:"http_client"`
+
+	Idempotency struct {
+		TTLSeconds   int   `yaml:"ttl_seconds"`    // 幂等键缓存响应的有效期（秒），默认 600
+		MaxEntries   int   `yaml:"max_entries"`    // 最多缓存的响应数，超出时淘汰最早的响应，默认 100
+		MaxBodyBytes int64 `yaml:"max_body_bytes"` // 单个响应体的最大缓存字节数，超出时不缓存，默认 4MB
+	} `yaml:"idempotency"`
+
+	Embeddings struct {
+		Enabled     bool   `yaml:"enabled"`      // 是否启用基于向量的相关文件检索
+		ApiEndpoint string `yaml:"api_endpoint"` // 向量接口地址，默认与 Gemini 相同
+		Model       string `yaml:"model"`        // 向量模型
+		TopK        int    `yaml:"top_k"`        // 每个问题选取的相关文件数
+		MaxChars    int    `yaml:"max_chars"`    // 每个文件参与向量计算的最大字符数
+	} `yaml:"embeddings"`
+
+	Github struct {
+		EnterpriseHosts   []string `yaml:"enterprise_hosts"`    // GitHub Enterprise 主机名列表
+		DisableGraphQL    bool     `yaml:"disable_graphql"`     // 禁用 GraphQL 批量获取文件，始终逐个调用 REST 接口
+		GraphQLBatchSize  int      `yaml:"graphql_batch_size"`  // 每个 GraphQL 查询获取的文件数，默认 50
+		FollowSubmodules  bool     `yaml:"follow_submodules"`   // 默认是否获取子模块内容，可被请求参数 follow_submodules 覆盖
+		SubmoduleMaxDepth int      `yaml:"submodule_max_depth"` // 子模块最大嵌套深度，默认 1
+		// LastModifiedMaxFiles include_last_modified 时最多查询提交记录的文件数，每个文件一次 API 请求，默认 20
+		LastModifiedMaxFiles int `yaml:"last_modified_max_files"`
+		// MaxAPIRequests 获取一个仓库（含子模块）最多发出的 API 请求数，达到上限后停止获取并将结果标记为不完整，0 表示不限制
+		MaxAPIRequests int `yaml:"max_api_requests"`
+		// DefaultBranches 无法获取仓库默认分支时依次尝试的分支，默认 main、master
+		DefaultBranches []string `yaml:"default_branches"`
+		// PriorityExtensions 优先获取的文件扩展名，在请求数达到上限前先于其他文件获取
+		PriorityExtensions []string `yaml:"priority_extensions"`
+	} `yaml:"github"`
+
+	RemoteZip struct {
+		AllowedSchemes []string `yaml:"allowed_schemes"` // 允许的 URL 协议，默认仅 https
+		AllowedHosts   []string `yaml:"allowed_hosts"`   // 允许的主机，支持 "*.example.com" 通配
+		TimeoutSeconds int      `yaml:"timeout_seconds"` // 下载超时时间（秒）
+	} `yaml:"remote_zip"`
+
+	Defaults struct {
+		GeneratePrompt bool   `yaml:"generate_prompt"` // 请求未指定 generate_prompt 时是否生成项目架构分析
+		Format         string `yaml:"format"`          // 请求未指定 format 时的输出格式: text 或 json，默认 text
+		Base64         bool   `yaml:"base64"`          // 请求未指定 base64 时是否以 Base64 返回文件内容
+	} `yaml:"defaults"`
+
 	Logging struct {
-		Level      string `yaml:"level"`       // 日志级别: debug, info, warn, error
-		OutputPath string `yaml:"output_path"` // 日志输出路径
+		Level        string `yaml:"level"`         // 日志级别: debug, info, warn, error
+		ConsoleLevel string `yaml:"console_level"` // 控制台日志级别，为空时使用 level
+		FileLevel    string `yaml:"file_level"`    // 文件日志（app.log）级别，为空时使用 level
+		OutputPath   string `yaml:"output_path"`   // 日志输出路径
+		MaxSizeMB    int    `yaml:"max_size_mb"`   // app.log、error.log 单个文件的最大大小（MB），超过时轮转，默认 100
+		MaxBackups   int    `yaml:"max_backups"`   // 每个日志文件保留的备份数量，0 表示全部保留
+		MaxAgeDays   int    `yaml:"max_age_days"`  // 备份的最长保留天数，0 表示不按时间删除
 	} `yaml:"logging"`
 
+	Audit struct {
+		Enabled  bool   `yaml:"enabled"`   // 是否记录审计日志（处理的代码来源、会话和提问，不含文件内容）
+		FilePath string `yaml:"file_path"` // 审计日志文件路径，独立于应用日志，默认 ./logs/audit.log
+	} `yaml:"audit"`
+
 	ExcludedDirPrefixes []string `yaml:"excluded_dir_prefixes"`
 	ExcludedExtensions  []string `yaml:"excluded_extensions"`
 	TextExtensions      []string `yaml:"text_extensions"`
 	TextFilenames       []string `yaml:"text_filenames"`
 	TextMimeTypes       []string `yaml:"text_mime_types"`
 
+	// 测试文件与生成代码的匹配模式（skip_tests / skip_generated 时使用）
+	FileFilters struct {
+		TestPatterns      []string `yaml:"test_patterns"`
+		GeneratedPatterns []string `yaml:"generated_patterns"`
+		IgnoreFiles       []string `yaml:"ignore_files"`    // 按 gitignore 语法应用的忽略文件名，如 .dockerignore
+		ImportantFiles    []string `yaml:"important_files"` // 架构分析和 GitHub 获取时优先收集的文件名，如 README.md、go.mod
+		// 关闭内容检测后，未识别扩展名的文件一律排除；默认对其内容前缀做文本检测
+		DisableContentSniffing bool `yaml:"disable_content_sniffing"`
+	} `yaml:"file_filters"`
+
+	// 扩展名（或文件名）到语言名称的映射，覆盖内置默认值
+	Languages map[string]string `yaml:"languages"`
+
 	// 运行时缓存
 	excludedExtMap map[string]struct{}
 	textExtMap     map[string]struct{}
 	textMimeMap    map[string]struct{}
+	languageMap    map[string]string
+
+	// rulesMu 保护可通过 Reload 热更新的排除/文本列表及其映射
+	rulesMu sync.RWMutex
+}
+
+// defaultTestPatterns 默认的测试文件匹配模式，以 "/" 结尾的模式匹配目录名
+var defaultTestPatterns = []string{
+	"*_test.go",
+	"test_*.py",
+	"*_test.py",
+	"*.test.js",
+	"*.test.ts",
+	"*.test.jsx",
+	"*.test.tsx",
+	"*.spec.js",
+	"*.spec.ts",
+	"*Test.java",
+	"*Tests.cs",
+	"__tests__/",
+}
+
+// defaultGeneratedPatterns 默认的生成代码匹配模式，以 "/" 结尾的模式匹配目录名
+var defaultGeneratedPatterns = []string{
+	"*.pb.go",
+	"*.pb.gw.go",
+	"*_generated.go",
+	"*.generated.*",
+	"*_pb2.py",
+	"*.min.js",
+	"__generated__/",
+}
+
+// defaultLanguages 内置的扩展名到语言映射
+var defaultLanguages = map[string]string{
+	".go":            "go",
+	".py":            "python",
+	".js":            "javascript",
+	".jsx":           "jsx",
+	".ts":            "typescript",
+	".tsx":           "tsx",
+	".java":          "java",
+	".kt":            "kotlin",
+	".kts":           "kotlin",
+	".scala":         "scala",
+	".c":             "c",
+	".h":             "c",
+	".cpp":           "cpp",
+	".hpp":           "cpp",
+	".cs":            "csharp",
+	".rs":            "rust",
+	".rb":            "ruby",
+	".php":           "php",
+	".pl":            "perl",
+	".pm":            "perl",
+	".swift":         "swift",
+	".m":             "objectivec",
+	".mm":            "objectivec",
+	".r":             "r",
+	".lua":           "lua",
+	".clj":           "clojure",
+	".ex":            "elixir",
+	".exs":           "elixir",
+	".erl":           "erlang",
+	".hrl":           "erlang",
+	".hs":            "haskell",
+	".elm":           "elm",
+	".fs":            "fsharp",
+	".fsx":           "fsharp",
+	".fsi":           "fsharp",
+	".vue":           "vue",
+	".html":          "html",
+	".htm":           "html",
+	".css":           "css",
+	".scss":          "scss",
+	".sass":          "sass",
+	".less":          "less",
+	".json":          "json",
+	".xml":           "xml",
+	".yaml":          "yaml",
+	".yml":           "yaml",
+	".toml":          "toml",
+	".ini":           "ini",
+	".sql":           "sql",
+	".proto":         "protobuf",
+	".sh":            "bash",
+	".bash":          "bash",
+	".zsh":           "zsh",
+	".fish":          "fish",
+	".bat":           "batch",
+	".cmd":           "batch",
+	".ps1":           "powershell",
+	".md":            "markdown",
+	".markdown":      "markdown",
+	".rst":           "rst",
+	"Dockerfile":     "dockerfile",
+	"Makefile":       "makefile",
+	"go.mod":         "go",
+	"CMakeLists.txt": "cmake",
 }
 
 var (
@@ -66,38 +308,141 @@ func Load(configPath string) error {
 			return
 		}
 
-		// 初始化映射
-		config.excludedExtMap = make(map[string]struct{})
-		config.textExtMap = make(map[string]struct{})
-		config.textMimeMap = make(map[string]struct{})
-
 		// 转换扩展名列表为映射
-		for _, ext := range config.ExcludedExtensions {
-			config.excludedExtMap[ext] = struct{}{}
+		config.excludedExtMap, config.textExtMap, config.textMimeMap = config.buildRuleMaps()
+
+		// 未配置时使用默认的测试/生成代码模式
+		if len(config.FileFilters.TestPatterns) == 0 {
+			config.FileFilters.TestPatterns = defaultTestPatterns
 		}
-		for _, ext := range config.TextExtensions {
-			config.textExtMap[ext] = struct{}{}
+		if len(config.FileFilters.GeneratedPatterns) == 0 {
+			config.FileFilters.GeneratedPatterns = defaultGeneratedPatterns
 		}
-		for _, mime := range config.TextMimeTypes {
-			config.textMimeMap[mime] = struct{}{}
+
+		// 合并语言映射：内置默认值 + 配置覆盖
+		config.languageMap = make(map[string]string, len(defaultLanguages)+len(config.Languages))
+		for key, lang := range defaultLanguages {
+			config.languageMap[key] = lang
+		}
+		for key, lang := range config.Languages {
+			config.languageMap[key] = lang
 		}
 
 		// 转换大小为字节
-		config.FileLimits.MaxUploadSize *= 1024 * 1024 // MB to bytes
-		config.FileLimits.MaxFileSize *= 1024 * 1024   // MB to bytes
+		config.FileLimits.MaxUploadSize *= 1024 * 1024    // MB to bytes
+		config.FileLimits.MaxFileSize *= 1024 * 1024      // MB to bytes
+		config.FileLimits.MaxRequestSize *= 1024 * 1024   // MB to bytes
+		config.FileLimits.MaxExtractedSize *= 1024 * 1024 // MB to bytes
 
-		// 尝试从环境变量读取 API 密钥
-		if envKey := os.Getenv("DEEPSEEK_API_KEY"); envKey != "" {
-			config.ApiKeys.Deepseek = envKey
+		// 尝试从环境变量和密钥文件读取 API 密钥
+		err = config.loadAPIKeys()
+	})
+	return err
+}
+
+// loadAPIKeys 按优先级确定各 API 密钥：环境变量 <NAME>_API_KEY > 环境变量 <NAME>_API_KEY_FILE 指向的文件 >
+// 配置 api_keys.<name>_file 指向的文件 > 配置 api_keys.<name>。指定的密钥文件无法读取时返回错误
+func (c *Config) loadAPIKeys() error {
+	keys := []struct {
+		env   string
+		file  string
+		value *string
+	}{
+		{"DEEPSEEK", c.ApiKeys.DeepseekFile, &c.ApiKeys.Deepseek},
+		{"GITHUB", c.ApiKeys.GithubFile, &c.ApiKeys.Github},
+		{"GEMINI", c.ApiKeys.GeminiFile, &c.ApiKeys.Gemini},
+		{"ANTHROPIC", c.ApiKeys.AnthropicFile, &c.ApiKeys.Anthropic},
+	}
+
+	for _, key := range keys {
+		if envKey := os.Getenv(key.env + "_API_KEY"); envKey != "" {
+			*key.value = envKey
+			continue
 		}
-		if envKey := os.Getenv("GITHUB_API_KEY"); envKey != "" {
-			config.ApiKeys.Github = envKey
+
+		path := key.file
+		if envFile := os.Getenv(key.env + "_API_KEY_FILE"); envFile != "" {
+			path = envFile
 		}
-		if envKey := os.Getenv("GEMINI_API_KEY"); envKey != "" {
-			config.ApiKeys.Gemini = envKey
+		if path == "" {
+			continue
 		}
-	})
-	return err
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("读取 %s API 密钥文件失败: %w", strings.ToLower(key.env), err)
+		}
+		// secret 文件通常以换行结尾
+		*key.value = strings.TrimSpace(string(content))
+	}
+	return nil
+}
+
+// Reload 重新读取配置文件，校验通过后原子替换排除/文本列表及其映射。
+// 其他配置项（端口、密钥等）仍需重启生效；校验失败时保留当前配置
+func Reload(configPath string) error {
+	if config == nil {
+		return fmt.Errorf("配置尚未加载")
+	}
+
+	fresh := &Config{}
+	if err := loadConfig(configPath, fresh); err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	if err := fresh.validateRules(); err != nil {
+		return fmt.Errorf("配置校验失败: %w", err)
+	}
+	excludedExtMap, textExtMap, textMimeMap := fresh.buildRuleMaps()
+
+	config.rulesMu.Lock()
+	defer config.rulesMu.Unlock()
+
+	config.ExcludedDirPrefixes = fresh.ExcludedDirPrefixes
+	config.ExcludedExtensions = fresh.ExcludedExtensions
+	config.TextExtensions = fresh.TextExtensions
+	config.TextFilenames = fresh.TextFilenames
+	config.TextMimeTypes = fresh.TextMimeTypes
+	config.excludedExtMap = excludedExtMap
+	config.textExtMap = textExtMap
+	config.textMimeMap = textMimeMap
+	return nil
+}
+
+// validateRules 校验排除/文本列表，避免错误的配置文件影响运行中的服务
+func (c *Config) validateRules() error {
+	if len(c.TextExtensions) == 0 {
+		return fmt.Errorf("text_extensions 不能为空")
+	}
+	for _, list := range [][]string{c.ExcludedExtensions, c.TextExtensions} {
+		for _, ext := range list {
+			if !strings.HasPrefix(ext, ".") {
+				return fmt.Errorf("扩展名必须以 \".\" 开头: %q", ext)
+			}
+		}
+	}
+	for _, prefix := range c.ExcludedDirPrefixes {
+		if prefix == "" {
+			return fmt.Errorf("excluded_dir_prefixes 不能包含空字符串")
+		}
+	}
+	return nil
+}
+
+// buildRuleMaps 将扩展名和 MIME 类型列表转换为查找映射
+func (c *Config) buildRuleMaps() (excludedExtMap, textExtMap, textMimeMap map[string]struct{}) {
+	excludedExtMap = make(map[string]struct{}, len(c.ExcludedExtensions))
+	textExtMap = make(map[string]struct{}, len(c.TextExtensions))
+	textMimeMap = make(map[string]struct{}, len(c.TextMimeTypes))
+
+	for _, ext := range c.ExcludedExtensions {
+		excludedExtMap[ext] = struct{}{}
+	}
+	for _, ext := range c.TextExtensions {
+		textExtMap[ext] = struct{}{}
+	}
+	for _, mime := range c.TextMimeTypes {
+		textMimeMap[mime] = struct{}{}
+	}
+	return excludedExtMap, textExtMap, textMimeMap
 }
 
 // Get 返回配置实例
@@ -120,24 +465,38 @@ func (c *Config) IsExcluded(filePath string, fileSize uint64) bool {
 		return true
 	}
 
-	// 规范化路径
-	normalizedPath := filepath.ToSlash(filePath)
+	if c.IsExcludedDir(filePath) {
+		return true
+	}
 
-	// 检查目录前缀
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
+	// 检查扩展名
+	ext := filepath.Ext(filepath.ToSlash(filePath))
+	_, excluded := c.excludedExtMap[ext]
+	return excluded
+}
+
+// IsExcludedDir 检查文件是否位于排除的目录下
+func (c *Config) IsExcludedDir(filePath string) bool {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
+	normalizedPath := filepath.ToSlash(filePath)
 	for _, prefix := range c.ExcludedDirPrefixes {
 		if strings.HasPrefix(normalizedPath, prefix) {
 			return true
 		}
 	}
-
-	// 检查扩展名
-	ext := filepath.Ext(normalizedPath)
-	_, excluded := c.excludedExtMap[ext]
-	return excluded
+	return false
 }
 
 // IsLikelyTextFile 检查文件是否可能是文本文件
 func (c *Config) IsLikelyTextFile(filePath string) bool {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
 	ext := filepath.Ext(filePath)
 	if _, ok := c.textExtMap[ext]; ok {
 		return true
@@ -153,27 +512,229 @@ func (c *Config) IsLikelyTextFile(filePath string) bool {
 	return false
 }
 
+// contentSniffLen 内容检测读取的前缀字节数，与 http.DetectContentType 使用的长度一致
+const contentSniffLen = 512
+
+// IsContentSniffingEnabled 是否对未识别扩展名的文件做内容检测
+func (c *Config) IsContentSniffingEnabled() bool {
+	return !c.FileFilters.DisableContentSniffing
+}
+
+// LooksLikeText 根据内容前缀判断是否为文本：MIME 检测为文本且前缀是合法的 UTF-8
+// （允许末尾被截断的多字节字符）
+func (c *Config) LooksLikeText(content []byte) bool {
+	prefix := content
+	if len(prefix) > contentSniffLen {
+		prefix = prefix[:contentSniffLen]
+	}
+	contentType := http.DetectContentType(prefix)
+	if !strings.HasPrefix(contentType, "text/") && !c.IsTextContentTypeException(contentType) {
+		return false
+	}
+	if len(content) > contentSniffLen {
+		// 去掉末尾不完整的字符，最多回退 utf8.UTFMax-1 个字节
+		for i := 0; i < utf8.UTFMax-1 && len(prefix) > 0 && !utf8.Valid(prefix); i++ {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return utf8.Valid(prefix)
+}
+
+// DetectTextContent 根据实际内容判断文件是否为文本，返回检测到的 MIME 类型。MIME 类型为 text/*
+// 或配置的例外类型时才视为文本；扩展名只作为提示：已识别扩展名的文件允许非 UTF-8 编码（处理时转换），
+// 未识别扩展名的文件还要求内容前缀是合法的 UTF-8。extraText 为 true 表示扩展名在请求额外指定的文本扩展名中，
+// 按已识别扩展名处理。ZIP 和 GitHub 等来源都以此作为最终判断
+func (c *Config) DetectTextContent(filePath string, content []byte, extraText bool) (string, bool) {
+	contentType := http.DetectContentType(content)
+	if !strings.HasPrefix(contentType, "text/") && !c.IsTextContentTypeException(contentType) {
+		return contentType, false
+	}
+	if extraText || c.IsLikelyTextFile(filePath) {
+		return contentType, true
+	}
+	return contentType, c.LooksLikeText(content)
+}
+
 // IsTextContentTypeException 检查MIME类型是否为文本类型的例外
 func (c *Config) IsTextContentTypeException(contentType string) bool {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
 	_, isException := c.textMimeMap[contentType]
 	return isException
 }
 
+// GetIgnoreFiles 返回默认应用的忽略文件名列表
+func (c *Config) GetIgnoreFiles() []string {
+	return c.FileFilters.IgnoreFiles
+}
+
+// defaultImportantFiles 默认的重要文件名列表
+var defaultImportantFiles = []string{
+	"README.md",
+	"README",
+	"README.txt",
+	"LICENSE",
+	"CONTRIBUTING.md",
+	"go.mod",
+	"package.json",
+	"requirements.txt",
+	"Cargo.toml",
+	"Dockerfile",
+}
+
+// GetImportantFiles 返回重要文件名列表，未配置时使用默认值
+func (c *Config) GetImportantFiles() []string {
+	if len(c.FileFilters.ImportantFiles) == 0 {
+		return defaultImportantFiles
+	}
+	return c.FileFilters.ImportantFiles
+}
+
+// IsImportantFile 检查文件名是否在重要文件列表中
+func (c *Config) IsImportantFile(filePath string) bool {
+	filename := filepath.Base(filePath)
+	for _, name := range c.GetImportantFiles() {
+		if name == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTestFile 检查文件路径是否匹配测试文件模式
+func (c *Config) IsTestFile(filePath string) bool {
+	return matchPathPatterns(filePath, c.FileFilters.TestPatterns)
+}
+
+// IsGeneratedFile 检查文件路径是否匹配生成代码模式
+func (c *Config) IsGeneratedFile(filePath string) bool {
+	return matchPathPatterns(filePath, c.FileFilters.GeneratedPatterns)
+}
+
+// matchPathPatterns 检查路径是否匹配任一模式：
+// 以 "/" 结尾的模式匹配任意一级目录名，其余模式匹配文件名
+func matchPathPatterns(filePath string, patterns []string) bool {
+	normalizedPath := filepath.ToSlash(filePath)
+	baseName := path.Base(normalizedPath)
+	dirs := strings.Split(path.Dir(normalizedPath), "/")
+
+	for _, pattern := range patterns {
+		if dirPattern, isDir := strings.CutSuffix(pattern, "/"); isDir {
+			for _, dir := range dirs {
+				if matched, _ := path.Match(dirPattern, dir); matched {
+					return true
+				}
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, baseName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// LanguageForPath 根据文件路径返回语言名称，未知时返回空字符串
+func (c *Config) LanguageForPath(filePath string) string {
+	// 优先匹配完整文件名（如 Dockerfile、Makefile）
+	if lang, ok := c.languageMap[filepath.Base(filePath)]; ok {
+		return lang
+	}
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if ext == "" {
+		return ""
+	}
+	return c.languageMap[ext]
+}
+
 // GetMaxUploadSize 返回最大上传大小
 func (c *Config) GetMaxUploadSize() int64 {
 	return c.FileLimits.MaxUploadSize
 }
 
+// GetMaxRequestSize 返回请求体最大字节数，未配置时为最大上传大小加 1MB（预留给表单字段和 multipart 边界）
+func (c *Config) GetMaxRequestSize() int64 {
+	if c.FileLimits.MaxRequestSize <= 0 {
+		return c.FileLimits.MaxUploadSize + 1024*1024
+	}
+	return c.FileLimits.MaxRequestSize
+}
+
 // GetMaxFileSize 返回最大文件大小
 func (c *Config) GetMaxFileSize() int64 {
 	return c.FileLimits.MaxFileSize
 }
 
+// GetMaxBinaryBytes 返回保留二进制文件时单个文件的最大字节数，默认 64KB
+func (c *Config) GetMaxBinaryBytes() int64 {
+	if c.FileLimits.MaxBinaryBytes <= 0 {
+		return 64 * 1024
+	}
+	return c.FileLimits.MaxBinaryBytes
+}
+
+// GetMaxExtractedSize 返回解压 tar 归档时读取的最大字节数，未配置时为最大上传大小的 4 倍
+func (c *Config) GetMaxExtractedSize() int64 {
+	if c.FileLimits.MaxExtractedSize <= 0 {
+		return c.FileLimits.MaxUploadSize * 4
+	}
+	return c.FileLimits.MaxExtractedSize
+}
+
+// GetMaxTreeNodes 返回文件树的最大节点数，默认 100000
+func (c *Config) GetMaxTreeNodes() int {
+	if c.FileLimits.MaxTreeNodes <= 0 {
+		return 100000
+	}
+	return c.FileLimits.MaxTreeNodes
+}
+
 // GetOutputFilename 返回输出文件名
 func (c *Config) GetOutputFilename() string {
 	return c.Output.Filename
 }
 
+// GetTreeMaxDepth 返回文件树最大渲染深度，0 表示不限制
+func (c *Config) GetTreeMaxDepth() int {
+	if c.Output.TreeMaxDepth < 0 {
+		return 0
+	}
+	return c.Output.TreeMaxDepth
+}
+
+// GetOutputTreeHeader 返回文本输出中文件树之前的分隔内容
+func (c *Config) GetOutputTreeHeader() string {
+	if c.Output.TreeHeader == "" {
+		return "文件结构:\n"
+	}
+	return c.Output.TreeHeader
+}
+
+// GetOutputContentHeader 返回文本输出中文件树与文件内容之间的分隔内容
+func (c *Config) GetOutputContentHeader() string {
+	if c.Output.ContentHeader == "" {
+		return "\n文件内容:\n"
+	}
+	return c.Output.ContentHeader
+}
+
+// GetOutputFileHeader 返回每个文件内容之前的标题模板
+func (c *Config) GetOutputFileHeader() string {
+	if c.Output.FileHeader == "" {
+		return "\n=== {path} ===\n"
+	}
+	return c.Output.FileHeader
+}
+
+// GetOutputFileFooter 返回每个文件内容之后的分隔内容
+func (c *Config) GetOutputFileFooter() string {
+	if c.Output.FileFooter == "" {
+		return "\n"
+	}
+	return c.Output.FileFooter
+}
+
 // GetReadBufferSize 返回读取缓冲区大小
 func (c *Config) GetReadBufferSize() int {
 	return c.FileLimits.ReadBufferSize
@@ -189,11 +750,135 @@ func (c *Config) GetGithubAPIKey() string {
 	return c.ApiKeys.Github
 }
 
+// GetGithubEnterpriseHosts 返回允许的 GitHub Enterprise 主机名
+func (c *Config) GetGithubEnterpriseHosts() []string {
+	return c.Github.EnterpriseHosts
+}
+
+// IsGithubGraphQLEnabled 返回是否使用 GraphQL 批量获取文件内容（仍需提供访问令牌）
+func (c *Config) IsGithubGraphQLEnabled() bool {
+	return !c.Github.DisableGraphQL
+}
+
+// GetGithubGraphQLBatchSize 返回每个 GraphQL 查询获取的文件数，默认 50
+func (c *Config) GetGithubGraphQLBatchSize() int {
+	if c.Github.GraphQLBatchSize <= 0 {
+		return 50
+	}
+	return c.Github.GraphQLBatchSize
+}
+
+// IsGithubFollowSubmodules 返回默认是否获取 GitHub 子模块内容
+func (c *Config) IsGithubFollowSubmodules() bool {
+	return c.Github.FollowSubmodules
+}
+
+// GetGithubSubmoduleMaxDepth 返回子模块最大嵌套深度，默认 1
+func (c *Config) GetGithubSubmoduleMaxDepth() int {
+	if c.Github.SubmoduleMaxDepth <= 0 {
+		return 1
+	}
+	return c.Github.SubmoduleMaxDepth
+}
+
+// GetGithubLastModifiedMaxFiles 返回最多查询最近提交的文件数，默认 20
+func (c *Config) GetGithubLastModifiedMaxFiles() int {
+	if c.Github.LastModifiedMaxFiles <= 0 {
+		return 20
+	}
+	return c.Github.LastModifiedMaxFiles
+}
+
+// GetGithubMaxAPIRequests 返回获取一个仓库最多发出的 GitHub API 请求数，0 表示不限制
+func (c *Config) GetGithubMaxAPIRequests() int {
+	if c.Github.MaxAPIRequests < 0 {
+		return 0
+	}
+	return c.Github.MaxAPIRequests
+}
+
+// GetGithubDefaultBranches 返回无法获取仓库默认分支时依次尝试的分支，未配置时为 main、master
+func (c *Config) GetGithubDefaultBranches() []string {
+	var branches []string
+	for _, branch := range c.Github.DefaultBranches {
+		if branch = strings.TrimSpace(branch); branch != "" {
+			branches = append(branches, branch)
+		}
+	}
+	if len(branches) == 0 {
+		return []string{"main", "master"}
+	}
+	return branches
+}
+
+// defaultPriorityExtensions 未配置 github.priority_extensions 时优先获取的文件扩展名
+var defaultPriorityExtensions = []string{".md", ".markdown", ".txt", ".go", ".py", ".js", ".ts", ".java", ".c", ".cpp", ".h"}
+
+// GetGithubPriorityExtensions 返回优先获取的文件扩展名集合（小写，以 "." 开头），未配置时使用默认列表
+func (c *Config) GetGithubPriorityExtensions() map[string]bool {
+	exts := c.Github.PriorityExtensions
+	if len(exts) == 0 {
+		exts = defaultPriorityExtensions
+	}
+	set := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		set[ext] = true
+	}
+	return set
+}
+
+// IsRemoteURLAllowed 检查远程 ZIP 的 URL 协议和主机是否在允许列表中，未配置主机时拒绝所有 URL。
+// 主机为 "*.example.com" 时匹配其任意子域名，其他以 "*" 开头的写法不匹配任何主机
+func (c *Config) IsRemoteURLAllowed(u *url.URL) bool {
+	schemes := c.RemoteZip.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"https"}
+	}
+	schemeAllowed := false
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			schemeAllowed = true
+			break
+		}
+	}
+	if !schemeAllowed {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range c.RemoteZip.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if strings.HasPrefix(allowed, "*") {
+			// 通配只支持 "*.example.com"，匹配其子域名（不含 example.com 本身），
+			// 按 "." 边界比较，避免 "*example.com" 之类的写法匹配 evilexample.com
+			suffix, ok := strings.CutPrefix(allowed, "*.")
+			if ok && suffix != "" && strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRemoteZipTimeout 返回远程 ZIP 下载超时时间
+func (c *Config) GetRemoteZipTimeout() time.Duration {
+	if c.RemoteZip.TimeoutSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.RemoteZip.TimeoutSeconds) * time.Second
+}
+
 // GetGeminiAPIKey 返回 Gemini API 密钥
 func (c *Config) GetGeminiAPIKey() string {
-	if envKey := os.Getenv("GEMINI_API_KEY"); envKey != "" {
-		return envKey
-	}
 	return c.ApiKeys.Gemini
 }
 
@@ -220,6 +905,83 @@ func (c *Config) GetGeminiProxyURL() string {
 	return c.Gemini.ProxyURL
 }
 
+// GetDeepseekProxyURL 返回 DeepSeek 代理 URL，环境变量 DEEPSEEK_PROXY 优先于配置文件，均为空时使用系统代理
+func (c *Config) GetDeepseekProxyURL() string {
+	if envProxy := os.Getenv("DEEPSEEK_PROXY"); envProxy != "" {
+		return envProxy
+	}
+	return c.Deepseek.ProxyURL
+}
+
+// GetAIProvider 返回代码问答使用的模型提供方，默认 gemini
+func (c *Config) GetAIProvider() string {
+	if c.AI.Provider == "" {
+		return "gemini"
+	}
+	return strings.ToLower(c.AI.Provider)
+}
+
+// GetAnthropicAPIKey 返回 Anthropic API 密钥
+func (c *Config) GetAnthropicAPIKey() string {
+	return c.ApiKeys.Anthropic
+}
+
+// GetAnthropicApiEndpoint 返回 Anthropic API 根地址
+func (c *Config) GetAnthropicApiEndpoint() string {
+	if c.Anthropic.ApiEndpoint == "" {
+		return "https://api.anthropic.com/v1"
+	}
+	return strings.TrimSuffix(c.Anthropic.ApiEndpoint, "/")
+}
+
+// GetAnthropicModel 返回使用的 Anthropic 模型
+func (c *Config) GetAnthropicModel() string {
+	if c.Anthropic.Model == "" {
+		return "claude-3-5-sonnet-latest"
+	}
+	return c.Anthropic.Model
+}
+
+// GetAnthropicMaxTokens 返回单次回答的最大输出 token 数，默认 8192
+func (c *Config) GetAnthropicMaxTokens() int {
+	if c.Anthropic.MaxTokens <= 0 {
+		return 8192
+	}
+	return c.Anthropic.MaxTokens
+}
+
+// GetAnthropicProxyURL 返回 Anthropic 代理 URL，环境变量 ANTHROPIC_PROXY 优先于配置文件，均为空时使用系统代理
+func (c *Config) GetAnthropicProxyURL() string {
+	if envProxy := os.Getenv("ANTHROPIC_PROXY"); envProxy != "" {
+		return envProxy
+	}
+	return c.Anthropic.ProxyURL
+}
+
+// GetGeminiMaxRetries 返回普通 Gemini 请求的最大尝试次数
+func (c *Config) GetGeminiMaxRetries() int {
+	if c.Gemini.MaxRetries <= 0 {
+		return 3
+	}
+	return c.Gemini.MaxRetries
+}
+
+// GetGeminiStreamMaxRetries 返回流式 Gemini 请求的最大尝试次数
+func (c *Config) GetGeminiStreamMaxRetries() int {
+	if c.Gemini.StreamMaxRetries <= 0 {
+		return 2
+	}
+	return c.Gemini.StreamMaxRetries
+}
+
+// GetGeminiRetryDelay 返回首次重试前的等待时间
+func (c *Config) GetGeminiRetryDelay() time.Duration {
+	if c.Gemini.RetryDelaySeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(c.Gemini.RetryDelaySeconds) * time.Second
+}
+
 // GetGeminiModel 返回使用的 Gemini 模型
 func (c *Config) GetGeminiModel() string {
 	if c.Gemini.Model == "" {
@@ -228,6 +990,237 @@ func (c *Config) GetGeminiModel() string {
 	return c.Gemini.Model
 }
 
+// GetMaxDocuments 返回架构分析最多收集的文档数，默认 10
+func (c *Config) GetMaxDocuments() int {
+	if c.Analysis.MaxDocuments <= 0 {
+		return 10
+	}
+	return c.Analysis.MaxDocuments
+}
+
+// GetMaxDocumentsPerType 返回每种非文档类型最多收集的文件数，默认 1
+func (c *Config) GetMaxDocumentsPerType() int {
+	if c.Analysis.MaxDocumentsPerType <= 0 {
+		return 1
+	}
+	return c.Analysis.MaxDocumentsPerType
+}
+
+// GetMaxDocFilesPerType 返回每种文档扩展名最多收集的文件数，默认 4
+func (c *Config) GetMaxDocFilesPerType() int {
+	if c.Analysis.MaxDocFilesPerType <= 0 {
+		return 4
+	}
+	return c.Analysis.MaxDocFilesPerType
+}
+
+// GetMaxQuestionBytes 返回单个问题的最大字节数，0 或负数时使用默认值 16KB
+func (c *Config) GetMaxQuestionBytes() int {
+	if c.AI.MaxQuestionBytes <= 0 {
+		return 16 * 1024
+	}
+	return c.AI.MaxQuestionBytes
+}
+
+// GetMaxPromptChars 返回提示词最大字符数，0 或负数时使用默认值 400000
+func (c *Config) GetMaxPromptChars() int {
+	if c.AI.MaxPromptChars <= 0 {
+		return 400000
+	}
+	return c.AI.MaxPromptChars
+}
+
+// IsSummarizeLargeFilesEnabled 检查是否用摘要代替上下文中被截断的大文件
+func (c *Config) IsSummarizeLargeFilesEnabled() bool {
+	return c.AI.SummarizeLargeFiles
+}
+
+// GetSessionTokenBudget 返回单个会话累计可消耗的 token 数，0 或负数表示不限制
+func (c *Config) GetSessionTokenBudget() int {
+	if c.AI.SessionTokenBudget < 0 {
+		return 0
+	}
+	return c.AI.SessionTokenBudget
+}
+
+// GetAIMaxConcurrentRequests 返回同时进行的 AI 请求数上限，0 或负数表示不限制
+func (c *Config) GetAIMaxConcurrentRequests() int {
+	if c.AI.MaxConcurrentRequests < 0 {
+		return 0
+	}
+	return c.AI.MaxConcurrentRequests
+}
+
+// GetAIQueueTimeout 返回 AI 请求排队等待的最长时间，0 或负数时使用默认值 30 秒
+func (c *Config) GetAIQueueTimeout() time.Duration {
+	if c.AI.QueueTimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.AI.QueueTimeoutSeconds) * time.Second
+}
+
+// GetAIRetryAfterSeconds 返回 AI 请求排队超时时 Retry-After 的秒数，0 或负数时使用默认值 5
+func (c *Config) GetAIRetryAfterSeconds() int {
+	if c.AI.RetryAfterSeconds <= 0 {
+		return 5
+	}
+	return c.AI.RetryAfterSeconds
+}
+
+// GetPromptTemplateFile 返回代码问答提示词模板文件路径，为空表示使用内置布局
+func (c *Config) GetPromptTemplateFile() string {
+	return c.AI.PromptTemplateFile
+}
+
+// GetMaxSessions 返回最大会话数量，0 或负数时使用默认值 1000
+func (c *Config) GetMaxSessions() int {
+	if c.Sessions.MaxSessions <= 0 {
+		return 1000
+	}
+	return c.Sessions.MaxSessions
+}
+
+// GetSSEKeepAliveInterval 返回 SSE 保活间隔，0 表示禁用
+func (c *Config) GetSSEKeepAliveInterval() time.Duration {
+	if c.SSE.KeepAliveSeconds < 0 {
+		return 0
+	}
+	if c.SSE.KeepAliveSeconds == 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(c.SSE.KeepAliveSeconds) * time.Second
+}
+
+// GetSSEFlushChars 返回流式回答合并数据块的默认字符数阈值，0 表示不按字符数合并
+func (c *Config) GetSSEFlushChars() int {
+	if c.SSE.FlushChars < 0 {
+		return 0
+	}
+	return c.SSE.FlushChars
+}
+
+// IsSSEMarkdownSafe 检查流式回答是否默认按 Markdown 语法边界切分数据块
+func (c *Config) IsSSEMarkdownSafe() bool {
+	return c.SSE.MarkdownSafe
+}
+
+// GetSSEFlushInterval 返回流式回答合并数据块的默认发送间隔，0 表示不按时间合并
+func (c *Config) GetSSEFlushInterval() time.Duration {
+	if c.SSE.FlushIntervalMs < 0 {
+		return 0
+	}
+	return time.Duration(c.SSE.FlushIntervalMs) * time.Millisecond
+}
+
+// GetHTTPMaxIdleConns 返回所有主机的最大空闲连接数，0 表示使用默认值
+func (c *Config) GetHTTPMaxIdleConns() int {
+	return c.HTTPClient.MaxIdleConns
+}
+
+// GetHTTPMaxIdleConnsPerHost 返回每个主机的最大空闲连接数，0 表示使用默认值
+func (c *Config) GetHTTPMaxIdleConnsPerHost() int {
+	return c.HTTPClient.MaxIdleConnsPerHost
+}
+
+// GetHTTPIdleConnTimeout 返回空闲连接保留时间，0 表示使用默认值
+func (c *Config) GetHTTPIdleConnTimeout() time.Duration {
+	return time.Duration(c.HTTPClient.IdleConnTimeoutSeconds) * time.Second
+}
+
+// GetHTTPDialTimeout 返回建立连接超时，0 表示使用默认值
+func (c *Config) GetHTTPDialTimeout() time.Duration {
+	return time.Duration(c.HTTPClient.DialTimeoutSeconds) * time.Second
+}
+
+// GetHTTPTLSHandshakeTimeout 返回 TLS 握手超时，0 表示使用默认值
+func (c *Config) GetHTTPTLSHandshakeTimeout() time.Duration {
+	return time.Duration(c.HTTPClient.TLSHandshakeTimeoutSeconds) * time.Second
+}
+
+// GetHTTPResponseHeaderTimeout 返回等待响应头超时，0 表示使用默认值
+func (c *Config) GetHTTPResponseHeaderTimeout() time.Duration {
+	return time.Duration(c.HTTPClient.ResponseHeaderTimeoutSeconds) * time.Second
+}
+
+// GetIdempotencyTTL 返回幂等键缓存响应的有效期，0 或负数时使用默认值 10 分钟
+func (c *Config) GetIdempotencyTTL() time.Duration {
+	if c.Idempotency.TTLSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.Idempotency.TTLSeconds) * time.Second
+}
+
+// GetIdempotencyMaxEntries 返回最多缓存的幂等响应数，0 或负数时使用默认值 100
+func (c *Config) GetIdempotencyMaxEntries() int {
+	if c.Idempotency.MaxEntries <= 0 {
+		return 100
+	}
+	return c.Idempotency.MaxEntries
+}
+
+// GetIdempotencyMaxBodyBytes 返回单个响应体的最大缓存字节数，0 或负数时使用默认值 4MB
+func (c *Config) GetIdempotencyMaxBodyBytes() int64 {
+	if c.Idempotency.MaxBodyBytes <= 0 {
+		return 4 << 20
+	}
+	return c.Idempotency.MaxBodyBytes
+}
+
+// IsEmbeddingsEnabled 检查是否启用向量检索
+func (c *Config) IsEmbeddingsEnabled() bool {
+	return c.Embeddings.Enabled
+}
+
+// GetEmbeddingsApiEndpoint 返回向量接口地址
+func (c *Config) GetEmbeddingsApiEndpoint() string {
+	if c.Embeddings.ApiEndpoint == "" {
+		return c.GetGeminiApiEndpoint()
+	}
+	return c.Embeddings.ApiEndpoint
+}
+
+// GetEmbeddingsModel 返回向量模型名称
+func (c *Config) GetEmbeddingsModel() string {
+	if c.Embeddings.Model == "" {
+		return "text-embedding-004"
+	}
+	return c.Embeddings.Model
+}
+
+// GetEmbeddingsTopK 返回每个问题选取的相关文件数
+func (c *Config) GetEmbeddingsTopK() int {
+	if c.Embeddings.TopK <= 0 {
+		return 10
+	}
+	return c.Embeddings.TopK
+}
+
+// GetEmbeddingsMaxChars 返回每个文件参与向量计算的最大字符数
+func (c *Config) GetEmbeddingsMaxChars() int {
+	if c.Embeddings.MaxChars <= 0 {
+		return 8000
+	}
+	return c.Embeddings.MaxChars
+}
+
+// IsDefaultGeneratePrompt 返回请求未指定 generate_prompt 时是否生成项目架构分析
+func (c *Config) IsDefaultGeneratePrompt() bool {
+	return c.Defaults.GeneratePrompt
+}
+
+// GetDefaultFormat 返回请求未指定 format 时的输出格式，默认 text
+func (c *Config) GetDefaultFormat() string {
+	if c.Defaults.Format == "" {
+		return "text"
+	}
+	return c.Defaults.Format
+}
+
+// IsDefaultBase64 返回请求未指定 base64 时是否以 Base64 返回文件内容
+func (c *Config) IsDefaultBase64() bool {
+	return c.Defaults.Base64
+}
+
 // GetLogLevel 返回日志级别
 func (c *Config) GetLogLevel() string {
 	if c.Logging.Level == "" {
@@ -236,6 +1229,22 @@ func (c *Config) GetLogLevel() string {
 	return c.Logging.Level
 }
 
+// GetLogConsoleLevel 返回控制台日志级别，未配置时使用 logging.level
+func (c *Config) GetLogConsoleLevel() string {
+	if c.Logging.ConsoleLevel == "" {
+		return c.GetLogLevel()
+	}
+	return c.Logging.ConsoleLevel
+}
+
+// GetLogFileLevel 返回文件日志级别，未配置时使用 logging.level
+func (c *Config) GetLogFileLevel() string {
+	if c.Logging.FileLevel == "" {
+		return c.GetLogLevel()
+	}
+	return c.Logging.FileLevel
+}
+
 // GetLogOutputPath 返回日志输出路径
 func (c *Config) GetLogOutputPath() string {
 	if c.Logging.OutputPath == "" {
@@ -243,3 +1252,40 @@ func (c *Config) GetLogOutputPath() string {
 	}
 	return c.Logging.OutputPath
 }
+
+// GetLogMaxSizeMB 返回日志文件轮转前的最大大小（MB），默认 100
+func (c *Config) GetLogMaxSizeMB() int {
+	if c.Logging.MaxSizeMB <= 0 {
+		return 100
+	}
+	return c.Logging.MaxSizeMB
+}
+
+// GetLogMaxBackups 返回每个日志文件保留的备份数量，0 表示全部保留
+func (c *Config) GetLogMaxBackups() int {
+	if c.Logging.MaxBackups < 0 {
+		return 0
+	}
+	return c.Logging.MaxBackups
+}
+
+// GetLogMaxAgeDays 返回日志备份的最长保留天数，0 表示不按时间删除
+func (c *Config) GetLogMaxAgeDays() int {
+	if c.Logging.MaxAgeDays < 0 {
+		return 0
+	}
+	return c.Logging.MaxAgeDays
+}
+
+// IsAuditEnabled 返回是否启用审计日志
+func (c *Config) IsAuditEnabled() bool {
+	return c.Audit.Enabled
+}
+
+// GetAuditFilePath 返回审计日志文件路径，默认 ./logs/audit.log
+func (c *Config) GetAuditFilePath() string {
+	if c.Audit.FilePath == "" {
+		return "./logs/audit.log"
+	}
+	return c.Audit.FilePath
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestIsRemoteURLAllowed(t *testing.T) {
+	cfg := &Config{}
+	cfg.RemoteZip.AllowedHosts = []string{"files.example.org", "*.example.com", "*example.net", "*."}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://files.example.org/a.zip", true},
+		{"https://FILES.example.org/a.zip", true},
+		{"https://other.example.org/a.zip", false},
+		{"https://bucket.example.com/a.zip", true},
+		{"https://a.b.example.com/a.zip", true},
+		{"https://example.com/a.zip", false},
+		{"https://evilexample.com/a.zip", false},
+		{"https://example.com.evil.org/a.zip", false},
+		// 缺少 "." 的通配写法无效
+		{"https://example.net/a.zip", false},
+		{"https://evilexample.net/a.zip", false},
+		{"https://a.example.net/a.zip", false},
+		// 默认只允许 https
+		{"http://bucket.example.com/a.zip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.url, err)
+			}
+			if got := cfg.IsRemoteURLAllowed(u); got != tt.want {
+				t.Errorf("IsRemoteURLAllowed(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRemoteURLAllowedWithoutHosts(t *testing.T) {
+	cfg := &Config{}
+	u, _ := url.Parse("https://example.com/a.zip")
+	if cfg.IsRemoteURLAllowed(u) {
+		t.Errorf("URL allowed with an empty allowlist")
+	}
+}
@@ -0,0 +1,145 @@
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"repo-prompt-web/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// PoolOptions 传输层的连接池和超时配置，零值字段使用默认值
+type PoolOptions struct {
+	MaxIdleConns          int           // 所有主机的最大空闲连接数，默认 100
+	MaxIdleConnsPerHost   int           // 每个主机的最大空闲连接数，默认 10
+	IdleConnTimeout       time.Duration // 空闲连接保留时间，默认 90 秒
+	DialTimeout           time.Duration // 建立连接超时，默认 30 秒
+	TLSHandshakeTimeout   time.Duration // TLS 握手超时，默认 30 秒
+	ResponseHeaderTimeout time.Duration // 等待响应头超时，默认 60 秒
+}
+
+// withDefaults 为未设置的配置填充默认值
+func (o PoolOptions) withDefaults() PoolOptions {
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = 100
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = 10
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = 90 * time.Second
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 30 * time.Second
+	}
+	if o.TLSHandshakeTimeout <= 0 {
+		o.TLSHandshakeTimeout = 30 * time.Second
+	}
+	if o.ResponseHeaderTimeout <= 0 {
+		o.ResponseHeaderTimeout = 60 * time.Second
+	}
+	return o
+}
+
+// NoResponseHeaderTimeout 用作 Options.ResponseHeaderTimeout 时不限制等待响应头的时间
+const NoResponseHeaderTimeout time.Duration = -1
+
+// Options 单个客户端的配置
+type Options struct {
+	Name     string        // 调用方名称（如 Gemini、DeepSeek、GitHub），仅用于日志
+	Timeout  time.Duration // 整个请求（包括读取响应体）的超时，0 表示不限制
+	ProxyURL string        // 代理地址，为空或无效时使用系统环境变量中的代理
+	// ResponseHeaderTimeout 覆盖 PoolOptions 的等待响应头超时，0 使用连接池配置，NoResponseHeaderTimeout 表示不限制。
+	// 非流式的大模型调用在生成结束后才返回响应头，应不限制并只依赖 Timeout
+	ResponseHeaderTimeout time.Duration
+}
+
+// transportKey 区分共享传输层的配置：代理地址和等待响应头超时
+type transportKey struct {
+	proxyURL              string
+	responseHeaderTimeout time.Duration
+}
+
+// Factory 按统一的连接池配置创建 HTTP 客户端。使用相同代理的客户端共享同一个传输层，
+// 以便复用连接，调优只需修改 PoolOptions
+type Factory struct {
+	pool       PoolOptions
+	transports map[transportKey]*http.Transport // 按代理地址（空字符串为系统代理）和等待响应头超时区分
+	mu         sync.Mutex
+}
+
+// NewFactory 创建 HTTP 客户端工厂
+func NewFactory(pool PoolOptions) *Factory {
+	return &Factory{
+		pool:       pool.withDefaults(),
+		transports: make(map[transportKey]*http.Transport),
+	}
+}
+
+// Client 创建使用共享传输层的客户端。返回的客户端可以单独设置 CheckRedirect 等字段，不影响其他客户端
+func (f *Factory) Client(opts Options) *http.Client {
+	return &http.Client{
+		Transport: f.transport(opts),
+		Timeout:   opts.Timeout,
+	}
+}
+
+// transport 返回与 opts 的代理和等待响应头超时对应的共享传输层，首次使用时创建
+func (f *Factory) transport(opts Options) *http.Transport {
+	headerTimeout := f.pool.ResponseHeaderTimeout
+	switch {
+	case opts.ResponseHeaderTimeout == NoResponseHeaderTimeout:
+		headerTimeout = 0
+	case opts.ResponseHeaderTimeout > 0:
+		headerTimeout = opts.ResponseHeaderTimeout
+	}
+	key := transportKey{proxyURL: opts.ProxyURL, responseHeaderTimeout: headerTimeout}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if transport, exists := f.transports[key]; exists {
+		return transport
+	}
+
+	transport := &http.Transport{
+		Proxy: proxyFunc(opts.Name, opts.ProxyURL),
+		DialContext: (&net.Dialer{
+			Timeout:   f.pool.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          f.pool.MaxIdleConns,
+		MaxIdleConnsPerHost:   f.pool.MaxIdleConnsPerHost,
+		IdleConnTimeout:       f.pool.IdleConnTimeout,
+		TLSHandshakeTimeout:   f.pool.TLSHandshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: headerTimeout,
+		ForceAttemptHTTP2:     true,
+	}
+	f.transports[key] = transport
+	return transport
+}
+
+// proxyFunc 返回传输层使用的代理函数：proxyURL 非空时使用该代理，为空或无效时回退到系统环境变量中的代理
+func proxyFunc(name, proxyURL string) func(*http.Request) (*url.URL, error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	proxy, err := url.Parse(proxyURL)
+	if err != nil {
+		logger.Warn("无效的代理URL配置，将使用系统代理",
+			zap.String("client", name),
+			zap.String("proxy_url", proxyURL),
+			zap.Error(err))
+		return http.ProxyFromEnvironment
+	}
+	logger.Info("使用配置的API代理",
+		zap.String("client", name),
+		zap.String("proxy_url", proxyURL))
+	return http.ProxyURL(proxy)
+}
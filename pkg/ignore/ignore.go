@@ -0,0 +1,157 @@
+// Package ignore 实现 gitignore 风格的忽略规则匹配，供 .gitignore、.dockerignore
+// 以及项目自定义的忽略文件共用
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rule 表示忽略文件中的一条规则
+type rule struct {
+	base     string // 忽略文件所在目录（相对项目根目录，"/" 分隔，根目录为空）
+	pattern  string // 去掉前后 "/" 及 "!" 后的模式
+	negate   bool   // "!" 开头：重新包含
+	dirOnly  bool   // "/" 结尾：只匹配目录
+	anchored bool   // 模式包含 "/"：相对 base 匹配完整路径，否则只匹配名称
+}
+
+// Matcher 按 gitignore 语义匹配路径：后出现的规则优先，父目录被忽略时其下所有内容均被忽略
+type Matcher struct {
+	rules []rule
+}
+
+// New 创建空的匹配器
+func New() *Matcher {
+	return &Matcher{}
+}
+
+// Empty 返回是否没有任何规则
+func (m *Matcher) Empty() bool {
+	return len(m.rules) == 0
+}
+
+// Add 解析忽略文件内容并加入规则，base 为该文件所在目录相对项目根目录的路径
+func (m *Matcher) Add(base string, content []byte) {
+	base = strings.Trim(filepath.ToSlash(base), "/")
+	if base == "." {
+		base = ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r := rule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		r.pattern = line
+		m.rules = append(m.rules, r)
+	}
+}
+
+// AddFromDir 读取 dir 目录下名为 names 的忽略文件并加入规则，base 为 dir 相对项目根目录的路径。
+// 文件不存在或无法读取时忽略
+func (m *Matcher) AddFromDir(dir, base string, names []string) {
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		m.Add(base, content)
+	}
+}
+
+// Match 判断相对项目根目录的路径是否被忽略
+func (m *Matcher) Match(filePath string, isDir bool) bool {
+	if len(m.rules) == 0 {
+		return false
+	}
+
+	filePath = strings.Trim(filepath.ToSlash(filePath), "/")
+	parts := strings.Split(filePath, "/")
+
+	// 任一父目录被忽略时，其下内容不能再被重新包含
+	for i := 1; i < len(parts); i++ {
+		if m.matchRules(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.matchRules(filePath, isDir)
+}
+
+// matchRules 依次应用所有规则，返回最后一条匹配规则的结果
+func (m *Matcher) matchRules(filePath string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		rel := filePath
+		if r.base != "" {
+			if !strings.HasPrefix(filePath, r.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(filePath, r.base+"/")
+		}
+
+		var matched bool
+		if r.anchored {
+			matched = matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+		} else {
+			matched, _ = path.Match(r.pattern, path.Base(rel))
+		}
+		if matched {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments 逐段匹配路径，"**" 匹配零个或多个目录
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
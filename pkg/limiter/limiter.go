@@ -0,0 +1,57 @@
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBusy 排队超时仍未获得执行名额
+var ErrBusy = errors.New("AI 服务繁忙，请稍后重试")
+
+// Limiter 限制同时进行的上游 AI 请求数量。名额已满时排队等待，超过 queueTimeout 返回 ErrBusy。
+// nil Limiter 不做限制
+type Limiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// New 创建并发限制器，maxConcurrent 为 0 或负数时返回 nil（不限制）
+func New(maxConcurrent int, queueTimeout time.Duration) *Limiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &Limiter{
+		slots:        make(chan struct{}, maxConcurrent),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire 获取一个执行名额，成功后调用方必须调用 Release
+func (l *Limiter) Acquire() error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBusy
+	}
+}
+
+// Release 归还 Acquire 获取的名额
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// auditLogger 审计日志实例，与应用日志分开写入独立文件；未启用时为 nil
+var auditLogger *zap.Logger
+
+// InitAudit 初始化审计日志，以 JSON 行追加写入 filePath。
+// 审计日志不受应用日志级别影响，调用方不得在其中记录文件内容
+func InitAudit(filePath string) error {
+	if dir := filepath.Dir(filePath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("无法创建审计日志目录: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("无法打开审计日志文件: %w", err)
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.LevelKey = zapcore.OmitKey
+	encoderConfig.CallerKey = zapcore.OmitKey
+	encoderConfig.MessageKey = "event"
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(file), zapcore.InfoLevel)
+	auditLogger = zap.New(core)
+	return nil
+}
+
+// Audit 记录一条审计事件，未启用审计日志时不做任何操作
+func Audit(event string, fields ...zap.Field) {
+	if auditLogger != nil {
+		auditLogger.Info(event, fields...)
+	}
+}
+
+// SyncAudit 刷新审计日志缓冲
+func SyncAudit() {
+	if auditLogger != nil {
+		_ = auditLogger.Sync()
+	}
+}
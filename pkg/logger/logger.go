@@ -17,23 +17,41 @@ var (
 	once   sync.Once
 )
 
+// Options 日志系统配置
+type Options struct {
+	ConsoleLevel string // 控制台输出的最低级别: debug, info, warn, error
+	FileLevel    string // app.log 的最低级别，error.log 固定只记录错误及以上级别
+	OutputPath   string // 日志文件目录，为空时只输出到控制台
+	MaxSizeMB    int    // app.log 和 error.log 单个文件的最大大小（MB），超过时轮转，0 表示不轮转
+	MaxBackups   int    // 每个日志文件保留的备份数量，0 表示不按数量删除
+	MaxAgeDays   int    // 备份的最长保留天数，0 表示不按时间删除
+}
+
+// openLogFile 打开按配置轮转的日志文件
+func (o Options) openLogFile(path string) (*rotatingFile, error) {
+	return openRotatingFile(path, int64(o.MaxSizeMB)*1024*1024, o.MaxBackups, time.Duration(o.MaxAgeDays)*24*time.Hour)
+}
+
+// parseLevel 解析日志级别，无法识别时使用 info
+func parseLevel(level string) zapcore.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return zapcore.DebugLevel
+	case "info":
+		return zapcore.InfoLevel
+	case "warn":
+		return zapcore.WarnLevel
+	case "error":
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
 // Init 初始化日志系统
-func Init(level string, outputPath string) {
+func Init(opts Options) {
 	once.Do(func() {
-		// 解析日志级别
-		var logLevel zapcore.Level
-		switch strings.ToLower(level) {
-		case "debug":
-			logLevel = zapcore.DebugLevel
-		case "info":
-			logLevel = zapcore.InfoLevel
-		case "warn":
-			logLevel = zapcore.WarnLevel
-		case "error":
-			logLevel = zapcore.ErrorLevel
-		default:
-			logLevel = zapcore.InfoLevel
-		}
+		outputPath := opts.OutputPath
 
 		// 创建日志目录
 		if outputPath != "" {
@@ -55,7 +73,7 @@ func Init(level string, outputPath string) {
 		consoleCore := zapcore.NewCore(
 			consoleEncoder,
 			zapcore.AddSync(os.Stdout),
-			logLevel,
+			parseLevel(opts.ConsoleLevel),
 		)
 		cores = append(cores, consoleCore)
 
@@ -63,25 +81,25 @@ func Init(level string, outputPath string) {
 		if outputPath != "" {
 			// 常规日志文件
 			logFilePath := filepath.Join(outputPath, "app.log")
-			logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			logFile, err := opts.openLogFile(logFilePath)
 			if err == nil {
 				fileEncoder := zapcore.NewJSONEncoder(encoderConfig)
 				fileCore := zapcore.NewCore(
 					fileEncoder,
-					zapcore.AddSync(logFile),
-					logLevel,
+					logFile,
+					parseLevel(opts.FileLevel),
 				)
 				cores = append(cores, fileCore)
 			}
 
 			// 错误日志文件
 			errorFilePath := filepath.Join(outputPath, "error.log")
-			errorFile, err := os.OpenFile(errorFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			errorFile, err := opts.openLogFile(errorFilePath)
 			if err == nil {
 				fileEncoder := zapcore.NewJSONEncoder(encoderConfig)
 				errorCore := zapcore.NewCore(
 					fileEncoder,
-					zapcore.AddSync(errorFile),
+					errorFile,
 					zapcore.ErrorLevel, // 错误文件只记录错误及以上级别
 				)
 				cores = append(cores, errorCore)
@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat 轮转后备份文件名中的时间格式，按字典序即按时间排序
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile 按大小轮转的日志文件，实现 zapcore.WriteSyncer。
+// 超过 maxSize 时将当前文件重命名为 <name>-<时间><ext> 并创建新文件，
+// 然后删除超出 maxBackups 个或早于 maxAge 的备份
+type rotatingFile struct {
+	path       string
+	maxSize    int64         // 单个文件的最大字节数，0 表示不轮转
+	maxBackups int           // 保留的备份数量，0 表示不按数量删除
+	maxAge     time.Duration // 备份的最长保留时间，0 表示不按时间删除
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openRotatingFile 以追加方式打开日志文件
+func openRotatingFile(path string, maxSize int64, maxBackups int, maxAge time.Duration) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open 打开日志文件并记录当前大小，调用方需持有锁或在初始化时调用
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// Write 写入日志，写入后超过最大大小时先轮转。轮转失败时输出到标准错误并继续写入当前文件，
+// 下次写入时重试轮转
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "日志文件 %s 轮转失败: %v\n", rf.path, err)
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Sync 将缓冲写入磁盘
+func (rf *rotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Sync()
+}
+
+// rotate 重命名当前文件并创建新文件，调用方需持有锁。
+// 新文件打开成功后才关闭旧文件，任一步失败时 rf.file 仍是可写入的文件
+func (rf *rotatingFile) rotate() error {
+	ext := filepath.Ext(rf.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(rf.path, ext), time.Now().Format(backupTimeFormat), ext)
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+
+	old := rf.file
+	if err := rf.open(); err != nil {
+		// 继续写入已重命名的旧文件，日志不丢失
+		return err
+	}
+	_ = old.Close()
+
+	rf.removeOldBackups()
+	return nil
+}
+
+// removeOldBackups 删除超出保留数量或保留时间的备份文件，删除失败时忽略
+func (rf *rotatingFile) removeOldBackups() {
+	if rf.maxBackups <= 0 && rf.maxAge <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(rf.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(rf.path, ext) + "-*" + ext)
+	if err != nil {
+		return
+	}
+	// 文件名中的时间使时间越新的备份排在越后面
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, backup := range backups {
+		remove := rf.maxBackups > 0 && i >= rf.maxBackups
+		if !remove && rf.maxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > rf.maxAge {
+				remove = true
+			}
+		}
+		if remove {
+			_ = os.Remove(backup)
+		}
+	}
+}
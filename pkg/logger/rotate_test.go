@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := openRotatingFile(path, 10, 0, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer rf.file.Close()
+
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "second\n" {
+		t.Errorf("current file = %q, want %q", data, "second\n")
+	}
+	backups, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "app-*.log"))
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want 1", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "first\n" {
+		t.Errorf("backup = %q, want %q", data, "first\n")
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotateFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	rf, err := openRotatingFile(path, 10, 0, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer rf.file.Close()
+
+	if _, err := rf.Write([]byte("first\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// 日志文件被外部删除后重命名失败，轮转无法完成
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	for _, line := range []string{"second\n", "third\n"} {
+		n, err := rf.Write([]byte(line))
+		if err != nil || n != len(line) {
+			t.Fatalf("write %q after failed rotation = %d, %v", line, n, err)
+		}
+	}
+	if err := rf.Sync(); err != nil {
+		t.Errorf("sync after failed rotation: %v", err)
+	}
+
+	// 文件恢复后下次写入重新轮转到新文件
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 10)), 0644); err != nil {
+		t.Fatalf("recreate: %v", err)
+	}
+	if _, err := rf.Write([]byte("fourth\n")); err != nil {
+		t.Fatalf("write after recovery: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "fourth\n" {
+		t.Errorf("current file after recovery = %q, want %q", data, "fourth\n")
+	}
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 进程启动以来的累计计数，重启后清零
+var (
+	startedAt       = time.Now()
+	sessionsCreated atomic.Int64
+	aiCalls         atomic.Int64
+	bytesProcessed  atomic.Int64
+)
+
+// Snapshot 某一时刻的累计统计
+type Snapshot struct {
+	SessionsCreated int64
+	AICalls         int64
+	BytesProcessed  int64
+	StartedAt       string
+	UptimeSeconds   int64
+}
+
+// SessionCreated 记录新建了一个代码会话
+func SessionCreated() {
+	sessionsCreated.Add(1)
+}
+
+// AICall 记录一次上游 AI 生成请求（Gemini 或 DeepSeek，含流式请求）
+func AICall() {
+	aiCalls.Add(1)
+}
+
+// AddBytesProcessed 累加处理的文件内容字节数
+func AddBytesProcessed(n int64) {
+	bytesProcessed.Add(n)
+}
+
+// Get 返回当前的累计统计
+func Get() Snapshot {
+	return Snapshot{
+		SessionsCreated: sessionsCreated.Load(),
+		AICalls:         aiCalls.Load(),
+		BytesProcessed:  bytesProcessed.Load(),
+		StartedAt:       startedAt.UTC().Format(time.RFC3339),
+		UptimeSeconds:   int64(time.Since(startedAt).Seconds()),
+	}
+}
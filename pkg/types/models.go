@@ -2,9 +2,13 @@ package types
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"path/filepath"
 	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // TreeNode represents a node in the file tree
@@ -12,6 +16,19 @@ type TreeNode struct {
 	Name     string               `json:"name"`
 	IsDir    bool                 `json:"is_dir"`
 	Children map[string]*TreeNode `json:"children,omitempty"`
+	// Size and Type are only set when the source reports them, e.g. GitHub's blob, tree or commit entries
+	Size int64  `json:"size,omitempty"`
+	Type string `json:"type,omitempty"`
+	// LinkTarget is the target of a symlink kept via include_symlinks; such nodes have Type "symlink"
+	LinkTarget string `json:"link_target,omitempty"`
+	// Commit is the commit SHA a git submodule is pinned to; such nodes have Type "commit"
+	Commit string `json:"commit,omitempty"`
+	// Truncated marks a root whose AddPath stopped adding nodes after reaching its node limit
+	Truncated bool `json:"truncated,omitempty"`
+
+	// maxNodes and nodeCount are only tracked on roots created via NewRootNode
+	maxNodes  int
+	nodeCount int
 }
 
 // FileContent represents a file's content and metadata
@@ -19,12 +36,63 @@ type FileContent struct {
 	Path     string `json:"path"`
 	Content  string `json:"content"`
 	IsBase64 bool   `json:"is_base64,omitempty"`
+	// IsBinary marks binary files kept as base64 via include_binary
+	IsBinary bool `json:"is_binary,omitempty"`
+	// Size is the decoded file size in bytes
+	Size int64 `json:"size"`
+	// Hash is the hex-encoded SHA-256 of the decoded file content
+	Hash string `json:"sha256,omitempty"`
+	// Truncated marks a preview returned via preview_bytes; FullSize is the length of the untruncated Content
+	Truncated bool  `json:"truncated,omitempty"`
+	FullSize  int64 `json:"full_size,omitempty"`
+	// LastModified (RFC 3339) and LastAuthor describe the latest commit touching the file,
+	// only set for GitHub files fetched with include_last_modified
+	LastModified string `json:"last_modified,omitempty"`
+	LastAuthor   string `json:"last_author,omitempty"`
+	// MixedLineEndings marks text files whose original content mixes CRLF and LF line endings
+	MixedLineEndings bool `json:"mixed_line_endings,omitempty"`
+}
+
+// HashContent returns the hex-encoded SHA-256 of raw file content
+func HashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileWarning describes a file that could not be read or processed
+type FileWarning struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// FileChange describes a file changed between two refs, as reported by GitHub's compare API
+type FileChange struct {
+	Path string `json:"path"`
+	// Status is one of added, modified, removed, renamed, copied, changed
+	Status string `json:"status"`
+	// PreviousPath is the path before a rename or copy
+	PreviousPath string `json:"previous_path,omitempty"`
+	Additions    int    `json:"additions"`
+	Deletions    int    `json:"deletions"`
 }
 
 // ProcessResult represents the result of processing files
 type ProcessResult struct {
 	FileTree     *TreeNode              `json:"file_tree"`
 	FileContents map[string]FileContent `json:"file_contents"`
+	// ContentHash is a SHA-256 over all file paths and their hashes, stable across runs
+	ContentHash string `json:"content_hash,omitempty"`
+	// Warnings is returned as a top-level response field rather than inside the result
+	Warnings []FileWarning `json:"-"`
+	// Changes lists the changed files when the result holds only the diff between two refs;
+	// like Warnings it is returned as a top-level response field
+	Changes []FileChange `json:"-"`
+	// Partial marks a result that stopped fetching early because the request budget ran out;
+	// it is returned as a top-level response field
+	Partial bool `json:"-"`
+	// DocsOnly marks a result fetched for architecture analysis only: FileContents holds just the
+	// documentation files while FileTree still lists every file, so it must not back a Q&A session
+	DocsOnly bool `json:"-"`
 }
 
 // Document represents a documentation file
@@ -39,6 +107,12 @@ type ProjectAnalysis struct {
 	PromptSuggestions []string   `json:"prompt_suggestions"`
 	Documents         []Document `json:"documents,omitempty"`
 	GeneratedAt       string     `json:"generated_at"`
+	// LanguageStats is the total size in bytes of the files recognized for each language
+	LanguageStats map[string]int64 `json:"language_stats,omitempty"`
+	// PrimaryLanguage is the programming language with the most bytes, ignoring markup and data formats
+	PrimaryLanguage string `json:"primary_language,omitempty"`
+	// Frameworks lists frameworks detected from dependency manifests such as go.mod and package.json
+	Frameworks []string `json:"frameworks"`
 }
 
 // NewTreeNode creates a new tree node
@@ -50,8 +124,28 @@ func NewTreeNode(name string, isDir bool) *TreeNode {
 	}
 }
 
+// NewRootNode creates an unnamed root that holds at most maxNodes nodes below it,
+// guarding memory and the recursive Print against archives with huge numbers of paths.
+// A maxNodes of 0 means unlimited.
+func NewRootNode(maxNodes int) *TreeNode {
+	root := NewTreeNode("", false)
+	root.maxNodes = maxNodes
+	return root
+}
+
 // Print recursively prints the file tree
 func (n *TreeNode) Print(buffer *bytes.Buffer, prefix string, isLast bool) {
+	n.PrintDepth(buffer, prefix, isLast, 0)
+}
+
+// PrintDepth prints the file tree like Print, but collapses directories deeper
+// than maxDepth into a "(… N items)" summary. A maxDepth of 0 means unlimited.
+func (n *TreeNode) PrintDepth(buffer *bytes.Buffer, prefix string, isLast bool, maxDepth int) {
+	n.printDepth(buffer, prefix, isLast, 0, maxDepth)
+}
+
+// printDepth prints the node at the given depth, where the unnamed root is depth 0
+func (n *TreeNode) printDepth(buffer *bytes.Buffer, prefix string, isLast bool, depth, maxDepth int) {
 	// Print current node
 	if n.Name != "" {
 		buffer.WriteString(prefix)
@@ -62,7 +156,24 @@ func (n *TreeNode) Print(buffer *bytes.Buffer, prefix string, isLast bool) {
 			buffer.WriteString("├── ")
 			prefix += "│   "
 		}
-		buffer.WriteString(n.Name + "\n")
+		buffer.WriteString(n.Name)
+		if n.LinkTarget != "" {
+			buffer.WriteString(" -> " + n.LinkTarget)
+		}
+		if n.Commit != "" {
+			buffer.WriteString(fmt.Sprintf(" (submodule @ %.7s)", n.Commit))
+		}
+		// Files show their own size, directories the total size of all files below them
+		if size := n.TotalSize(); size > 0 {
+			buffer.WriteString(" (" + FormatSize(size) + ")")
+		}
+
+		// Collapse everything below the depth limit
+		if maxDepth > 0 && depth >= maxDepth && len(n.Children) > 0 {
+			buffer.WriteString(fmt.Sprintf(" (… %d items)\n", n.CountItems()))
+			return
+		}
+		buffer.WriteString("\n")
 	}
 
 	// Get and sort children
@@ -80,14 +191,56 @@ func (n *TreeNode) Print(buffer *bytes.Buffer, prefix string, isLast bool) {
 
 	// Recursively print children
 	for i, child := range children {
-		child.Print(buffer, prefix, i == len(children)-1)
+		// The truncation note below becomes the last line when the tree is truncated
+		child.printDepth(buffer, prefix, i == len(children)-1 && !n.Truncated, depth+1, maxDepth)
+	}
+	if n.Truncated {
+		buffer.WriteString(prefix + "└── (… tree truncated: too many nodes)\n")
+	}
+}
+
+// TotalSize returns the size of a file node, or the sum of all file sizes below a directory.
+// Nodes whose size is unknown count as 0.
+func (n *TreeNode) TotalSize() int64 {
+	if !n.IsDir {
+		return n.Size
+	}
+	var total int64
+	for _, child := range n.Children {
+		total += child.TotalSize()
+	}
+	return total
+}
+
+// FormatSize formats a byte count using binary units, e.g. "512 B" or "1.5 KB"
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// CountItems returns the number of files and directories below the node
+func (n *TreeNode) CountItems() int {
+	count := 0
+	for _, child := range n.Children {
+		count += 1 + child.CountItems()
 	}
+	return count
 }
 
-// AddPath adds a path to the tree
-func (n *TreeNode) AddPath(path string) {
+// AddPath adds a path to the tree and returns the node for its last element.
+// Once the node limit of a root is reached, the root is marked Truncated and a detached
+// node is returned instead, so callers can still set its fields without growing the tree.
+func (n *TreeNode) AddPath(path string) *TreeNode {
 	if path == "" {
-		return
+		return nil
 	}
 
 	parts := strings.Split(filepath.ToSlash(path), "/")
@@ -98,8 +251,126 @@ func (n *TreeNode) AddPath(path string) {
 		isDir := !isLast
 
 		if _, exists := current.Children[part]; !exists {
+			if n.maxNodes > 0 && n.nodeCount >= n.maxNodes {
+				n.Truncated = true
+				return NewTreeNode(parts[len(parts)-1], false)
+			}
+			n.nodeCount++
 			current.Children[part] = NewTreeNode(part, isDir)
+		} else if isDir {
+			// A node first added as a leaf (e.g. a GitHub "tree" entry) becomes a directory once it has children
+			current.Children[part].IsDir = true
 		}
 		current = current.Children[part]
 	}
+	return current
+}
+
+// AddSubtree copies the children of sub below a new directory at path and returns that directory.
+// Copied nodes count towards the root's node limit like AddPath: once it is reached, the remaining
+// nodes are dropped in path order and the root is marked Truncated. A truncated sub also marks the root.
+func (n *TreeNode) AddSubtree(path string, sub *TreeNode) *TreeNode {
+	dir := n.AddPath(path)
+	if dir == nil {
+		return nil
+	}
+	dir.IsDir = true
+	n.Truncated = n.Truncated || sub.Truncated
+	n.copyChildren(dir, sub)
+	return dir
+}
+
+// copyChildren copies the children of src below dst, counting them against the limit of root n
+func (n *TreeNode) copyChildren(dst, src *TreeNode) {
+	names := make([]string, 0, len(src.Children))
+	for name := range src.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if n.maxNodes > 0 && n.nodeCount >= n.maxNodes {
+			n.Truncated = true
+			return
+		}
+		n.nodeCount++
+		child := *src.Children[name]
+		child.Children = make(map[string]*TreeNode, len(child.Children))
+		dst.Children[name] = &child
+		n.copyChildren(&child, src.Children[name])
+	}
+}
+
+// FilePaths returns the slash-separated paths of all files in the tree, sorted
+func (n *TreeNode) FilePaths() []string {
+	var paths []string
+	n.collectFilePaths("", &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+// collectFilePaths recursively collects file paths under the node
+func (n *TreeNode) collectFilePaths(prefix string, paths *[]string) {
+	for name, child := range n.Children {
+		childPath := name
+		if prefix != "" {
+			childPath = prefix + "/" + name
+		}
+		if child.IsDir {
+			child.collectFilePaths(childPath, paths)
+		} else {
+			*paths = append(*paths, childPath)
+		}
+	}
+}
+
+// UpdateContentHash recomputes ContentHash from the per-file hashes in path order
+func (r *ProcessResult) UpdateContentHash() {
+	paths := make([]string, 0, len(r.FileContents))
+	for path := range r.FileContents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s\x00%s\n", path, r.FileContents[path].Hash)
+	}
+	r.ContentHash = hex.EncodeToString(h.Sum(nil))
+}
+
+// Preview returns a copy of the file whose Content is cut to at most maxBytes,
+// on a UTF-8 boundary for text and a 4-byte boundary for base64. A maxBytes of 0 means unlimited.
+func (f FileContent) Preview(maxBytes int) FileContent {
+	if maxBytes <= 0 || len(f.Content) <= maxBytes {
+		return f
+	}
+
+	cut := maxBytes
+	if f.IsBase64 {
+		cut -= cut % 4
+	} else {
+		for cut > 0 && !utf8.RuneStart(f.Content[cut]) {
+			cut--
+		}
+	}
+	f.FullSize = int64(len(f.Content))
+	f.Content = f.Content[:cut]
+	f.Truncated = true
+	return f
+}
+
+// WithPreview returns a shallow copy of the result with every file cut via Preview,
+// leaving the original untouched. A maxBytes of 0 returns the result itself.
+func (r *ProcessResult) WithPreview(maxBytes int) *ProcessResult {
+	if maxBytes <= 0 {
+		return r
+	}
+
+	preview := *r
+	preview.FileContents = make(map[string]FileContent, len(r.FileContents))
+	for path, content := range r.FileContents {
+		preview.FileContents[path] = content.Preview(maxBytes)
+	}
+	return &preview
 }